@@ -0,0 +1,10 @@
+DROP TRIGGER comment_votes_notify ON comment_votes;
+DROP TRIGGER post_votes_notify ON post_votes;
+DROP TRIGGER posts_notify ON posts;
+DROP TRIGGER comments_notify ON comments;
+
+DROP FUNCTION notify_comment_vote();
+DROP FUNCTION notify_post_vote();
+DROP FUNCTION notify_post();
+DROP FUNCTION notify_comment();
+DROP FUNCTION notify_event(TEXT, UUID, UUID, BIGINT);
@@ -0,0 +1,64 @@
+-- the changes of the posts and comments are announced on the goreddit_events channel, every server listens to it
+-- to push them to the browsers. NOTIFY is delivered on commit, so rolled back changes are never announced.
+CREATE FUNCTION notify_event(event_type TEXT, event_post UUID, event_comment UUID, event_votes BIGINT) RETURNS void AS $$
+DECLARE
+    event_thread UUID;
+BEGIN
+    SELECT thread_id INTO event_thread FROM posts WHERE id = event_post;
+    -- the post is gone together with what changed
+    IF event_thread IS NULL THEN
+        RETURN;
+    END IF;
+    PERFORM pg_notify('goreddit_events', json_build_object(
+        'type', event_type,
+        'thread_id', event_thread,
+        'post_id', event_post,
+        'comment_id', event_comment,
+        'votes', COALESCE(event_votes, 0)
+    )::text);
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION notify_comment() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        PERFORM notify_event('comment_created', NEW.post_id, NEW.id, NULL);
+    ELSE
+        PERFORM notify_event('comment_updated', NEW.post_id, NEW.id, NULL);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION notify_post() RETURNS trigger AS $$
+BEGIN
+    PERFORM notify_event('post_updated', NEW.id, NULL, NULL);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the scores are sums of the ledgers, so the votes announce the new sum
+CREATE FUNCTION notify_post_vote() RETURNS trigger AS $$
+DECLARE
+    voted UUID := CASE WHEN TG_OP = 'DELETE' THEN OLD.post_id ELSE NEW.post_id END;
+BEGIN
+    PERFORM notify_event('score_changed', voted, NULL,
+        (SELECT COALESCE(SUM(value), 0) FROM post_votes WHERE post_id = voted));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION notify_comment_vote() RETURNS trigger AS $$
+DECLARE
+    voted UUID := CASE WHEN TG_OP = 'DELETE' THEN OLD.comment_id ELSE NEW.comment_id END;
+BEGIN
+    PERFORM notify_event('score_changed', (SELECT post_id FROM comments WHERE id = voted), voted,
+        (SELECT COALESCE(SUM(value), 0) FROM comment_votes WHERE comment_id = voted));
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER comments_notify AFTER INSERT OR UPDATE ON comments FOR EACH ROW EXECUTE FUNCTION notify_comment();
+CREATE TRIGGER posts_notify AFTER UPDATE ON posts FOR EACH ROW EXECUTE FUNCTION notify_post();
+CREATE TRIGGER post_votes_notify AFTER INSERT OR UPDATE OR DELETE ON post_votes FOR EACH ROW EXECUTE FUNCTION notify_post_vote();
+CREATE TRIGGER comment_votes_notify AFTER INSERT OR UPDATE OR DELETE ON comment_votes FOR EACH ROW EXECUTE FUNCTION notify_comment_vote();
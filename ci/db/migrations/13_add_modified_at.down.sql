@@ -0,0 +1,20 @@
+DROP TRIGGER posts_notify ON posts;
+CREATE TRIGGER posts_notify AFTER UPDATE ON posts FOR EACH ROW EXECUTE FUNCTION notify_post();
+
+DROP TRIGGER users_touch ON users;
+DROP TRIGGER comment_votes_touch ON comment_votes;
+DROP TRIGGER post_votes_touch ON post_votes;
+DROP TRIGGER comments_touch ON comments;
+DROP TRIGGER posts_touch ON posts;
+DROP TRIGGER posts_modified ON posts;
+DROP TRIGGER threads_modified ON threads;
+
+DROP FUNCTION touch_author();
+DROP FUNCTION touch_comment_vote();
+DROP FUNCTION touch_post_vote();
+DROP FUNCTION touch_comment_post();
+DROP FUNCTION touch_post_thread();
+DROP FUNCTION touch_modified();
+
+ALTER TABLE posts DROP COLUMN modified_at;
+ALTER TABLE threads DROP COLUMN modified_at;
@@ -0,0 +1,81 @@
+-- modified_at tells when a thread or a post last changed together with everything below it, the pages validate
+-- their HTTP caches with it. The comments and the votes bump their post, the posts bump their thread.
+-- The hard deletes are left out: only the purge does them and what it removes was already hidden by the trash.
+ALTER TABLE threads ADD COLUMN modified_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE posts ADD COLUMN modified_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX threads_modified_at_idx ON threads (modified_at);
+
+-- the time of the change itself rather than the start of its transaction, and never twice the same time for a row,
+-- so a change is not hidden behind the one of a transaction which started later but committed first
+CREATE FUNCTION touch_modified() RETURNS trigger AS $$
+BEGIN
+    NEW.modified_at := clock_timestamp();
+    IF TG_OP = 'UPDATE' AND NEW.modified_at <= OLD.modified_at THEN
+        NEW.modified_at := OLD.modified_at + interval '1 microsecond';
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION touch_post_thread() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.thread_id <> NEW.thread_id THEN
+        UPDATE threads SET modified_at = clock_timestamp() WHERE id = OLD.thread_id;
+    END IF;
+    UPDATE threads SET modified_at = clock_timestamp() WHERE id = NEW.thread_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION touch_comment_post() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND OLD.post_id <> NEW.post_id THEN
+        UPDATE posts SET modified_at = clock_timestamp() WHERE id = OLD.post_id;
+    END IF;
+    UPDATE posts SET modified_at = clock_timestamp() WHERE id = NEW.post_id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION touch_post_vote() RETURNS trigger AS $$
+BEGIN
+    UPDATE posts SET modified_at = clock_timestamp()
+    WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.post_id ELSE NEW.post_id END;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION touch_comment_vote() RETURNS trigger AS $$
+BEGIN
+    UPDATE posts SET modified_at = clock_timestamp()
+    WHERE id = (SELECT post_id FROM comments WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.comment_id ELSE NEW.comment_id END);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- the pages show the usernames of the authors
+CREATE FUNCTION touch_author() RETURNS trigger AS $$
+BEGIN
+    UPDATE threads SET modified_at = clock_timestamp() WHERE author_id = NEW.id;
+    UPDATE posts SET modified_at = clock_timestamp()
+    WHERE author_id = NEW.id OR id IN (SELECT post_id FROM comments WHERE author_id = NEW.id);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER threads_modified BEFORE INSERT OR UPDATE ON threads FOR EACH ROW EXECUTE FUNCTION touch_modified();
+CREATE TRIGGER posts_modified BEFORE INSERT OR UPDATE ON posts FOR EACH ROW EXECUTE FUNCTION touch_modified();
+CREATE TRIGGER posts_touch AFTER INSERT OR UPDATE ON posts FOR EACH ROW EXECUTE FUNCTION touch_post_thread();
+CREATE TRIGGER comments_touch AFTER INSERT OR UPDATE ON comments FOR EACH ROW EXECUTE FUNCTION touch_comment_post();
+CREATE TRIGGER post_votes_touch AFTER INSERT OR UPDATE OR DELETE ON post_votes FOR EACH ROW EXECUTE FUNCTION touch_post_vote();
+CREATE TRIGGER comment_votes_touch AFTER INSERT OR UPDATE OR DELETE ON comment_votes FOR EACH ROW EXECUTE FUNCTION touch_comment_vote();
+CREATE TRIGGER users_touch AFTER UPDATE OF username ON users FOR EACH ROW
+    WHEN (OLD.username IS DISTINCT FROM NEW.username) EXECUTE FUNCTION touch_author();
+
+-- the bumps of modified_at are no edits, the pages must not hear about them as such
+DROP TRIGGER posts_notify ON posts;
+CREATE TRIGGER posts_notify AFTER UPDATE ON posts FOR EACH ROW
+    WHEN ((OLD.thread_id, OLD.author_id, OLD.title, OLD.content, OLD.updated_at, OLD.deleted_at)
+        IS DISTINCT FROM (NEW.thread_id, NEW.author_id, NEW.title, NEW.content, NEW.updated_at, NEW.deleted_at))
+    EXECUTE FUNCTION notify_post();
@@ -0,0 +1,13 @@
+DROP TRIGGER thread_moderators_touch ON thread_moderators;
+DROP FUNCTION touch_moderated_thread();
+
+DROP TABLE mod_actions;
+DROP TABLE thread_moderators;
+
+DROP INDEX posts_pinned_idx;
+ALTER TABLE posts DROP COLUMN pinned;
+ALTER TABLE threads DROP COLUMN locked;
+
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT false;
+UPDATE users SET admin = true WHERE role = 'admin';
+ALTER TABLE users DROP COLUMN role;
@@ -0,0 +1,43 @@
+-- the site role replaces the admin flag, admins are appointed with: UPDATE users SET role = 'admin' WHERE username = '...'
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin'));
+UPDATE users SET role = 'admin' WHERE admin;
+ALTER TABLE users DROP COLUMN admin;
+
+ALTER TABLE threads ADD COLUMN locked BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE posts ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX posts_pinned_idx ON posts (thread_id) WHERE pinned;
+
+-- the admins appoint the moderators of each thread
+CREATE TABLE thread_moderators (
+    thread_id UUID NOT NULL REFERENCES threads (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (thread_id, user_id)
+);
+
+CREATE INDEX thread_moderators_user_id_idx ON thread_moderators (user_id);
+
+-- the public log of what the moderators did, it outlives the moderators and the targets
+CREATE TABLE mod_actions (
+    id UUID PRIMARY KEY,
+    thread_id UUID NOT NULL REFERENCES threads (id) ON DELETE CASCADE,
+    moderator_id UUID REFERENCES users (id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    target_id UUID NOT NULL,
+    target TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX mod_actions_thread_id_idx ON mod_actions (thread_id, created_at DESC, id DESC);
+
+-- the thread page lists its moderators
+CREATE FUNCTION touch_moderated_thread() RETURNS trigger AS $$
+BEGIN
+    UPDATE threads SET modified_at = clock_timestamp()
+    WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.thread_id ELSE NEW.thread_id END;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER thread_moderators_touch AFTER INSERT OR DELETE ON thread_moderators FOR EACH ROW EXECUTE FUNCTION touch_moderated_thread();
@@ -0,0 +1,14 @@
+-- the password resets are mailed, an empty email means the user cannot reset the password
+ALTER TABLE users ADD COLUMN email TEXT NOT NULL DEFAULT '';
+
+-- a reset is a single use token mailed to the user, only the sha256 of the token is kept
+CREATE TABLE password_resets (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    hash BYTEA NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
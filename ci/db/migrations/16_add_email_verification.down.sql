@@ -0,0 +1,4 @@
+DROP TABLE email_verifications;
+
+DROP INDEX users_email_idx;
+ALTER TABLE users DROP COLUMN email_verified;
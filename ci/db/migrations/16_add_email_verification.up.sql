@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+
+-- the addresses are unique regardless of the case, many users may have none
+CREATE UNIQUE INDEX users_email_idx ON users (lower(email)) WHERE email <> '';
+
+-- the links mailed to verify the addresses, only the sha256 of their token is kept
+CREATE TABLE email_verifications (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    hash BYTEA NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX email_verifications_user_id_idx ON email_verifications (user_id, created_at DESC);
@@ -0,0 +1,7 @@
+-- the recent attempts to log in and to register by address and by username, shared by every instance of the site
+CREATE TABLE lockouts (
+    key TEXT PRIMARY KEY,
+    attempts INT NOT NULL DEFAULT 0,
+    locked_until TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
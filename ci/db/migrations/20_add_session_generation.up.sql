@@ -0,0 +1,2 @@
+-- the sessions remember the generation of their user at login, a password reset moves it on to end them all
+ALTER TABLE users ADD COLUMN session_generation INT NOT NULL DEFAULT 0;
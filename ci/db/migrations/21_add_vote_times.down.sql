@@ -0,0 +1,35 @@
+DROP TRIGGER users_voter_touch ON users;
+DROP FUNCTION touch_voter();
+
+DROP INDEX comment_votes_voted_at_idx;
+DROP INDEX post_votes_voted_at_idx;
+DROP INDEX comment_votes_comment_id_idx;
+DROP INDEX post_votes_post_id_idx;
+CREATE INDEX post_votes_post_id_idx ON post_votes (post_id);
+CREATE INDEX comment_votes_comment_id_idx ON comment_votes (comment_id);
+
+CREATE FUNCTION touch_post_vote() RETURNS trigger AS $$
+BEGIN
+    UPDATE posts SET modified_at = clock_timestamp()
+    WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.post_id ELSE NEW.post_id END;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION touch_comment_vote() RETURNS trigger AS $$
+BEGIN
+    UPDATE posts SET modified_at = clock_timestamp()
+    WHERE id = (SELECT post_id FROM comments WHERE id = CASE WHEN TG_OP = 'DELETE' THEN OLD.comment_id ELSE NEW.comment_id END);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER post_votes_touch AFTER INSERT OR UPDATE OR DELETE ON post_votes FOR EACH ROW EXECUTE FUNCTION touch_post_vote();
+CREATE TRIGGER comment_votes_touch AFTER INSERT OR UPDATE OR DELETE ON comment_votes FOR EACH ROW EXECUTE FUNCTION touch_comment_vote();
+
+-- the retracted votes are rows no more
+DELETE FROM comment_votes WHERE value = 0;
+DELETE FROM post_votes WHERE value = 0;
+
+ALTER TABLE comment_votes DROP COLUMN voted_at;
+ALTER TABLE post_votes DROP COLUMN voted_at;
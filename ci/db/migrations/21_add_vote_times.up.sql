@@ -0,0 +1,31 @@
+-- the votes keep their own time instead of bumping the modified_at of their post and thread: every vote of a busy
+-- thread wrote the same two rows, and the serializable transactions of the votes failed to serialize on them.
+-- The pages read the latest vote time together with modified_at. A retracted vote stays in the ledger with the
+-- value 0, so the time of the retraction is kept as well.
+ALTER TABLE post_votes ADD COLUMN voted_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp();
+ALTER TABLE comment_votes ADD COLUMN voted_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp();
+
+DROP TRIGGER comment_votes_touch ON comment_votes;
+DROP TRIGGER post_votes_touch ON post_votes;
+DROP FUNCTION touch_comment_vote();
+DROP FUNCTION touch_post_vote();
+
+DROP INDEX post_votes_post_id_idx;
+DROP INDEX comment_votes_comment_id_idx;
+CREATE INDEX post_votes_post_id_idx ON post_votes (post_id, voted_at);
+CREATE INDEX comment_votes_comment_id_idx ON comment_votes (comment_id, voted_at);
+CREATE INDEX post_votes_voted_at_idx ON post_votes (voted_at);
+CREATE INDEX comment_votes_voted_at_idx ON comment_votes (voted_at);
+
+-- the votes of a deleted user go with the user and leave no time behind, the posts they were on are bumped instead
+CREATE FUNCTION touch_voter() RETURNS trigger AS $$
+BEGIN
+    UPDATE posts SET modified_at = clock_timestamp()
+    WHERE id IN (SELECT post_id FROM post_votes WHERE user_id = OLD.id AND value <> 0)
+        OR id IN (SELECT comments.post_id FROM comment_votes JOIN comments ON comments.id = comment_votes.comment_id
+            WHERE comment_votes.user_id = OLD.id AND comment_votes.value <> 0);
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_voter_touch BEFORE DELETE ON users FOR EACH ROW EXECUTE FUNCTION touch_voter();
@@ -0,0 +1,20 @@
+CREATE OR REPLACE FUNCTION notify_event(event_type TEXT, event_post UUID, event_comment UUID, event_votes BIGINT) RETURNS void AS $$
+DECLARE
+    event_thread UUID;
+BEGIN
+    SELECT thread_id INTO event_thread FROM posts WHERE id = event_post;
+    -- the post is gone together with what changed
+    IF event_thread IS NULL THEN
+        RETURN;
+    END IF;
+    PERFORM pg_notify('goreddit_events', json_build_object(
+        'type', event_type,
+        'thread_id', event_thread,
+        'post_id', event_post,
+        'comment_id', event_comment,
+        'votes', COALESCE(event_votes, 0)
+    )::text);
+END;
+$$ LANGUAGE plpgsql;
+
+DROP SEQUENCE events_id_seq;
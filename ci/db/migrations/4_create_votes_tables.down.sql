@@ -0,0 +1,8 @@
+ALTER TABLE posts ADD COLUMN votes INT NOT NULL DEFAULT 0;
+ALTER TABLE comments ADD COLUMN votes INT NOT NULL DEFAULT 0;
+
+UPDATE posts SET votes = COALESCE((SELECT SUM(value) FROM post_votes WHERE post_votes.post_id = posts.id), 0);
+UPDATE comments SET votes = COALESCE((SELECT SUM(value) FROM comment_votes WHERE comment_votes.comment_id = comments.id), 0);
+
+DROP TABLE comment_votes;
+DROP TABLE post_votes;
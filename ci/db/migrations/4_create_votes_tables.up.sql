@@ -0,0 +1,21 @@
+CREATE TABLE post_votes (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    value SMALLINT NOT NULL CHECK (value BETWEEN -1 AND 1),
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX post_votes_post_id_idx ON post_votes (post_id);
+
+CREATE TABLE comment_votes (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    comment_id UUID NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    value SMALLINT NOT NULL CHECK (value BETWEEN -1 AND 1),
+    PRIMARY KEY (user_id, comment_id)
+);
+
+CREATE INDEX comment_votes_comment_id_idx ON comment_votes (comment_id);
+
+-- the score is now computed from the ledger
+ALTER TABLE posts DROP COLUMN votes;
+ALTER TABLE comments DROP COLUMN votes;
@@ -0,0 +1,3 @@
+ALTER TABLE comments DROP COLUMN author_id;
+ALTER TABLE posts DROP COLUMN author_id;
+ALTER TABLE threads DROP COLUMN author_id;
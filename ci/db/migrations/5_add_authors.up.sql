@@ -0,0 +1,8 @@
+-- content outlives its author, deleted users show up as [deleted]
+ALTER TABLE threads ADD COLUMN author_id UUID REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE posts ADD COLUMN author_id UUID REFERENCES users (id) ON DELETE SET NULL;
+ALTER TABLE comments ADD COLUMN author_id UUID REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE INDEX threads_author_id_idx ON threads (author_id);
+CREATE INDEX posts_author_id_idx ON posts (author_id);
+CREATE INDEX comments_author_id_idx ON comments (author_id);
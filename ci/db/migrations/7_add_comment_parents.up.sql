@@ -0,0 +1,4 @@
+-- replies are removed together with the comment they answer
+ALTER TABLE comments ADD COLUMN parent_id UUID REFERENCES comments (id) ON DELETE CASCADE;
+
+CREATE INDEX comments_parent_id_idx ON comments (parent_id);
@@ -0,0 +1,3 @@
+ALTER TABLE threads DROP COLUMN search;
+ALTER TABLE posts DROP COLUMN search;
+ALTER TABLE comments DROP COLUMN search;
@@ -0,0 +1,10 @@
+ALTER TABLE users DROP COLUMN admin;
+
+-- what is in the trash is gone after going back
+DELETE FROM comments WHERE deleted_at IS NOT NULL;
+DELETE FROM posts WHERE deleted_at IS NOT NULL;
+DELETE FROM threads WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE threads DROP COLUMN deleted_at;
+ALTER TABLE posts DROP COLUMN deleted_at;
+ALTER TABLE comments DROP COLUMN deleted_at;
@@ -0,0 +1,11 @@
+-- deleted content goes to the trash first, it is removed for good by the purge job once the retention is over
+ALTER TABLE threads ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE posts ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX threads_deleted_at_idx ON threads (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX posts_deleted_at_idx ON posts (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX comments_deleted_at_idx ON comments (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- admins manage the trash, they are appointed with: UPDATE users SET admin = true WHERE username = '...'
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT false;
@@ -11,6 +11,7 @@ require (
 	github.com/jmoiron/sqlx v1.3.5
 	github.com/lib/pq v1.4.0
 	golang.org/x/crypto v0.0.0-20220826181053-bd7e27e6170d
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -0,0 +1,159 @@
+// Package archive exports a thread with its posts, comments and votes to a portable JSON document and imports it back,
+// into the database it came from or into another one.
+package archive
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// Version is the version of the format written by Write, Read rejects the archives of other versions
+const Version = 1
+
+// Archive is a thread with everything below it. The authors and voters are listed in Users,
+// an import matches them by username since their ids differ from a database to another.
+type Archive struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Users      []User    `json:"users"`
+	Thread     Thread    `json:"thread"`
+	Posts      []Post    `json:"posts"`
+}
+
+type User struct {
+	ID       uuid.UUID `json:"id"`
+	Username string    `json:"username"`
+}
+
+// the author ids refer to Users, they are null for content whose author has been deleted
+
+type Thread struct {
+	ID          uuid.UUID     `json:"id"`
+	AuthorID    uuid.NullUUID `json:"author_id"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type Post struct {
+	ID        uuid.UUID     `json:"id"`
+	AuthorID  uuid.NullUUID `json:"author_id"`
+	Title     string        `json:"title"`
+	Content   string        `json:"content"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Votes     []Vote        `json:"votes,omitempty"`
+	// Comments lists every comment of the post, each after the comment it answers
+	Comments []Comment `json:"comments"`
+}
+
+type Comment struct {
+	ID       uuid.UUID     `json:"id"`
+	ParentID uuid.NullUUID `json:"parent_id"`
+	AuthorID uuid.NullUUID `json:"author_id"`
+	Content  string        `json:"content"`
+	// Deleted comments are only kept to hold their replies, without author nor content
+	Deleted   bool      `json:"deleted"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Votes     []Vote    `json:"votes,omitempty"`
+}
+
+type Vote struct {
+	UserID uuid.UUID `json:"user_id"`
+	Value  int       `json:"value"`
+}
+
+// Write encodes the archive as indented JSON
+func Write(w io.Writer, a *Archive) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+// Read decodes and checks an archive written by Write
+func Read(r io.Reader) (*Archive, error) {
+	var a Archive
+	if err := json.NewDecoder(r).Decode(&a); err != nil {
+		return nil, fmt.Errorf("error reading archive: %w", err)
+	}
+	if a.Version != Version {
+		return nil, fmt.Errorf("error reading archive: unsupported version %d, expected %d", a.Version, Version)
+	}
+	if err := a.check(); err != nil {
+		return nil, fmt.Errorf("error reading archive: %w", err)
+	}
+	return &a, nil
+}
+
+// check verifies the references within the archive, so an import does not fail half way through
+func (a *Archive) check() error {
+	users := map[uuid.UUID]bool{}
+	for _, u := range a.Users {
+		if u.Username == "" {
+			return fmt.Errorf("user %s has no username", u.ID)
+		}
+		users[u.ID] = true
+	}
+	checkUser := func(id uuid.NullUUID) error {
+		if id.Valid && !users[id.UUID] {
+			return fmt.Errorf("unknown user %s", id.UUID)
+		}
+		return nil
+	}
+	checkVotes := func(vv []Vote) error {
+		for _, v := range vv {
+			if err := checkUser(uuid.NullUUID{UUID: v.UserID, Valid: true}); err != nil {
+				return err
+			}
+			if v.Value != store.VoteDown && v.Value != store.VoteUp {
+				return fmt.Errorf("invalid vote %d", v.Value)
+			}
+		}
+		return nil
+	}
+
+	if a.Thread.ID == uuid.Nil {
+		return fmt.Errorf("the thread has no id")
+	}
+	if err := checkUser(a.Thread.AuthorID); err != nil {
+		return err
+	}
+	ids := map[uuid.UUID]bool{a.Thread.ID: true}
+	for _, p := range a.Posts {
+		if p.ID == uuid.Nil || ids[p.ID] {
+			return fmt.Errorf("missing or duplicate post id %s", p.ID)
+		}
+		ids[p.ID] = true
+		if err := checkUser(p.AuthorID); err != nil {
+			return err
+		}
+		if err := checkVotes(p.Votes); err != nil {
+			return err
+		}
+		comments := map[uuid.UUID]bool{}
+		for _, c := range p.Comments {
+			if c.ID == uuid.Nil || ids[c.ID] {
+				return fmt.Errorf("missing or duplicate comment id %s", c.ID)
+			}
+			if c.ParentID.Valid && !comments[c.ParentID.UUID] {
+				return fmt.Errorf("comment %s comes before its parent %s or answers a comment of another post", c.ID, c.ParentID.UUID)
+			}
+			ids[c.ID] = true
+			comments[c.ID] = true
+			if err := checkUser(c.AuthorID); err != nil {
+				return err
+			}
+			if err := checkVotes(c.Votes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+const (
+	// treeDepth is how deep the comment trees are read, deeper than any real discussion
+	treeDepth = 1000
+	// pageSize is how many top level comments are read at once
+	pageSize = 100
+)
+
+// Export reads the thread with its posts, comments and votes. The content in the trash is left out,
+// except the deleted comments holding replies which are kept blank like the pages show them.
+// Everything is read in a transaction, so the archive is consistent even while the thread is active.
+func Export(ctx context.Context, s store.Store, threadID uuid.UUID) (*Archive, error) {
+	var a *Archive
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		e := exporter{store: tx, users: map[uuid.UUID]bool{}}
+		var err error
+		a, err = e.export(ctx, threadID)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error exporting thread: %w", err)
+	}
+	return a, nil
+}
+
+type exporter struct {
+	store store.Store
+	a     Archive
+	// users tells which users are in the archive already, the ones deleted meanwhile are false
+	users map[uuid.UUID]bool
+}
+
+func (e *exporter) export(ctx context.Context, threadID uuid.UUID) (*Archive, error) {
+	t, err := e.store.Thread(ctx, threadID)
+	if err != nil {
+		return nil, err
+	}
+	if t.DeletedAt.Valid {
+		return nil, fmt.Errorf("thread %s is in the trash: %w", t.ID, sql.ErrNoRows)
+	}
+	e.a = Archive{
+		Version:    Version,
+		ExportedAt: time.Now().UTC(),
+		Users:      []User{},
+		Posts:      []Post{},
+	}
+	author, err := e.user(ctx, t.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+	e.a.Thread = Thread{
+		ID:          t.ID,
+		AuthorID:    author,
+		Title:       t.Title,
+		Description: t.Description,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+
+	pp, err := e.store.PostsByThread(ctx, t.ID, store.PostSort{Order: store.OrderNew})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uuid.UUID, len(pp))
+	for i, p := range pp {
+		ids[i] = p.ID
+	}
+	votes, err := e.votes(ctx, e.store.VotesOnPosts, ids)
+	if err != nil {
+		return nil, err
+	}
+	// the oldest first, like they were written
+	for i := len(pp) - 1; i >= 0; i-- {
+		p := pp[i]
+		author, err := e.user(ctx, p.AuthorID)
+		if err != nil {
+			return nil, err
+		}
+		cc, err := e.comments(ctx, p.ID)
+		if err != nil {
+			return nil, err
+		}
+		e.a.Posts = append(e.a.Posts, Post{
+			ID:        p.ID,
+			AuthorID:  author,
+			Title:     p.Title,
+			Content:   p.Content,
+			CreatedAt: p.CreatedAt,
+			UpdatedAt: p.UpdatedAt,
+			Votes:     votes[p.ID],
+			Comments:  cc,
+		})
+	}
+	return &e.a, nil
+}
+
+// comments reads the comment trees of the post and lists them each after its parent
+func (e *exporter) comments(ctx context.Context, postID uuid.UUID) ([]Comment, error) {
+	var tree []store.Comment
+	page := store.Page{Size: pageSize}
+	for {
+		roots, cursors, err := e.store.CommentTree(ctx, postID, page, treeDepth)
+		if err != nil {
+			return nil, err
+		}
+		tree = append(tree, roots...)
+		if cursors.Next == "" {
+			break
+		}
+		page.Cursor = cursors.Next
+	}
+
+	var flat []store.Comment
+	var walk func(cc []store.Comment)
+	walk = func(cc []store.Comment) {
+		for _, c := range cc {
+			flat = append(flat, c)
+			walk(c.Replies)
+		}
+	}
+	walk(tree)
+
+	ids := make([]uuid.UUID, len(flat))
+	for i, c := range flat {
+		ids[i] = c.ID
+	}
+	votes, err := e.votes(ctx, e.store.VotesOnComments, ids)
+	if err != nil {
+		return nil, err
+	}
+	cc := make([]Comment, len(flat))
+	for i, c := range flat {
+		cc[i] = Comment{
+			ID:        c.ID,
+			ParentID:  c.ParentID,
+			Deleted:   c.DeletedAt.Valid,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+			Votes:     votes[c.ID],
+		}
+		if !c.DeletedAt.Valid {
+			if cc[i].AuthorID, err = e.user(ctx, c.AuthorID); err != nil {
+				return nil, err
+			}
+			cc[i].Content = c.Content
+		}
+	}
+	return cc, nil
+}
+
+// votes reads the ledger of the targets, the votes of the users who are gone are left out
+func (e *exporter) votes(ctx context.Context, ledger func(context.Context, []uuid.UUID) ([]store.Vote, error), ids []uuid.UUID) (map[uuid.UUID][]Vote, error) {
+	vv, err := ledger(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byTarget := map[uuid.UUID][]Vote{}
+	for _, v := range vv {
+		voter, err := e.user(ctx, uuid.NullUUID{UUID: v.UserID, Valid: true})
+		if err != nil {
+			return nil, err
+		}
+		if voter.Valid {
+			byTarget[v.TargetID] = append(byTarget[v.TargetID], Vote{UserID: v.UserID, Value: v.Value})
+		}
+	}
+	return byTarget, nil
+}
+
+// user adds the user to the archive and returns the reference to it, which is null when the user does not exist
+func (e *exporter) user(ctx context.Context, id uuid.NullUUID) (uuid.NullUUID, error) {
+	if !id.Valid {
+		return id, nil
+	}
+	if found, ok := e.users[id.UUID]; ok {
+		if !found {
+			return uuid.NullUUID{}, nil
+		}
+		return id, nil
+	}
+	u, err := e.store.User(ctx, id.UUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		e.users[id.UUID] = false
+		return uuid.NullUUID{}, nil
+	}
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	e.users[u.ID] = true
+	e.a.Users = append(e.a.Users, User{ID: u.ID, Username: u.Username})
+	return id, nil
+}
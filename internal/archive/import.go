@@ -0,0 +1,278 @@
+package archive
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// Options tells Import what to do with the content and the users already in the store
+type Options struct {
+	// Merge adds the archive to the thread it was exported from when the store holds it: the posts and comments
+	// already there are kept as they are and only the missing ones are created. Without it a new thread is created.
+	Merge bool
+	// CreateUsers creates the authors and voters missing from the store, without a password so they cannot log in.
+	// Without it their content is attributed to nobody and their votes are dropped.
+	CreateUsers bool
+}
+
+// Result tells what an import did
+type Result struct {
+	// ThreadID is the thread imported or merged into
+	ThreadID uuid.UUID
+	// the posts, comments, votes and users created
+	Posts    int
+	Comments int
+	Votes    int
+	Users    int
+	// Kept counts the posts and comments merged with the ones already in the store
+	Kept int
+	// Renamed counts the items created with a new id because theirs was taken by something else
+	Renamed int
+	// MissingUsers counts the users who are not in the store and were not created
+	MissingUsers int
+}
+
+// Import recreates the archived thread in the store. The items keep their ids and their times unless
+// an id is taken, then a new one is generated. The users are matched by username.
+// Everything happens in a transaction, either the whole archive is imported or nothing is.
+func Import(ctx context.Context, s store.Store, a *Archive, opts Options) (Result, error) {
+	var res Result
+	err := s.WithTx(ctx, func(tx store.Store) error {
+		im := importer{
+			store:     tx,
+			opts:      opts,
+			usernames: map[uuid.UUID]string{},
+			users:     map[uuid.UUID]uuid.NullUUID{},
+		}
+		if err := im.run(ctx, a); err != nil {
+			return err
+		}
+		res = im.res
+		return nil
+	})
+	if err != nil {
+		return Result{}, fmt.Errorf("error importing thread: %w", err)
+	}
+	return res, nil
+}
+
+type importer struct {
+	store store.Store
+	opts  Options
+	// usernames by id of the archive
+	usernames map[uuid.UUID]string
+	// users maps the ids of the archive to the ones of the store, null for the missing users
+	users map[uuid.UUID]uuid.NullUUID
+	res   Result
+}
+
+func (im *importer) run(ctx context.Context, a *Archive) error {
+	for _, u := range a.Users {
+		im.usernames[u.ID] = u.Username
+	}
+
+	threadID, merge := a.Thread.ID, false
+	_, err := im.store.Thread(ctx, threadID)
+	switch {
+	case err == nil && im.opts.Merge:
+		merge = true
+	case err == nil:
+		threadID = uuid.New()
+		im.res.Renamed++
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	if !merge {
+		author, err := im.user(ctx, a.Thread.AuthorID)
+		if err != nil {
+			return err
+		}
+		if err := im.store.CreateThread(ctx, &store.Thread{
+			ID:          threadID,
+			AuthorID:    author,
+			Title:       a.Thread.Title,
+			Description: a.Thread.Description,
+			CreatedAt:   a.Thread.CreatedAt,
+			UpdatedAt:   a.Thread.UpdatedAt,
+		}); err != nil {
+			return err
+		}
+	}
+	im.res.ThreadID = threadID
+
+	for _, p := range a.Posts {
+		if err := im.post(ctx, p, threadID, merge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *importer) post(ctx context.Context, p Post, threadID uuid.UUID, merge bool) error {
+	postID, kept, err := im.postID(ctx, p.ID, threadID, merge)
+	if err != nil {
+		return err
+	}
+	if kept {
+		im.res.Kept++
+	} else {
+		author, err := im.user(ctx, p.AuthorID)
+		if err != nil {
+			return err
+		}
+		if err := im.store.CreatePost(ctx, &store.Post{
+			ID:        postID,
+			ThreadID:  threadID,
+			AuthorID:  author,
+			Title:     p.Title,
+			Content:   p.Content,
+			CreatedAt: p.CreatedAt,
+			UpdatedAt: p.UpdatedAt,
+		}); err != nil {
+			return err
+		}
+		im.res.Posts++
+		if err := im.votes(ctx, p.Votes, postID, im.store.VotePost); err != nil {
+			return err
+		}
+	}
+
+	// the comments come after their parent, so the parents are mapped already
+	ids := map[uuid.UUID]uuid.UUID{}
+	for _, c := range p.Comments {
+		commentID, keptComment, err := im.commentID(ctx, c.ID, postID, kept)
+		if err != nil {
+			return err
+		}
+		ids[c.ID] = commentID
+		if keptComment {
+			im.res.Kept++
+			continue
+		}
+
+		parentID := c.ParentID
+		if parentID.Valid {
+			parentID.UUID = ids[parentID.UUID]
+		}
+		author, err := im.user(ctx, c.AuthorID)
+		if err != nil {
+			return err
+		}
+		content := c.Content
+		if c.Deleted {
+			author, content = uuid.NullUUID{}, store.DeletedContent
+		}
+		if err := im.store.CreateComment(ctx, &store.Comment{
+			ID:        commentID,
+			PostID:    postID,
+			ParentID:  parentID,
+			AuthorID:  author,
+			Content:   content,
+			CreatedAt: c.CreatedAt,
+			UpdatedAt: c.UpdatedAt,
+		}); err != nil {
+			return err
+		}
+		if c.Deleted {
+			if err := im.store.DeleteComment(ctx, commentID); err != nil {
+				return err
+			}
+		}
+		im.res.Comments++
+		if err := im.votes(ctx, c.Votes, commentID, im.store.VoteComment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postID returns the id to create the post with, or the id of the post to keep when it is merged
+func (im *importer) postID(ctx context.Context, id, threadID uuid.UUID, merge bool) (uuid.UUID, bool, error) {
+	p, err := im.store.Post(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, false, nil
+	}
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	if merge && p.ThreadID == threadID {
+		return id, true, nil
+	}
+	im.res.Renamed++
+	return uuid.New(), false, nil
+}
+
+// commentID returns the id to create the comment with, or the id of the comment to keep when it is merged
+func (im *importer) commentID(ctx context.Context, id, postID uuid.UUID, merge bool) (uuid.UUID, bool, error) {
+	c, err := im.store.Comment(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, false, nil
+	}
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	if merge && c.PostID == postID {
+		return id, true, nil
+	}
+	im.res.Renamed++
+	return uuid.New(), false, nil
+}
+
+// votes casts the votes on the target, the ones of the missing users are dropped
+func (im *importer) votes(ctx context.Context, vv []Vote, targetID uuid.UUID, vote func(context.Context, uuid.UUID, uuid.UUID, int) error) error {
+	for _, v := range vv {
+		voter, err := im.user(ctx, uuid.NullUUID{UUID: v.UserID, Valid: true})
+		if err != nil {
+			return err
+		}
+		if !voter.Valid {
+			continue
+		}
+		if err := vote(ctx, voter.UUID, targetID, v.Value); err != nil {
+			return err
+		}
+		im.res.Votes++
+	}
+	return nil
+}
+
+// user returns the user of the store with the username of the archived user, creating it when the options say so
+func (im *importer) user(ctx context.Context, id uuid.NullUUID) (uuid.NullUUID, error) {
+	if !id.Valid {
+		return id, nil
+	}
+	if u, ok := im.users[id.UUID]; ok {
+		return u, nil
+	}
+
+	username := im.usernames[id.UUID]
+	var found uuid.NullUUID
+	u, err := im.store.UserByUsername(ctx, username)
+	switch {
+	case err == nil:
+		found = uuid.NullUUID{UUID: u.ID, Valid: true}
+	case !errors.Is(err, sql.ErrNoRows):
+		return uuid.NullUUID{}, err
+	case im.opts.CreateUsers:
+		u := store.User{ID: id.UUID, Username: username}
+		if _, err := im.store.User(ctx, u.ID); err == nil {
+			u.ID = uuid.New()
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return uuid.NullUUID{}, err
+		}
+		if err := im.store.CreateUser(ctx, &u); err != nil {
+			return uuid.NullUUID{}, err
+		}
+		im.res.Users++
+		found = uuid.NullUUID{UUID: u.ID, Valid: true}
+	default:
+		im.res.MissingUsers++
+	}
+	im.users[id.UUID] = found
+	return found, nil
+}
@@ -0,0 +1,233 @@
+package archive
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/memory"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// source is the database the archive comes from: a thread of alice with a post of hers voted by bob,
+// a comment of bob on it and a reply of alice voted by herself
+type source struct {
+	store          *memory.Store
+	alice, bob     store.User
+	thread         store.Thread
+	post           store.Post
+	comment, reply store.Comment
+	archive        *Archive
+}
+
+func newSource(t *testing.T) *source {
+	t.Helper()
+	ctx := context.Background()
+	s := &source{store: memory.NewStore()}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.alice = store.User{ID: uuid.New(), Username: "alice"}
+	must(s.store.CreateUser(ctx, &s.alice))
+	s.bob = store.User{ID: uuid.New(), Username: "bob"}
+	must(s.store.CreateUser(ctx, &s.bob))
+	s.thread = store.Thread{ID: uuid.New(), AuthorID: nullUUID(s.alice.ID), Title: "thread"}
+	must(s.store.CreateThread(ctx, &s.thread))
+	s.post = store.Post{ID: uuid.New(), ThreadID: s.thread.ID, AuthorID: nullUUID(s.alice.ID), Title: "post"}
+	must(s.store.CreatePost(ctx, &s.post))
+	must(s.store.VotePost(ctx, s.bob.ID, s.post.ID, store.VoteUp))
+	s.comment = store.Comment{ID: uuid.New(), PostID: s.post.ID, AuthorID: nullUUID(s.bob.ID), Content: "comment"}
+	must(s.store.CreateComment(ctx, &s.comment))
+	s.reply = store.Comment{ID: uuid.New(), PostID: s.post.ID, ParentID: nullUUID(s.comment.ID), AuthorID: nullUUID(s.alice.ID), Content: "reply"}
+	must(s.store.CreateComment(ctx, &s.reply))
+	must(s.store.VoteComment(ctx, s.alice.ID, s.reply.ID, store.VoteDown))
+
+	var err error
+	s.archive, err = Export(ctx, s.store, s.thread.ID)
+	must(err)
+	return s
+}
+
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: true}
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// target returns the database to import into
+		target func(t *testing.T, src *source) store.Store
+		// edit changes the archive before the import
+		edit func(src *source, a *Archive)
+		opts Options
+		want Result
+		// sameThread tells whether the thread keeps the id of the archive
+		sameThread bool
+		check      func(t *testing.T, src *source, s store.Store, res Result)
+	}{
+		{
+			name:       "empty database creating the users",
+			target:     func(*testing.T, *source) store.Store { return memory.NewStore() },
+			opts:       Options{CreateUsers: true},
+			want:       Result{Posts: 1, Comments: 2, Votes: 2, Users: 2},
+			sameThread: true,
+			check: func(t *testing.T, src *source, s store.Store, res Result) {
+				// the ids of the archive are free, everything keeps them
+				if _, err := s.Post(ctx, src.post.ID); err != nil {
+					t.Errorf("the post lost its id: %v", err)
+				}
+				c, err := s.Comment(ctx, src.reply.ID)
+				if err != nil {
+					t.Fatalf("the reply lost its id: %v", err)
+				}
+				if c.ParentID != nullUUID(src.comment.ID) {
+					t.Errorf("the reply answers %v, want %s", c.ParentID, src.comment.ID)
+				}
+			},
+		},
+		{
+			name:       "empty database without the users",
+			target:     func(*testing.T, *source) store.Store { return memory.NewStore() },
+			want:       Result{Posts: 1, Comments: 2, MissingUsers: 2},
+			sameThread: true,
+			check: func(t *testing.T, src *source, s store.Store, res Result) {
+				p, err := s.Post(ctx, src.post.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.AuthorID.Valid || p.Votes != 0 {
+					t.Errorf("the post has the author %v and %d votes, want none", p.AuthorID, p.Votes)
+				}
+			},
+		},
+		{
+			name:   "same database",
+			target: func(_ *testing.T, src *source) store.Store { return src.store },
+			want:   Result{Posts: 1, Comments: 2, Votes: 2, Renamed: 4},
+			check: func(t *testing.T, src *source, s store.Store, res Result) {
+				// the copy has new ids everywhere, the reply answers the copy of the comment
+				a, err := Export(ctx, s, res.ThreadID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p := a.Posts[0]
+				if p.ID == src.post.ID || p.Comments[0].ID == src.comment.ID || p.Comments[1].ID == src.reply.ID {
+					t.Error("the copy took ids of the original")
+				}
+				if p.Comments[1].ParentID != nullUUID(p.Comments[0].ID) {
+					t.Errorf("the reply of the copy answers %v, want %s", p.Comments[1].ParentID, p.Comments[0].ID)
+				}
+			},
+		},
+		{
+			name:       "same database merged",
+			target:     func(_ *testing.T, src *source) store.Store { return src.store },
+			opts:       Options{Merge: true},
+			want:       Result{Kept: 3},
+			sameThread: true,
+		},
+		{
+			name:   "same database merged with a new reply",
+			target: func(_ *testing.T, src *source) store.Store { return src.store },
+			edit: func(src *source, a *Archive) {
+				a.Posts[0].Comments = append(a.Posts[0].Comments, Comment{ID: uuid.New(), ParentID: nullUUID(src.comment.ID),
+					AuthorID: nullUUID(src.bob.ID), Content: "new", Votes: []Vote{{UserID: src.alice.ID, Value: store.VoteUp}}})
+			},
+			opts:       Options{Merge: true},
+			want:       Result{Comments: 1, Votes: 1, Kept: 3},
+			sameThread: true,
+		},
+		{
+			name: "other database with the ids of the post and the comment taken",
+			target: func(t *testing.T, src *source) store.Store {
+				s := memory.NewStore()
+				th := store.Thread{ID: uuid.New(), Title: "other"}
+				p := store.Post{ID: src.post.ID, ThreadID: th.ID, Title: "other"}
+				c := store.Comment{ID: src.comment.ID, PostID: p.ID, Content: "other"}
+				for _, err := range []error{s.CreateThread(ctx, &th), s.CreatePost(ctx, &p), s.CreateComment(ctx, &c)} {
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				return s
+			},
+			opts:       Options{CreateUsers: true, Merge: true},
+			want:       Result{Posts: 1, Comments: 2, Votes: 2, Users: 2, Renamed: 2},
+			sameThread: true,
+			check: func(t *testing.T, src *source, s store.Store, res Result) {
+				// the content there is left alone
+				if c, err := s.Comment(ctx, src.comment.ID); err != nil || c.Content != "other" {
+					t.Errorf("the comment there is %q, %v", c.Content, err)
+				}
+				a, err := Export(ctx, s, res.ThreadID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p := a.Posts[0]
+				if p.ID == src.post.ID || p.Comments[0].ID == src.comment.ID || p.Comments[1].ID != src.reply.ID {
+					t.Error("the ids taken were not replaced, or the free one was")
+				}
+				if p.Comments[1].ParentID != nullUUID(p.Comments[0].ID) {
+					t.Errorf("the reply answers %v, want %s", p.Comments[1].ParentID, p.Comments[0].ID)
+				}
+			},
+		},
+		{
+			name: "other database with the id of a user taken",
+			target: func(t *testing.T, src *source) store.Store {
+				s := memory.NewStore()
+				if err := s.CreateUser(ctx, &store.User{ID: src.alice.ID, Username: "carol"}); err != nil {
+					t.Fatal(err)
+				}
+				return s
+			},
+			opts:       Options{CreateUsers: true},
+			want:       Result{Posts: 1, Comments: 2, Votes: 2, Users: 2},
+			sameThread: true,
+			check: func(t *testing.T, src *source, s store.Store, res Result) {
+				alice, err := s.UserByUsername(ctx, "alice")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if alice.ID == src.alice.ID {
+					t.Error("alice took the id of carol")
+				}
+				if p, err := s.Post(ctx, src.post.ID); err != nil || p.AuthorID != nullUUID(alice.ID) {
+					t.Errorf("the post is by %v, want alice", p.AuthorID)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := newSource(t)
+			a := *src.archive
+			a.Posts = append([]Post(nil), a.Posts...)
+			if tt.edit != nil {
+				tt.edit(src, &a)
+			}
+			s := tt.target(t, src)
+
+			res, err := Import(ctx, s, &a, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.ThreadID == a.Thread.ID; got != tt.sameThread {
+				t.Errorf("the thread kept its id: %v, want %v", got, tt.sameThread)
+			}
+			tt.want.ThreadID = res.ThreadID
+			if res != tt.want {
+				t.Errorf("got %+v, want %+v", res, tt.want)
+			}
+			if tt.check != nil {
+				tt.check(t, src, s, res)
+			}
+		})
+	}
+}
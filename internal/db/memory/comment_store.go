@@ -0,0 +1,193 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// comment returns the stored comment with its computed fields, the caller has to hold the lock
+func (s *Store) comment(c store.Comment) store.Comment {
+	c.ThreadID = s.posts[c.PostID].ThreadID
+	c.AuthorName = s.authorName(c.AuthorID)
+	c.Votes, _, _ = score(s.commentVotes[c.ID])
+	return c
+}
+
+func commentCursor(c store.Comment) store.Cursor {
+	return store.Cursor{SortKey: float64(c.Votes), CreatedAt: c.CreatedAt, ID: c.ID}
+}
+
+// commentsWhere lists the comments matching keep sorted by votes, the caller has to hold the lock
+func (s *Store) commentsWhere(keep func(store.Comment) bool) []store.Comment {
+	var cc []store.Comment
+	for _, c := range s.comments {
+		if keep(c) {
+			cc = append(cc, s.comment(c))
+		}
+	}
+	store.SortComments(cc)
+	return cc
+}
+
+func (s *Store) CommentsByPost(ctx context.Context, postID uuid.UUID) ([]store.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.commentsWhere(func(c store.Comment) bool { return c.PostID == postID && !c.DeletedAt.Valid }), nil
+}
+
+func (s *Store) CommentsByPostPage(ctx context.Context, postID uuid.UUID, page store.Page) ([]store.Comment, store.Cursors, error) {
+	cc, _ := s.CommentsByPost(ctx, postID)
+	cc, cursors, err := paginate(cc, "", page, commentCursor)
+	if err != nil {
+		return []store.Comment{}, store.Cursors{}, fmt.Errorf("error getting comments: %w", err)
+	}
+	return cc, cursors, nil
+}
+
+// hasReplies reports whether some comment answers the comment, the caller has to hold the lock
+func (s *Store) hasReplies(id uuid.UUID) bool {
+	for _, c := range s.comments {
+		if c.ParentID.Valid && c.ParentID.UUID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// withReplies returns the comment with its replies nested up to maxDepth levels below it, the caller has to hold the lock
+func (s *Store) withReplies(c store.Comment, depth, maxDepth int) store.Comment {
+	replies := s.commentsWhere(func(r store.Comment) bool { return r.ParentID.Valid && r.ParentID.UUID == c.ID })
+	c.Depth = depth
+	c.RepliesCount = len(replies)
+	c.Replies = []store.Comment{}
+	if depth < maxDepth {
+		for _, r := range replies {
+			c.Replies = append(c.Replies, s.withReplies(r, depth+1, maxDepth))
+		}
+	}
+	return c
+}
+
+func (s *Store) CommentTree(ctx context.Context, postID uuid.UUID, page store.Page, maxDepth int) ([]store.Comment, store.Cursors, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	roots := s.commentsWhere(func(c store.Comment) bool {
+		return c.PostID == postID && !c.ParentID.Valid && (!c.DeletedAt.Valid || s.hasReplies(c.ID))
+	})
+	roots, cursors, err := paginate(roots, "", page, commentCursor)
+	if err != nil {
+		return []store.Comment{}, store.Cursors{}, fmt.Errorf("error getting comment tree: %w", err)
+	}
+	for i, c := range roots {
+		roots[i] = s.withReplies(c, 0, maxDepth)
+	}
+	return store.PruneDeleted(roots), cursors, nil
+}
+
+func (s *Store) CommentThread(ctx context.Context, id uuid.UUID, maxDepth int) (store.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return store.Comment{}, fmt.Errorf("error getting comment thread: %w", sql.ErrNoRows)
+	}
+	// a deleted comment is shown only to hold its replies
+	pruned := store.PruneDeleted([]store.Comment{s.withReplies(s.comment(c), 0, maxDepth)})
+	if len(pruned) == 0 {
+		return store.Comment{}, fmt.Errorf("error getting comment thread: %w", sql.ErrNoRows)
+	}
+	return pruned[0], nil
+}
+
+func (s *Store) Comment(ctx context.Context, id uuid.UUID) (store.Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.comments[id]
+	if !ok {
+		return store.Comment{}, fmt.Errorf("error getting comment: %w", sql.ErrNoRows)
+	}
+	return s.comment(c), nil
+}
+
+func (s *Store) CreateComment(ctx context.Context, c *store.Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.comments[c.ID]; ok {
+		return fmt.Errorf("error creating comment: duplicate id %s", c.ID)
+	}
+	if _, ok := s.posts[c.PostID]; !ok {
+		return fmt.Errorf("error creating comment: post %s does not exist", c.PostID)
+	}
+	if _, ok := s.comments[c.ParentID.UUID]; c.ParentID.Valid && !ok {
+		return fmt.Errorf("error creating comment: parent %s does not exist", c.ParentID.UUID)
+	}
+	if !s.authorExists(c.AuthorID) {
+		return fmt.Errorf("error creating comment: author %s does not exist", c.AuthorID.UUID)
+	}
+	c.CreatedAt, c.UpdatedAt = createdTimes(c.CreatedAt, c.UpdatedAt)
+	c.DeletedAt = sql.NullTime{}
+	c.Replies = nil
+	s.comments[c.ID] = *c
+	s.touchComment(c.ID)
+	*c = s.comment(*c)
+	s.publishComment(store.EventCommentCreated, c.ID)
+	return nil
+}
+
+func (s *Store) UpdateComment(ctx context.Context, c *store.Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	stored, ok := s.comments[c.ID]
+	if !ok {
+		return fmt.Errorf("error updating comment: %w", sql.ErrNoRows)
+	}
+	if _, ok := s.posts[c.PostID]; !ok {
+		return fmt.Errorf("error updating comment: post %s does not exist", c.PostID)
+	}
+	s.touchPost(stored.PostID)
+	stored.PostID = c.PostID
+	stored.Content = c.Content
+	stored.UpdatedAt = now()
+	s.comments[c.ID] = stored
+	s.touchComment(c.ID)
+	*c = s.comment(stored)
+	s.publishComment(store.EventCommentUpdated, c.ID)
+	return nil
+}
+
+func (s *Store) DeleteComment(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if c, ok := s.comments[id]; ok && !c.DeletedAt.Valid {
+		c.DeletedAt = sql.NullTime{Time: now(), Valid: true}
+		s.comments[id] = c
+		s.touchComment(id)
+		s.publishComment(store.EventCommentUpdated, id)
+	}
+	return nil
+}
+
+// deleteComment removes the comment with its replies and votes like ON DELETE CASCADE does, the caller has to hold the lock
+func (s *Store) deleteComment(id uuid.UUID) {
+	for _, c := range s.comments {
+		if c.ParentID.Valid && c.ParentID.UUID == id {
+			s.deleteComment(c.ID)
+		}
+	}
+	delete(s.commentVotes, id)
+	delete(s.comments, id)
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) EmailVerificationByHash(ctx context.Context, hash []byte) (store.EmailVerification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, v := range s.verifications {
+		if bytes.Equal(v.Hash, hash) {
+			return v, nil
+		}
+	}
+	return store.EmailVerification{}, fmt.Errorf("error getting email verification: %w", sql.ErrNoRows)
+}
+
+func (s *Store) LastEmailVerification(ctx context.Context, userID uuid.UUID) (store.EmailVerification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var last store.EmailVerification
+	found := false
+	for _, v := range s.verifications {
+		if v.UserID == userID && (!found || compareCursors(verificationCursor(v), verificationCursor(last)) > 0) {
+			last, found = v, true
+		}
+	}
+	if !found {
+		return store.EmailVerification{}, fmt.Errorf("error getting email verification: %w", sql.ErrNoRows)
+	}
+	return last, nil
+}
+
+func verificationCursor(v store.EmailVerification) store.Cursor {
+	return store.Cursor{CreatedAt: v.CreatedAt, ID: v.ID}
+}
+
+func (s *Store) CreateEmailVerification(ctx context.Context, v *store.EmailVerification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	t := now()
+	for _, other := range s.verifications {
+		if !t.Before(other.ExpiresAt) {
+			delete(s.verifications, other.ID)
+		}
+	}
+	if _, ok := s.verifications[v.ID]; ok {
+		return fmt.Errorf("error creating email verification: duplicate id %s", v.ID)
+	}
+	if _, ok := s.users[v.UserID]; !ok {
+		return fmt.Errorf("error creating email verification: user %s does not exist", v.UserID)
+	}
+	for _, other := range s.verifications {
+		if bytes.Equal(other.Hash, v.Hash) {
+			return fmt.Errorf("error creating email verification: duplicate hash")
+		}
+	}
+	v.CreatedAt = t
+	v.ExpiresAt = v.ExpiresAt.UTC().Truncate(time.Microsecond)
+	s.verifications[v.ID] = *v
+	return nil
+}
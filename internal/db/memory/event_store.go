@@ -0,0 +1,41 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) Subscribe(ctx context.Context, lastID string) (<-chan store.Event, bool) {
+	return s.events.Subscribe(ctx, lastID)
+}
+
+// publish announces a change of the post or of one of its comments like the triggers of the postgres store do,
+// inside a transaction the event waits for the commit. The caller has to hold the lock.
+func (s *Store) publish(t store.EventType, postID uuid.UUID, commentID uuid.NullUUID) {
+	p, ok := s.posts[postID]
+	if !ok {
+		return
+	}
+	e := store.Event{Type: t, ThreadID: p.ThreadID, PostID: postID, CommentID: commentID}
+	if t == store.EventScoreChanged {
+		if commentID.Valid {
+			e.Votes, _, _ = score(s.commentVotes[commentID.UUID])
+		} else {
+			e.Votes, _, _ = score(s.postVotes[postID])
+		}
+	}
+	if s.inTx {
+		s.pending = append(s.pending, e)
+		return
+	}
+	s.events.Publish(e)
+}
+
+// publishComment announces a change of the comment, the caller has to hold the lock
+func (s *Store) publishComment(t store.EventType, id uuid.UUID) {
+	if c, ok := s.comments[id]; ok {
+		s.publish(t, c.PostID, uuid.NullUUID{UUID: id, Valid: true})
+	}
+}
@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) IdentityBySubject(ctx context.Context, provider, subject string) (store.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, i := range s.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return i, nil
+		}
+	}
+	return store.Identity{}, fmt.Errorf("error getting identity: %w", sql.ErrNoRows)
+}
+
+func (s *Store) IdentitiesByUser(ctx context.Context, userID uuid.UUID) ([]store.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ii := []store.Identity{}
+	for _, i := range s.identities {
+		if i.UserID == userID {
+			ii = append(ii, i)
+		}
+	}
+	sort.Slice(ii, func(a, b int) bool { return ii[a].Provider < ii[b].Provider })
+	return ii, nil
+}
+
+func (s *Store) CreateIdentity(ctx context.Context, i *store.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.identities[i.ID]; ok {
+		return fmt.Errorf("error creating identity: duplicate id %s", i.ID)
+	}
+	if _, ok := s.users[i.UserID]; !ok {
+		return fmt.Errorf("error creating identity: user %s does not exist", i.UserID)
+	}
+	for _, other := range s.identities {
+		if other.Provider == i.Provider && other.Subject == i.Subject {
+			return fmt.Errorf("error creating identity: the %s account %s is linked already", i.Provider, i.Subject)
+		}
+		if other.Provider == i.Provider && other.UserID == i.UserID {
+			return fmt.Errorf("error creating identity: the user has a %s account already", i.Provider)
+		}
+	}
+	i.CreatedAt = now()
+	s.identities[i.ID] = *i
+	return nil
+}
+
+func (s *Store) DeleteIdentity(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	delete(s.identities, id)
+	return nil
+}
@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) Lockout(ctx context.Context, key string) (store.Lockout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.lockouts[key]
+	if !ok {
+		return store.Lockout{}, fmt.Errorf("error getting lockout: %w", sql.ErrNoRows)
+	}
+	return l, nil
+}
+
+func (s *Store) CountAttempt(ctx context.Context, key string, window time.Duration) (store.Lockout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	t := now()
+	for k, l := range s.lockouts {
+		if l.UpdatedAt.Before(t.Add(-window)) && !l.Locked(t) {
+			delete(s.lockouts, k)
+		}
+	}
+	l := s.lockouts[key]
+	l.Key = key
+	l.Attempts++
+	l.UpdatedAt = t
+	s.lockouts[key] = l
+	return l, nil
+}
+
+func (s *Store) LockOut(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if l, ok := s.lockouts[key]; ok {
+		l.LockedUntil = sql.NullTime{Time: until.UTC().Truncate(time.Microsecond), Valid: true}
+		s.lockouts[key] = l
+	}
+	return nil
+}
+
+func (s *Store) ClearLockout(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	delete(s.lockouts, key)
+	return nil
+}
+
+func (s *Store) Lockouts(ctx context.Context, at time.Time) ([]store.Lockout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ll := []store.Lockout{}
+	for _, l := range s.lockouts {
+		if l.Locked(at) {
+			ll = append(ll, l)
+		}
+	}
+	sort.Slice(ll, func(i, j int) bool {
+		if !ll[i].LockedUntil.Time.Equal(ll[j].LockedUntil.Time) {
+			return ll[i].LockedUntil.Time.After(ll[j].LockedUntil.Time)
+		}
+		return ll[i].Key < ll[j].Key
+	})
+	return ll, nil
+}
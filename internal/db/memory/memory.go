@@ -0,0 +1,251 @@
+// Package memory implements store.Store in memory, with the same semantics as the postgres store.
+// It is meant for tests and demos, everything is lost when the process stops.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewStore() *Store {
+	return &Store{
+		threads:       map[uuid.UUID]store.Thread{},
+		posts:         map[uuid.UUID]store.Post{},
+		comments:      map[uuid.UUID]store.Comment{},
+		users:         map[uuid.UUID]store.User{},
+		tokens:        map[uuid.UUID]store.Token{},
+		webhooks:      map[uuid.UUID]store.Webhook{},
+		deliveries:    map[uuid.UUID]store.WebhookDelivery{},
+		postVotes:     map[uuid.UUID]map[uuid.UUID]int{},
+		commentVotes:  map[uuid.UUID]map[uuid.UUID]int{},
+		modified:      map[uuid.UUID]time.Time{},
+		moderators:    map[uuid.UUID]map[uuid.UUID]bool{},
+		modActions:    map[uuid.UUID]store.ModAction{},
+		resets:        map[uuid.UUID]store.PasswordReset{},
+		verifications: map[uuid.UUID]store.EmailVerification{},
+		totps:         map[uuid.UUID]store.TOTP{},
+		recoveryCodes: map[uuid.UUID]store.RecoveryCode{},
+		identities:    map[uuid.UUID]store.Identity{},
+		lockouts:      map[string]store.Lockout{},
+		events:        store.NewHub(),
+	}
+}
+
+// Store keeps every entity in maps guarded by a single lock, so cascades happen atomically like in a transaction
+type Store struct {
+	mu       sync.RWMutex
+	threads  map[uuid.UUID]store.Thread
+	posts    map[uuid.UUID]store.Post
+	comments map[uuid.UUID]store.Comment
+	users    map[uuid.UUID]store.User
+	tokens   map[uuid.UUID]store.Token
+	webhooks map[uuid.UUID]store.Webhook
+	// deliveries are the queue and the log of the webhooks
+	deliveries map[uuid.UUID]store.WebhookDelivery
+	// votes by target id and then by user id
+	postVotes    map[uuid.UUID]map[uuid.UUID]int
+	commentVotes map[uuid.UUID]map[uuid.UUID]int
+	// modified tells when the threads and the posts last changed together with everything below them
+	modified map[uuid.UUID]time.Time
+	// moderators by thread id and then by user id
+	moderators map[uuid.UUID]map[uuid.UUID]bool
+	modActions map[uuid.UUID]store.ModAction
+	resets     map[uuid.UUID]store.PasswordReset
+	// verifications are the links mailed to verify the addresses of the users
+	verifications map[uuid.UUID]store.EmailVerification
+	// totps are the second factors by user id
+	totps         map[uuid.UUID]store.TOTP
+	recoveryCodes map[uuid.UUID]store.RecoveryCode
+	identities    map[uuid.UUID]store.Identity
+	// lockouts are the counters of the attempts by key
+	lockouts map[string]store.Lockout
+	// version counts the writes, WithTx uses it to detect conflicting ones
+	version uint64
+	// inTx is set on the copy a transaction works on
+	inTx bool
+	// events reach the subscribers right away, while the ones of a transaction wait in pending for the commit
+	events  *store.Hub
+	pending []store.Event
+}
+
+var _ store.Store = (*Store)(nil)
+
+// maxTxAttempts is how many times WithTx runs a transaction that keeps conflicting
+const maxTxAttempts = 5
+
+// WithTx runs fn against a copy of the store and swaps the copy in when fn returns nil.
+// When another write happened in the meantime the copy is stale, so fn runs again on a fresh one
+// like the postgres store retries serialization failures.
+func (s *Store) WithTx(ctx context.Context, fn func(store.Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		s.mu.RLock()
+		tx, version := s.clone(), s.version
+		s.mu.RUnlock()
+
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		if s.version == version {
+			s.threads, s.posts, s.comments, s.users, s.tokens = tx.threads, tx.posts, tx.comments, tx.users, tx.tokens
+			s.postVotes, s.commentVotes = tx.postVotes, tx.commentVotes
+			s.webhooks, s.deliveries = tx.webhooks, tx.deliveries
+			s.modified = tx.modified
+			s.moderators, s.modActions = tx.moderators, tx.modActions
+			s.resets, s.verifications = tx.resets, tx.verifications
+			s.totps, s.recoveryCodes = tx.totps, tx.recoveryCodes
+			s.identities, s.lockouts = tx.identities, tx.lockouts
+			s.version++
+			s.mu.Unlock()
+			for _, e := range tx.pending {
+				s.events.Publish(e)
+			}
+			return nil
+		}
+		s.mu.Unlock()
+	}
+	return fmt.Errorf("error running transaction after %d attempts: conflicting writes", maxTxAttempts)
+}
+
+// clone deep copies the data of the store for a transaction, the caller has to hold the lock
+func (s *Store) clone() *Store {
+	c := NewStore()
+	c.inTx = true
+	c.events = s.events
+	for id, t := range s.threads {
+		c.threads[id] = t
+	}
+	for id, p := range s.posts {
+		c.posts[id] = p
+	}
+	for id, cm := range s.comments {
+		c.comments[id] = cm
+	}
+	for id, u := range s.users {
+		c.users[id] = u
+	}
+	for id, t := range s.tokens {
+		c.tokens[id] = t
+	}
+	for id, w := range s.webhooks {
+		c.webhooks[id] = w
+	}
+	for id, d := range s.deliveries {
+		c.deliveries[id] = d
+	}
+	for id, t := range s.modified {
+		c.modified[id] = t
+	}
+	for threadID, users := range s.moderators {
+		c.moderators[threadID] = make(map[uuid.UUID]bool, len(users))
+		for id := range users {
+			c.moderators[threadID][id] = true
+		}
+	}
+	for id, a := range s.modActions {
+		c.modActions[id] = a
+	}
+	for id, r := range s.resets {
+		c.resets[id] = r
+	}
+	for id, v := range s.verifications {
+		c.verifications[id] = v
+	}
+	for id, t := range s.totps {
+		c.totps[id] = t
+	}
+	for id, rc := range s.recoveryCodes {
+		c.recoveryCodes[id] = rc
+	}
+	for id, i := range s.identities {
+		c.identities[id] = i
+	}
+	for key, l := range s.lockouts {
+		c.lockouts[key] = l
+	}
+	c.postVotes = cloneVotes(s.postVotes)
+	c.commentVotes = cloneVotes(s.commentVotes)
+	return c
+}
+
+func cloneVotes(votes map[uuid.UUID]map[uuid.UUID]int) map[uuid.UUID]map[uuid.UUID]int {
+	c := make(map[uuid.UUID]map[uuid.UUID]int, len(votes))
+	for target, byUser := range votes {
+		c[target] = make(map[uuid.UUID]int, len(byUser))
+		for user, v := range byUser {
+			c[target][user] = v
+		}
+	}
+	return c
+}
+
+// now returns the current time with the precision postgres stores
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// createdTimes returns the times of a new item, the ones already set are kept like the postgres store does
+func createdTimes(createdAt, updatedAt time.Time) (time.Time, time.Time) {
+	if createdAt.IsZero() {
+		createdAt = now()
+	}
+	if updatedAt.IsZero() {
+		updatedAt = createdAt
+	}
+	return createdAt, updatedAt
+}
+
+// authorName returns the username of the author or store.DeletedAuthor, the caller has to hold the lock
+func (s *Store) authorName(id uuid.NullUUID) string {
+	if u, ok := s.users[id.UUID]; ok && id.Valid {
+		return u.Username
+	}
+	return store.DeletedAuthor
+}
+
+// authorExists checks the author reference like the foreign key does, the caller has to hold the lock
+func (s *Store) authorExists(id uuid.NullUUID) bool {
+	_, ok := s.users[id.UUID]
+	return !id.Valid || ok
+}
+
+func score(votes map[uuid.UUID]int) (sum, ups, downs int) {
+	for _, v := range votes {
+		sum += v
+		if v > 0 {
+			ups++
+		} else if v < 0 {
+			downs++
+		}
+	}
+	return sum, ups, downs
+}
+
+// compareCursors orders positions of a listing like the keyset queries of the postgres store do
+func compareCursors(a, b store.Cursor) int {
+	switch {
+	case a.SortKey < b.SortKey:
+		return -1
+	case a.SortKey > b.SortKey:
+		return 1
+	case a.CreatedAt.Before(b.CreatedAt):
+		return -1
+	case a.CreatedAt.After(b.CreatedAt):
+		return 1
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: true}
+}
@@ -0,0 +1,140 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) Moderators(ctx context.Context, threadID uuid.UUID) ([]store.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	uu := []store.User{}
+	for id := range s.moderators[threadID] {
+		uu = append(uu, s.users[id])
+	}
+	sort.Slice(uu, func(i, j int) bool { return uu[i].Username < uu[j].Username })
+	return uu, nil
+}
+
+func (s *Store) AddModerator(ctx context.Context, threadID, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.threads[threadID]; !ok {
+		return fmt.Errorf("error adding moderator: thread %s does not exist", threadID)
+	}
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("error adding moderator: user %s does not exist", userID)
+	}
+	if s.moderators[threadID][userID] {
+		return nil
+	}
+	if s.moderators[threadID] == nil {
+		s.moderators[threadID] = map[uuid.UUID]bool{}
+	}
+	s.moderators[threadID][userID] = true
+	s.touchThread(threadID)
+	return nil
+}
+
+func (s *Store) RemoveModerator(ctx context.Context, threadID, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if s.moderators[threadID][userID] {
+		delete(s.moderators[threadID], userID)
+		s.touchThread(threadID)
+	}
+	return nil
+}
+
+func (s *Store) PinnedPosts(ctx context.Context, threadID uuid.UUID) ([]store.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pp := []store.Post{}
+	for _, p := range s.posts {
+		if p.ThreadID == threadID && p.Pinned && s.listed(p) {
+			pp = append(pp, s.post(p))
+		}
+	}
+	sort.Slice(pp, func(i, j int) bool {
+		return compareCursors(store.Cursor{CreatedAt: pp[i].CreatedAt, ID: pp[i].ID}, store.Cursor{CreatedAt: pp[j].CreatedAt, ID: pp[j].ID}) > 0
+	})
+	return pp, nil
+}
+
+func (s *Store) PinPost(ctx context.Context, id uuid.UUID, pinned bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if p, ok := s.posts[id]; ok {
+		p.Pinned = pinned
+		s.posts[id] = p
+		s.touchPost(id)
+	}
+	return nil
+}
+
+func (s *Store) LockThread(ctx context.Context, id uuid.UUID, locked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if t, ok := s.threads[id]; ok {
+		t.Locked = locked
+		s.threads[id] = t
+		s.touchThread(id)
+	}
+	return nil
+}
+
+func modActionCursor(a store.ModAction) store.Cursor {
+	return store.Cursor{CreatedAt: a.CreatedAt, ID: a.ID}
+}
+
+func (s *Store) ModLog(ctx context.Context, threadID uuid.UUID, page store.Page) ([]store.ModAction, store.Cursors, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var aa []store.ModAction
+	for _, a := range s.modActions {
+		if a.ThreadID == threadID {
+			a.ModeratorName = s.authorName(a.ModeratorID)
+			aa = append(aa, a)
+		}
+	}
+	aa, cc, err := paginate(aa, "", page, modActionCursor)
+	if err != nil {
+		return []store.ModAction{}, store.Cursors{}, fmt.Errorf("error getting mod log: %w", err)
+	}
+	return aa, cc, nil
+}
+
+func (s *Store) LogModAction(ctx context.Context, a *store.ModAction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.modActions[a.ID]; ok {
+		return fmt.Errorf("error logging mod action: duplicate id %s", a.ID)
+	}
+	if _, ok := s.threads[a.ThreadID]; !ok {
+		return fmt.Errorf("error logging mod action: thread %s does not exist", a.ThreadID)
+	}
+	if !s.authorExists(a.ModeratorID) {
+		return fmt.Errorf("error logging mod action: moderator %s does not exist", a.ModeratorID.UUID)
+	}
+	a.CreatedAt = now()
+	a.ModeratorName = s.authorName(a.ModeratorID)
+	s.modActions[a.ID] = *a
+	return nil
+}
@@ -0,0 +1,99 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// after returns the current time, or the time right after t when the clock has not moved past it yet
+func after(t time.Time) time.Time {
+	n := now()
+	if !n.After(t) {
+		n = t.Add(time.Microsecond)
+	}
+	return n
+}
+
+// touchThread records a change of the thread like the triggers of the postgres store do, the caller has to hold the lock
+func (s *Store) touchThread(id uuid.UUID) {
+	if _, ok := s.threads[id]; ok {
+		s.modified[id] = after(s.modified[id])
+	}
+}
+
+// touchPost records a change of the post and so of its thread, the caller has to hold the lock
+func (s *Store) touchPost(id uuid.UUID) {
+	if p, ok := s.posts[id]; ok {
+		s.modified[id] = after(s.modified[id])
+		s.touchThread(p.ThreadID)
+	}
+}
+
+// touchComment records a change below the post of the comment, the caller has to hold the lock
+func (s *Store) touchComment(id uuid.UUID) {
+	if c, ok := s.comments[id]; ok {
+		s.touchPost(c.PostID)
+	}
+}
+
+// touchAuthor records a change of everything showing the username of the user, the caller has to hold the lock
+func (s *Store) touchAuthor(id uuid.UUID) {
+	author := nullUUID(id)
+	for _, t := range s.threads {
+		if t.AuthorID == author {
+			s.touchThread(t.ID)
+		}
+	}
+	for _, p := range s.posts {
+		if p.AuthorID == author {
+			s.touchPost(p.ID)
+		}
+	}
+	for _, c := range s.comments {
+		if c.AuthorID == author {
+			s.touchPost(c.PostID)
+		}
+	}
+}
+
+func (s *Store) LastModified(ctx context.Context) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var last time.Time
+	for id := range s.threads {
+		if t := s.modified[id]; t.After(last) {
+			last = t
+		}
+	}
+	return last, nil
+}
+
+func (s *Store) ThreadModified(ctx context.Context, id uuid.UUID) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.threads[id]; !ok {
+		return time.Time{}, fmt.Errorf("error getting thread modification: %w", sql.ErrNoRows)
+	}
+	return s.modified[id], nil
+}
+
+func (s *Store) PostModified(ctx context.Context, id uuid.UUID) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.posts[id]
+	if !ok {
+		return time.Time{}, fmt.Errorf("error getting post modification: %w", sql.ErrNoRows)
+	}
+	modified := s.modified[id]
+	if t := s.threads[p.ThreadID].UpdatedAt; t.After(modified) {
+		modified = t
+	}
+	return modified, nil
+}
@@ -0,0 +1,65 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// paginate returns the page of the items, sorted by the position key returns, descending like the postgres listings
+func paginate[T any](items []T, order string, page store.Page, key func(T) store.Cursor) ([]T, store.Cursors, error) {
+	size := page.Size
+	if size <= 0 {
+		size = store.DefaultPageSize
+	}
+	var c store.Cursor
+	if page.Cursor != "" {
+		var err error
+		if c, err = store.ParseCursor(page.Cursor); err != nil {
+			return []T{}, store.Cursors{}, err
+		}
+		// a cursor of another sort order points to a meaningless position
+		if c.Order != order {
+			return []T{}, store.Cursors{}, store.ErrInvalidCursor
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return compareCursors(key(items[i]), key(items[j])) > 0
+	})
+
+	// the items strictly after the cursor, or strictly before it when going back
+	start, end := 0, len(items)
+	if page.Cursor != "" {
+		if c.Before {
+			end = sort.Search(len(items), func(i int) bool { return compareCursors(key(items[i]), c) <= 0 })
+		} else {
+			start = sort.Search(len(items), func(i int) bool { return compareCursors(key(items[i]), c) < 0 })
+		}
+	}
+
+	// going back there is always a next page, going forward there is a previous one unless this is the first page
+	hasPrev, hasNext := !c.Before && page.Cursor != "", c.Before
+	if c.Before && end-start > size {
+		start, hasPrev = end-size, true
+	} else if !c.Before && end-start > size {
+		end, hasNext = start+size, true
+	}
+	rows := append([]T{}, items[start:end]...)
+	if len(rows) == 0 {
+		return []T{}, store.Cursors{}, nil
+	}
+
+	var cc store.Cursors
+	if hasPrev {
+		prev := key(rows[0])
+		prev.Order, prev.Before = order, true
+		cc.Prev = prev.String()
+	}
+	if hasNext {
+		next := key(rows[len(rows)-1])
+		next.Order = order
+		cc.Next = next.String()
+	}
+	return rows, cc, nil
+}
@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) PasswordResetByHash(ctx context.Context, hash []byte) (store.PasswordReset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, r := range s.resets {
+		if bytes.Equal(r.Hash, hash) {
+			return r, nil
+		}
+	}
+	return store.PasswordReset{}, fmt.Errorf("error getting password reset: %w", sql.ErrNoRows)
+}
+
+func (s *Store) CreatePasswordReset(ctx context.Context, r *store.PasswordReset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	t := now()
+	for _, other := range s.resets {
+		if !t.Before(other.ExpiresAt) {
+			delete(s.resets, other.ID)
+		}
+	}
+	if _, ok := s.resets[r.ID]; ok {
+		return fmt.Errorf("error creating password reset: duplicate id %s", r.ID)
+	}
+	if _, ok := s.users[r.UserID]; !ok {
+		return fmt.Errorf("error creating password reset: user %s does not exist", r.UserID)
+	}
+	for _, other := range s.resets {
+		if bytes.Equal(other.Hash, r.Hash) {
+			return fmt.Errorf("error creating password reset: duplicate hash")
+		}
+	}
+	r.CreatedAt = t
+	r.ExpiresAt = r.ExpiresAt.UTC().Truncate(time.Microsecond)
+	r.UsedAt = sql.NullTime{}
+	s.resets[r.ID] = *r
+	return nil
+}
+
+func (s *Store) UsePasswordReset(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	t := now()
+	r, ok := s.resets[id]
+	if !ok || !r.Usable(t) {
+		return fmt.Errorf("error using password reset: %w", sql.ErrNoRows)
+	}
+	for _, other := range s.resets {
+		if other.UserID == r.UserID && !other.UsedAt.Valid {
+			other.UsedAt = sql.NullTime{Time: t, Valid: true}
+			s.resets[other.ID] = other
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// post returns the stored post with its computed fields, the caller has to hold the lock
+func (s *Store) post(p store.Post) store.Post {
+	p.AuthorName = s.authorName(p.AuthorID)
+	p.Votes, _, _ = score(s.postVotes[p.ID])
+	p.CommentsCount = 0
+	for _, c := range s.comments {
+		if c.PostID == p.ID && !c.DeletedAt.Valid {
+			p.CommentsCount++
+		}
+	}
+	return p
+}
+
+func epoch(t time.Time) float64 {
+	return float64(t.UnixMicro()) / 1e6
+}
+
+// postSortKey computes the sort key of the order with the same formulas as the postgres store
+func postSortKey(order store.PostOrder, p store.Post, ups, downs int) float64 {
+	switch order {
+	case store.OrderNew:
+		return epoch(p.CreatedAt)
+	case store.OrderTop:
+		return float64(p.Votes)
+	case store.OrderControversial:
+		if ups == 0 || downs == 0 {
+			return 0
+		}
+		return math.Pow(float64(ups+downs), math.Min(float64(ups), float64(downs))/math.Max(float64(ups), float64(downs)))
+	}
+	sign := 0.0
+	if p.Votes > 0 {
+		sign = 1
+	} else if p.Votes < 0 {
+		sign = -1
+	}
+	return sign*math.Log10(math.Max(math.Abs(float64(p.Votes)), 1)) + (epoch(p.CreatedAt)-1134028003)/45000
+}
+
+// sortedPost is a post together with the sort key used to list it
+type sortedPost struct {
+	store.Post
+	sortKey float64
+}
+
+func (p sortedPost) cursor() store.Cursor {
+	return store.Cursor{SortKey: p.sortKey, CreatedAt: p.CreatedAt, ID: p.ID}
+}
+
+// listed reports whether the post shows up in listings, which it does not while it or its thread are in the trash.
+// The caller has to hold the lock.
+func (s *Store) listed(p store.Post) bool {
+	return !p.DeletedAt.Valid && !s.threads[p.ThreadID].DeletedAt.Valid
+}
+
+// sortedPosts lists the posts of the thread, or of every thread when threadID is not valid, sorted like the listings
+func (s *Store) sortedPosts(threadID uuid.NullUUID, ps store.PostSort, withThreadTitle bool) []sortedPost {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var pp []sortedPost
+	for _, p := range s.posts {
+		if threadID.Valid && p.ThreadID != threadID.UUID || p.CreatedAt.Before(ps.Since) || !s.listed(p) {
+			continue
+		}
+		p = s.post(p)
+		if withThreadTitle {
+			p.ThreadTitle = s.threads[p.ThreadID].Title
+		}
+		_, ups, downs := score(s.postVotes[p.ID])
+		pp = append(pp, sortedPost{Post: p, sortKey: postSortKey(ps.Order, p, ups, downs)})
+	}
+	sort.Slice(pp, func(i, j int) bool {
+		return compareCursors(pp[i].cursor(), pp[j].cursor()) > 0
+	})
+	return pp
+}
+
+func unwrapPosts(sp []sortedPost) []store.Post {
+	pp := make([]store.Post, len(sp))
+	for i, p := range sp {
+		pp[i] = p.Post
+	}
+	return pp
+}
+
+func (s *Store) PostsByThread(ctx context.Context, threadID uuid.UUID, sort store.PostSort) ([]store.Post, error) {
+	return unwrapPosts(s.sortedPosts(uuid.NullUUID{UUID: threadID, Valid: true}, sort, false)), nil
+}
+
+func (s *Store) PostsByThreadPage(ctx context.Context, threadID uuid.UUID, sort store.PostSort, page store.Page) ([]store.Post, store.Cursors, error) {
+	sp, cc, err := paginate(s.sortedPosts(uuid.NullUUID{UUID: threadID, Valid: true}, sort, false), string(sort.Order), page, sortedPost.cursor)
+	if err != nil {
+		return []store.Post{}, store.Cursors{}, fmt.Errorf("error getting posts: %w", err)
+	}
+	return unwrapPosts(sp), cc, nil
+}
+
+func (s *Store) Posts(ctx context.Context, sort store.PostSort) ([]store.Post, error) {
+	return unwrapPosts(s.sortedPosts(uuid.NullUUID{}, sort, true)), nil
+}
+
+func (s *Store) PostsPage(ctx context.Context, sort store.PostSort, page store.Page) ([]store.Post, store.Cursors, error) {
+	sp, cc, err := paginate(s.sortedPosts(uuid.NullUUID{}, sort, true), string(sort.Order), page, sortedPost.cursor)
+	if err != nil {
+		return []store.Post{}, store.Cursors{}, fmt.Errorf("error getting posts: %w", err)
+	}
+	return unwrapPosts(sp), cc, nil
+}
+
+func (s *Store) Post(ctx context.Context, id uuid.UUID) (store.Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.posts[id]
+	if !ok {
+		return store.Post{}, fmt.Errorf("error getting post: %w", sql.ErrNoRows)
+	}
+	return s.post(p), nil
+}
+
+func (s *Store) CreatePost(ctx context.Context, p *store.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.posts[p.ID]; ok {
+		return fmt.Errorf("error creating post: duplicate id %s", p.ID)
+	}
+	if _, ok := s.threads[p.ThreadID]; !ok {
+		return fmt.Errorf("error creating post: thread %s does not exist", p.ThreadID)
+	}
+	if !s.authorExists(p.AuthorID) {
+		return fmt.Errorf("error creating post: author %s does not exist", p.AuthorID.UUID)
+	}
+	p.CreatedAt, p.UpdatedAt = createdTimes(p.CreatedAt, p.UpdatedAt)
+	p.Pinned = false
+	p.DeletedAt = sql.NullTime{}
+	s.posts[p.ID] = *p
+	s.touchPost(p.ID)
+	*p = s.post(*p)
+	return nil
+}
+
+func (s *Store) UpdatePost(ctx context.Context, p *store.Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	stored, ok := s.posts[p.ID]
+	if !ok {
+		return fmt.Errorf("error updating post: %w", sql.ErrNoRows)
+	}
+	if _, ok := s.threads[p.ThreadID]; !ok {
+		return fmt.Errorf("error updating post: thread %s does not exist", p.ThreadID)
+	}
+	// the thread the post leaves changes too
+	s.touchThread(stored.ThreadID)
+	stored.ThreadID = p.ThreadID
+	stored.Title = p.Title
+	stored.Content = p.Content
+	stored.UpdatedAt = now()
+	s.posts[p.ID] = stored
+	s.touchPost(p.ID)
+	*p = s.post(stored)
+	s.publish(store.EventPostUpdated, p.ID, uuid.NullUUID{})
+	return nil
+}
+
+func (s *Store) DeletePost(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if p, ok := s.posts[id]; ok && !p.DeletedAt.Valid {
+		p.DeletedAt = sql.NullTime{Time: now(), Valid: true}
+		s.posts[id] = p
+		s.touchPost(id)
+		s.publish(store.EventPostUpdated, id, uuid.NullUUID{})
+	}
+	return nil
+}
+
+// deletePost removes the post with its comments and votes like ON DELETE CASCADE does, the caller has to hold the lock
+func (s *Store) deletePost(id uuid.UUID) {
+	for _, c := range s.comments {
+		if c.PostID == id {
+			s.deleteComment(c.ID)
+		}
+	}
+	delete(s.postVotes, id)
+	delete(s.posts, id)
+	delete(s.modified, id)
+}
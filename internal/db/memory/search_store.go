@@ -0,0 +1,143 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// headlineWords is about how many words of the matching text a headline shows
+const headlineWords = 30
+
+// searchTerms splits the query into lower case words, without the punctuation postgres ignores as well
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// rank scores a text where every term has to appear, titles weigh more than the text below them like in postgres.
+// The memory store matches words by prefix instead of stemming them.
+func rank(terms []string, title, text string) float64 {
+	titleWords, textWords := searchTerms(title), searchTerms(text)
+	var score float64
+	for _, t := range terms {
+		n := 2*countPrefixed(titleWords, t) + countPrefixed(textWords, t)
+		if n == 0 {
+			return 0
+		}
+		score += float64(n)
+	}
+	return score / float64(len(titleWords)+len(textWords)+1)
+}
+
+// matches reports whether one of the words starts with one of the terms
+func matches(terms, words []string) bool {
+	for _, t := range terms {
+		if countPrefixed(words, t) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func countPrefixed(words []string, prefix string) int {
+	n := 0
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+// headline marks the matching words of the text and cuts it around the first of them
+func headline(terms []string, text string) string {
+	words := strings.Fields(text)
+	first := -1
+	for i, w := range words {
+		if !matches(terms, searchTerms(w)) {
+			continue
+		}
+		words[i] = store.HighlightStart + w + store.HighlightStop
+		if first < 0 {
+			first = i
+		}
+	}
+	start := 0
+	if first > headlineWords/2 {
+		start = first - headlineWords/2
+	}
+	end := start + headlineWords
+	if end > len(words) {
+		end = len(words)
+	}
+	return strings.Join(words[start:end], " ")
+}
+
+func searchCursor(r store.SearchResult) store.Cursor {
+	return store.Cursor{SortKey: r.Rank, CreatedAt: r.CreatedAt, ID: r.ID}
+}
+
+func (s *Store) Search(ctx context.Context, q store.SearchQuery, page store.Page) ([]store.SearchResult, store.Cursors, error) {
+	rr := s.search(q)
+	rr, cc, err := paginate(rr, "search", page, searchCursor)
+	if err != nil {
+		return []store.SearchResult{}, store.Cursors{}, fmt.Errorf("error searching: %w", err)
+	}
+	return rr, cc, nil
+}
+
+func (s *Store) search(q store.SearchQuery) []store.SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := searchTerms(q.Terms)
+	var rr []store.SearchResult
+	if len(terms) == 0 {
+		return rr
+	}
+	inThread := func(t store.Thread) bool { return !q.ThreadID.Valid || q.ThreadID.UUID == t.ID }
+	wanted := func(st store.ContentType) bool { return q.Type == "" || q.Type == st }
+
+	if wanted(store.ContentThread) {
+		for _, t := range s.threads {
+			if t.DeletedAt.Valid {
+				continue
+			}
+			if r := rank(terms, t.Title, t.Description); r > 0 && inThread(t) {
+				rr = append(rr, store.SearchResult{Type: store.ContentThread, ID: t.ID, ThreadID: t.ID, ThreadTitle: t.Title,
+					Title: t.Title, Headline: headline(terms, t.Description), AuthorName: s.authorName(t.AuthorID), Rank: r, CreatedAt: t.CreatedAt})
+			}
+		}
+	}
+	if wanted(store.ContentPost) {
+		for _, p := range s.posts {
+			t := s.threads[p.ThreadID]
+			if !s.listed(p) {
+				continue
+			}
+			if r := rank(terms, p.Title, p.Content); r > 0 && inThread(t) {
+				rr = append(rr, store.SearchResult{Type: store.ContentPost, ID: p.ID, ThreadID: t.ID, ThreadTitle: t.Title, PostID: nullUUID(p.ID),
+					Title: p.Title, Headline: headline(terms, p.Content), AuthorName: s.authorName(p.AuthorID), Rank: r, CreatedAt: p.CreatedAt})
+			}
+		}
+	}
+	if wanted(store.ContentComment) {
+		for _, c := range s.comments {
+			p := s.posts[c.PostID]
+			t := s.threads[p.ThreadID]
+			if c.DeletedAt.Valid || !s.listed(p) {
+				continue
+			}
+			if r := rank(terms, "", c.Content); r > 0 && inThread(t) {
+				rr = append(rr, store.SearchResult{Type: store.ContentComment, ID: c.ID, ThreadID: t.ID, ThreadTitle: t.Title, PostID: nullUUID(p.ID),
+					Title: p.Title, Headline: headline(terms, c.Content), AuthorName: s.authorName(c.AuthorID), Rank: r, CreatedAt: c.CreatedAt})
+			}
+		}
+	}
+	return rr
+}
@@ -0,0 +1,124 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// thread returns the stored thread with its computed fields, the caller has to hold the lock
+func (s *Store) thread(t store.Thread) store.Thread {
+	t.AuthorName = s.authorName(t.AuthorID)
+	return t
+}
+
+func threadCursor(t store.Thread) store.Cursor {
+	return store.Cursor{SortKey: epoch(t.CreatedAt), CreatedAt: t.CreatedAt, ID: t.ID}
+}
+
+func (s *Store) Threads(ctx context.Context) ([]store.Thread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tt := make([]store.Thread, 0, len(s.threads))
+	for _, t := range s.threads {
+		if !t.DeletedAt.Valid {
+			tt = append(tt, s.thread(t))
+		}
+	}
+	sort.Slice(tt, func(i, j int) bool {
+		return compareCursors(threadCursor(tt[i]), threadCursor(tt[j])) > 0
+	})
+	return tt, nil
+}
+
+func (s *Store) ThreadsPage(ctx context.Context, page store.Page) ([]store.Thread, store.Cursors, error) {
+	tt, _ := s.Threads(ctx)
+	tt, cc, err := paginate(tt, "", page, threadCursor)
+	if err != nil {
+		return []store.Thread{}, store.Cursors{}, fmt.Errorf("error getting threads: %w", err)
+	}
+	return tt, cc, nil
+}
+
+func (s *Store) Thread(ctx context.Context, id uuid.UUID) (store.Thread, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.threads[id]
+	if !ok {
+		return store.Thread{}, fmt.Errorf("error getting thread: %w", sql.ErrNoRows)
+	}
+	return s.thread(t), nil
+}
+
+func (s *Store) CreateThread(ctx context.Context, t *store.Thread) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.threads[t.ID]; ok {
+		return fmt.Errorf("error creating thread: duplicate id %s", t.ID)
+	}
+	if !s.authorExists(t.AuthorID) {
+		return fmt.Errorf("error creating thread: author %s does not exist", t.AuthorID.UUID)
+	}
+	t.CreatedAt, t.UpdatedAt = createdTimes(t.CreatedAt, t.UpdatedAt)
+	t.Locked = false
+	t.DeletedAt = sql.NullTime{}
+	s.threads[t.ID] = *t
+	s.touchThread(t.ID)
+	return nil
+}
+
+func (s *Store) UpdateThread(ctx context.Context, t *store.Thread) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	stored, ok := s.threads[t.ID]
+	if !ok {
+		return fmt.Errorf("error updating thread: %w", sql.ErrNoRows)
+	}
+	stored.Title = t.Title
+	stored.Description = t.Description
+	stored.UpdatedAt = now()
+	s.threads[t.ID] = stored
+	s.touchThread(t.ID)
+	*t = s.thread(stored)
+	return nil
+}
+
+func (s *Store) DeleteThread(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if t, ok := s.threads[id]; ok && !t.DeletedAt.Valid {
+		t.DeletedAt = sql.NullTime{Time: now(), Valid: true}
+		s.threads[id] = t
+		s.touchThread(id)
+	}
+	return nil
+}
+
+// deleteThread removes the thread with its posts like ON DELETE CASCADE does, the caller has to hold the lock
+func (s *Store) deleteThread(id uuid.UUID) {
+	for _, p := range s.posts {
+		if p.ThreadID == id {
+			s.deletePost(p.ID)
+		}
+	}
+	for _, a := range s.modActions {
+		if a.ThreadID == id {
+			delete(s.modActions, a.ID)
+		}
+	}
+	delete(s.moderators, id)
+	delete(s.threads, id)
+	delete(s.modified, id)
+}
@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) Token(ctx context.Context, id uuid.UUID) (store.Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.tokens[id]
+	if !ok {
+		return store.Token{}, fmt.Errorf("error getting token: %w", sql.ErrNoRows)
+	}
+	return t, nil
+}
+
+func (s *Store) TokenByHash(ctx context.Context, hash []byte) (store.Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, t := range s.tokens {
+		if bytes.Equal(t.Hash, hash) {
+			return t, nil
+		}
+	}
+	return store.Token{}, fmt.Errorf("error getting token: %w", sql.ErrNoRows)
+}
+
+func (s *Store) TokensByUser(ctx context.Context, userID uuid.UUID) ([]store.Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tt := []store.Token{}
+	for _, t := range s.tokens {
+		if t.UserID == userID {
+			tt = append(tt, t)
+		}
+	}
+	sort.Slice(tt, func(i, j int) bool {
+		if !tt[i].CreatedAt.Equal(tt[j].CreatedAt) {
+			return tt[i].CreatedAt.After(tt[j].CreatedAt)
+		}
+		return bytes.Compare(tt[i].ID[:], tt[j].ID[:]) > 0
+	})
+	return tt, nil
+}
+
+func (s *Store) CreateToken(ctx context.Context, t *store.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.tokens[t.ID]; ok {
+		return fmt.Errorf("error creating token: duplicate id %s", t.ID)
+	}
+	if _, ok := s.users[t.UserID]; !ok {
+		return fmt.Errorf("error creating token: user %s does not exist", t.UserID)
+	}
+	for _, other := range s.tokens {
+		if bytes.Equal(other.Hash, t.Hash) {
+			return fmt.Errorf("error creating token: duplicate hash")
+		}
+	}
+	t.CreatedAt = now()
+	t.LastUsedAt = sql.NullTime{}
+	s.tokens[t.ID] = *t
+	return nil
+}
+
+func (s *Store) TouchToken(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if t, ok := s.tokens[id]; ok {
+		t.LastUsedAt = sql.NullTime{Time: now(), Valid: true}
+		s.tokens[id] = t
+	}
+	return nil
+}
+
+func (s *Store) DeleteToken(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	delete(s.tokens, id)
+	return nil
+}
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func trashCursor(i store.TrashItem) store.Cursor {
+	return store.Cursor{SortKey: epoch(i.DeletedAt), CreatedAt: i.CreatedAt, ID: i.ID}
+}
+
+func (s *Store) Trash(ctx context.Context, page store.Page) ([]store.TrashItem, store.Cursors, error) {
+	ii, cc, err := paginate(s.trash(), "trash", page, trashCursor)
+	if err != nil {
+		return []store.TrashItem{}, store.Cursors{}, fmt.Errorf("error getting trash: %w", err)
+	}
+	return ii, cc, nil
+}
+
+func (s *Store) trash() []store.TrashItem {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ii []store.TrashItem
+	for _, t := range s.threads {
+		if t.DeletedAt.Valid {
+			ii = append(ii, store.TrashItem{Type: store.ContentThread, ID: t.ID, ThreadID: t.ID, Title: t.Title, Content: t.Description,
+				AuthorName: s.authorName(t.AuthorID), CreatedAt: t.CreatedAt, DeletedAt: t.DeletedAt.Time})
+		}
+	}
+	for _, p := range s.posts {
+		if p.DeletedAt.Valid {
+			ii = append(ii, store.TrashItem{Type: store.ContentPost, ID: p.ID, ThreadID: p.ThreadID, PostID: nullUUID(p.ID), Title: p.Title, Content: p.Content,
+				AuthorName: s.authorName(p.AuthorID), CreatedAt: p.CreatedAt, DeletedAt: p.DeletedAt.Time})
+		}
+	}
+	for _, c := range s.comments {
+		if c.DeletedAt.Valid {
+			p := s.posts[c.PostID]
+			ii = append(ii, store.TrashItem{Type: store.ContentComment, ID: c.ID, ThreadID: p.ThreadID, PostID: nullUUID(p.ID), Title: p.Title, Content: c.Content,
+				AuthorName: s.authorName(c.AuthorID), CreatedAt: c.CreatedAt, DeletedAt: c.DeletedAt.Time})
+		}
+	}
+	return ii
+}
+
+func (s *Store) Restore(ctx context.Context, t store.ContentType, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	restored := false
+	switch t {
+	case store.ContentThread:
+		if th, ok := s.threads[id]; ok && th.DeletedAt.Valid {
+			th.DeletedAt, restored = sql.NullTime{}, true
+			s.threads[id] = th
+			s.touchThread(id)
+		}
+	case store.ContentPost:
+		if p, ok := s.posts[id]; ok && p.DeletedAt.Valid {
+			p.DeletedAt, restored = sql.NullTime{}, true
+			s.posts[id] = p
+			s.touchPost(id)
+			s.publish(store.EventPostUpdated, id, uuid.NullUUID{})
+		}
+	case store.ContentComment:
+		if c, ok := s.comments[id]; ok && c.DeletedAt.Valid {
+			c.DeletedAt, restored = sql.NullTime{}, true
+			s.comments[id] = c
+			s.touchComment(id)
+			s.publishComment(store.EventCommentUpdated, id)
+		}
+	default:
+		return fmt.Errorf("error restoring %s: unknown content type", t)
+	}
+	if !restored {
+		return fmt.Errorf("error restoring %s: %w", t, sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (s *Store) Purge(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	// the comments staying keep their ancestors, deleting a comment takes its replies with it
+	purgeable := func(c store.Comment) bool { return c.DeletedAt.Valid && c.DeletedAt.Time.Before(before) }
+	kept := map[uuid.UUID]bool{}
+	for _, c := range s.comments {
+		if purgeable(c) {
+			continue
+		}
+		for id, ok := c.ID, true; ok && !kept[id]; {
+			kept[id] = true
+			parent := s.comments[id].ParentID
+			id, ok = parent.UUID, parent.Valid
+		}
+	}
+
+	// comments first, so the ones removed together with their post or thread are not counted twice
+	var purged int64
+	var ids []uuid.UUID
+	for _, c := range s.comments {
+		if purgeable(c) && !kept[c.ID] {
+			ids = append(ids, c.ID)
+		}
+	}
+	for _, id := range ids {
+		s.deleteComment(id)
+		purged++
+	}
+	for _, p := range s.posts {
+		if p.DeletedAt.Valid && p.DeletedAt.Time.Before(before) {
+			s.deletePost(p.ID)
+			purged++
+		}
+	}
+	for _, t := range s.threads {
+		if t.DeletedAt.Valid && t.DeletedAt.Time.Before(before) {
+			s.deleteThread(t.ID)
+			purged++
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,136 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func TestPurgeKeepsLiveReplies(t *testing.T) {
+	// the comments of the post: a, its reply b, the reply c of b, and d beside a
+	parents := map[string]string{"a": "", "b": "a", "c": "b", "d": ""}
+	ctx := context.Background()
+	before := time.Now()
+	old, recent := before.Add(-time.Hour), before.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		// deleted tells when the comments were moved to the trash
+		deleted map[string]time.Time
+		purged  int64
+		left    string
+	}{
+		{"nothing deleted", nil, 0, "a b c d"},
+		{"deleted parent with a live reply", map[string]time.Time{"a": old}, 0, "a b c d"},
+		{"deleted parent with a live reply below a deleted one", map[string]time.Time{"a": old, "b": old}, 0, "a b c d"},
+		{"deleted leaf", map[string]time.Time{"c": old}, 1, "a b d"},
+		{"deleted branch", map[string]time.Time{"b": old, "c": old}, 2, "a d"},
+		{"deleted tree", map[string]time.Time{"a": old, "b": old, "c": old}, 3, "d"},
+		{"deleted parent with a reply deleted after the time", map[string]time.Time{"b": old, "c": recent}, 0, "a b c d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore()
+			th := store.Thread{ID: uuid.New(), Title: "thread"}
+			if err := s.CreateThread(ctx, &th); err != nil {
+				t.Fatal(err)
+			}
+			p := store.Post{ID: uuid.New(), ThreadID: th.ID, Title: "post"}
+			if err := s.CreatePost(ctx, &p); err != nil {
+				t.Fatal(err)
+			}
+			ids := map[string]uuid.UUID{}
+			for _, name := range []string{"a", "b", "c", "d"} {
+				ids[name] = uuid.New()
+				c := store.Comment{ID: ids[name], PostID: p.ID, Content: name}
+				if parent := parents[name]; parent != "" {
+					c.ParentID = uuid.NullUUID{UUID: ids[parent], Valid: true}
+				}
+				if err := s.CreateComment(ctx, &c); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, at := range tt.deleted {
+				c := s.comments[ids[name]]
+				c.DeletedAt = sql.NullTime{Time: at, Valid: true}
+				s.comments[c.ID] = c
+			}
+
+			purged, err := s.Purge(ctx, before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purged != tt.purged {
+				t.Errorf("purged %d comments, want %d", purged, tt.purged)
+			}
+			var left []string
+			for _, c := range s.comments {
+				left = append(left, c.Content)
+			}
+			sort.Strings(left)
+			if got := strings.Join(left, " "); got != tt.left {
+				t.Errorf("left %q, want %q", got, tt.left)
+			}
+		})
+	}
+}
+
+func TestPurgeThreadsAndPosts(t *testing.T) {
+	ctx := context.Background()
+	before := time.Now()
+	old, recent := sql.NullTime{Time: before.Add(-time.Hour), Valid: true}, sql.NullTime{Time: before.Add(time.Hour), Valid: true}
+
+	tests := []struct {
+		name           string
+		thread, post   sql.NullTime
+		purged         int64
+		threads, posts int
+		comments       int
+	}{
+		{"nothing deleted", sql.NullTime{}, sql.NullTime{}, 0, 1, 1, 1},
+		{"post deleted before the time", sql.NullTime{}, old, 1, 1, 0, 0},
+		{"post deleted after the time", sql.NullTime{}, recent, 0, 1, 1, 1},
+		{"thread deleted before the time", old, sql.NullTime{}, 1, 0, 0, 0},
+		{"thread and post deleted before the time", old, old, 2, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore()
+			th := store.Thread{ID: uuid.New(), Title: "thread"}
+			if err := s.CreateThread(ctx, &th); err != nil {
+				t.Fatal(err)
+			}
+			p := store.Post{ID: uuid.New(), ThreadID: th.ID, Title: "post"}
+			if err := s.CreatePost(ctx, &p); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.CreateComment(ctx, &store.Comment{ID: uuid.New(), PostID: p.ID, Content: "comment"}); err != nil {
+				t.Fatal(err)
+			}
+			stored := s.threads[th.ID]
+			stored.DeletedAt = tt.thread
+			s.threads[th.ID] = stored
+			sp := s.posts[p.ID]
+			sp.DeletedAt = tt.post
+			s.posts[p.ID] = sp
+
+			purged, err := s.Purge(ctx, before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if purged != tt.purged {
+				t.Errorf("purged %d items, want %d", purged, tt.purged)
+			}
+			if len(s.threads) != tt.threads || len(s.posts) != tt.posts || len(s.comments) != tt.comments {
+				t.Errorf("left %d threads, %d posts and %d comments, want %d, %d and %d",
+					len(s.threads), len(s.posts), len(s.comments), tt.threads, tt.posts, tt.comments)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) TOTP(ctx context.Context, userID uuid.UUID) (store.TOTP, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.totps[userID]
+	if !ok {
+		return store.TOTP{}, fmt.Errorf("error getting totp: %w", sql.ErrNoRows)
+	}
+	return t, nil
+}
+
+func (s *Store) EnableTOTP(ctx context.Context, t *store.TOTP, codes []store.RecoveryCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.users[t.UserID]; !ok {
+		return fmt.Errorf("error enabling totp: user %s does not exist", t.UserID)
+	}
+	for i, c := range codes {
+		if _, ok := s.recoveryCodes[c.ID]; ok {
+			return fmt.Errorf("error enabling totp: duplicate id %s", c.ID)
+		}
+		for _, other := range codes[:i] {
+			if bytes.Equal(other.Hash, c.Hash) {
+				return fmt.Errorf("error enabling totp: duplicate recovery code")
+			}
+		}
+	}
+	s.disableTOTP(t.UserID)
+	t.CreatedAt = now()
+	s.totps[t.UserID] = *t
+	for i := range codes {
+		codes[i].CreatedAt = t.CreatedAt
+		codes[i].UsedAt = sql.NullTime{}
+		s.recoveryCodes[codes[i].ID] = codes[i]
+	}
+	return nil
+}
+
+func (s *Store) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	s.disableTOTP(userID)
+	return nil
+}
+
+// disableTOTP removes the second factor and the recovery codes of the user, the caller has to hold the lock
+func (s *Store) disableTOTP(userID uuid.UUID) {
+	delete(s.totps, userID)
+	for _, c := range s.recoveryCodes {
+		if c.UserID == userID {
+			delete(s.recoveryCodes, c.ID)
+		}
+	}
+}
+
+func (s *Store) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	t, ok := s.totps[userID]
+	if !ok || step <= t.LastStep {
+		return fmt.Errorf("error using totp step: %w", sql.ErrNoRows)
+	}
+	t.LastStep = step
+	s.totps[userID] = t
+	return nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	for _, c := range s.recoveryCodes {
+		if c.UserID == userID && !c.UsedAt.Valid && bytes.Equal(c.Hash, hash) {
+			c.UsedAt = sql.NullTime{Time: now(), Valid: true}
+			s.recoveryCodes[c.ID] = c
+			return nil
+		}
+	}
+	return fmt.Errorf("error using recovery code: %w", sql.ErrNoRows)
+}
+
+func (s *Store) RecoveryCodesLeft(ctx context.Context, userID uuid.UUID) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	for _, c := range s.recoveryCodes {
+		if c.UserID == userID && !c.UsedAt.Valid {
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,230 @@
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) User(ctx context.Context, id uuid.UUID) (store.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return store.User{}, fmt.Errorf("error getting user: %w", sql.ErrNoRows)
+	}
+	for threadID, users := range s.moderators {
+		if users[id] {
+			u.Moderates = append(u.Moderates, threadID)
+		}
+	}
+	return u, nil
+}
+
+func (s *Store) UserByUsername(ctx context.Context, username string) (store.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return store.User{}, fmt.Errorf("error getting user: %w", sql.ErrNoRows)
+}
+
+func (s *Store) UserByEmail(ctx context.Context, email string) (store.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, u := range s.users {
+		if u.Email != "" && strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return store.User{}, fmt.Errorf("error getting user: %w", sql.ErrNoRows)
+}
+
+func (s *Store) Users(ctx context.Context) ([]store.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	uu := make([]store.User, 0, len(s.users))
+	for _, u := range s.users {
+		uu = append(uu, u)
+	}
+	sort.Slice(uu, func(i, j int) bool { return uu[i].Username < uu[j].Username })
+	return uu, nil
+}
+
+// usernameTaken checks the unique constraint on usernames, the caller has to hold the lock
+func (s *Store) usernameTaken(username string, except uuid.UUID) bool {
+	for _, u := range s.users {
+		if u.Username == username && u.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+// emailTaken checks the unique index on the addresses, which ignores the case and the empty ones.
+// The caller has to hold the lock.
+func (s *Store) emailTaken(email string, except uuid.UUID) bool {
+	for _, u := range s.users {
+		if email != "" && strings.EqualFold(u.Email, email) && u.ID != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) CreateUser(ctx context.Context, u *store.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.users[u.ID]; ok {
+		return fmt.Errorf("error creating user: duplicate id %s", u.ID)
+	}
+	if s.usernameTaken(u.Username, u.ID) {
+		return fmt.Errorf("error creating user: username %q already exists", u.Username)
+	}
+	if s.emailTaken(u.Email, u.ID) {
+		return fmt.Errorf("error creating user: email %q already exists", u.Email)
+	}
+	if u.Role == "" {
+		u.Role = store.RoleUser
+	}
+	u.CreatedAt = now()
+	u.UpdatedAt = u.CreatedAt
+	u.Moderates = nil
+	s.users[u.ID] = *u
+	return nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, u *store.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	stored, ok := s.users[u.ID]
+	if !ok {
+		return fmt.Errorf("error updating user: %w", sql.ErrNoRows)
+	}
+	if s.usernameTaken(u.Username, u.ID) {
+		return fmt.Errorf("error updating user: username %q already exists", u.Username)
+	}
+	if s.emailTaken(u.Email, u.ID) {
+		return fmt.Errorf("error updating user: email %q already exists", u.Email)
+	}
+	if stored.Username != u.Username {
+		s.touchAuthor(u.ID)
+	}
+	stored.Username = u.Username
+	stored.Password = u.Password
+	stored.Email = u.Email
+	stored.EmailVerified = u.EmailVerified
+	stored.Role = u.Role
+	stored.UpdatedAt = now()
+	s.users[u.ID] = stored
+	*u = stored
+	return nil
+}
+
+func (s *Store) RevokeSessions(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if u, ok := s.users[id]; ok {
+		u.SessionGeneration++
+		s.users[id] = u
+	}
+	return nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.users[id]; !ok {
+		return nil
+	}
+	s.touchAuthor(id)
+	// the content of the user stays, attributed to nobody like ON DELETE SET NULL does
+	for _, t := range s.threads {
+		if t.AuthorID.Valid && t.AuthorID.UUID == id {
+			t.AuthorID = uuid.NullUUID{}
+			s.threads[t.ID] = t
+		}
+	}
+	for _, p := range s.posts {
+		if p.AuthorID.Valid && p.AuthorID.UUID == id {
+			p.AuthorID = uuid.NullUUID{}
+			s.posts[p.ID] = p
+		}
+	}
+	for _, c := range s.comments {
+		if c.AuthorID.Valid && c.AuthorID.UUID == id {
+			c.AuthorID = uuid.NullUUID{}
+			s.comments[c.ID] = c
+		}
+	}
+	// while the votes, the tokens, the password resets, the email verifications, the second factor
+	// and the identities go away with the user like ON DELETE CASCADE does
+	for postID, votes := range s.postVotes {
+		if _, ok := votes[id]; ok {
+			delete(votes, id)
+			s.touchPost(postID)
+		}
+	}
+	for commentID, votes := range s.commentVotes {
+		if _, ok := votes[id]; ok {
+			delete(votes, id)
+			s.touchComment(commentID)
+		}
+	}
+	for _, t := range s.tokens {
+		if t.UserID == id {
+			delete(s.tokens, t.ID)
+		}
+	}
+	for _, r := range s.resets {
+		if r.UserID == id {
+			delete(s.resets, r.ID)
+		}
+	}
+	for _, v := range s.verifications {
+		if v.UserID == id {
+			delete(s.verifications, v.ID)
+		}
+	}
+	s.disableTOTP(id)
+	for _, i := range s.identities {
+		if i.UserID == id {
+			delete(s.identities, i.ID)
+		}
+	}
+	for threadID, users := range s.moderators {
+		if users[id] {
+			delete(users, id)
+			s.touchThread(threadID)
+		}
+	}
+	// the mod log keeps what the user did as a moderator
+	for _, a := range s.modActions {
+		if a.ModeratorID.Valid && a.ModeratorID.UUID == id {
+			a.ModeratorID = uuid.NullUUID{}
+			s.modActions[a.ID] = a
+		}
+	}
+	delete(s.users, id)
+	return nil
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// userVotes returns the votes the user cast on the targets, the caller has to hold the lock
+func userVotes(votes map[uuid.UUID]map[uuid.UUID]int, userID uuid.UUID, targetIDs []uuid.UUID) map[uuid.UUID]int {
+	m := map[uuid.UUID]int{}
+	for _, id := range targetIDs {
+		if v, ok := votes[id][userID]; ok {
+			m[id] = v
+		}
+	}
+	return m
+}
+
+// ledger returns the votes cast on the targets by every user, the caller has to hold the lock
+func ledger(votes map[uuid.UUID]map[uuid.UUID]int, targetIDs []uuid.UUID) []store.Vote {
+	vv := []store.Vote{}
+	for _, id := range targetIDs {
+		for userID, v := range votes[id] {
+			vv = append(vv, store.Vote{UserID: userID, TargetID: id, Value: v})
+		}
+	}
+	return vv
+}
+
+// vote casts or changes the vote of the user on the target, the caller has to hold the lock
+func vote(votes map[uuid.UUID]map[uuid.UUID]int, userID, targetID uuid.UUID, value int) {
+	if votes[targetID] == nil {
+		votes[targetID] = map[uuid.UUID]int{}
+	}
+	votes[targetID][userID] = value
+}
+
+func (s *Store) PostVotes(ctx context.Context, userID uuid.UUID, postIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return userVotes(s.postVotes, userID, postIDs), nil
+}
+
+func (s *Store) VotePost(ctx context.Context, userID, postID uuid.UUID, value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if err := s.checkVote(userID, value); err != nil {
+		return fmt.Errorf("error voting post: %w", err)
+	}
+	if _, ok := s.posts[postID]; !ok {
+		return fmt.Errorf("error voting post: post %s does not exist", postID)
+	}
+	vote(s.postVotes, userID, postID, value)
+	s.touchPost(postID)
+	s.publish(store.EventScoreChanged, postID, uuid.NullUUID{})
+	return nil
+}
+
+func (s *Store) RetractPostVote(ctx context.Context, userID, postID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.postVotes[postID][userID]; ok {
+		delete(s.postVotes[postID], userID)
+		s.touchPost(postID)
+		s.publish(store.EventScoreChanged, postID, uuid.NullUUID{})
+	}
+	return nil
+}
+
+func (s *Store) CommentVotes(ctx context.Context, userID uuid.UUID, commentIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return userVotes(s.commentVotes, userID, commentIDs), nil
+}
+
+func (s *Store) VoteComment(ctx context.Context, userID, commentID uuid.UUID, value int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if err := s.checkVote(userID, value); err != nil {
+		return fmt.Errorf("error voting comment: %w", err)
+	}
+	if _, ok := s.comments[commentID]; !ok {
+		return fmt.Errorf("error voting comment: comment %s does not exist", commentID)
+	}
+	vote(s.commentVotes, userID, commentID, value)
+	s.touchComment(commentID)
+	s.publishComment(store.EventScoreChanged, commentID)
+	return nil
+}
+
+func (s *Store) RetractCommentVote(ctx context.Context, userID, commentID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.commentVotes[commentID][userID]; ok {
+		delete(s.commentVotes[commentID], userID)
+		s.touchComment(commentID)
+		s.publishComment(store.EventScoreChanged, commentID)
+	}
+	return nil
+}
+
+// checkVote enforces the constraints of the vote ledgers, the caller has to hold the lock
+func (s *Store) checkVote(userID uuid.UUID, value int) error {
+	if value < -1 || value > 1 {
+		return fmt.Errorf("vote value %d out of range", value)
+	}
+	if _, ok := s.users[userID]; !ok {
+		return fmt.Errorf("user %s does not exist", userID)
+	}
+	return nil
+}
+
+func (s *Store) VotesOnPosts(ctx context.Context, postIDs []uuid.UUID) ([]store.Vote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return ledger(s.postVotes, postIDs), nil
+}
+
+func (s *Store) VotesOnComments(ctx context.Context, commentIDs []uuid.UUID) ([]store.Vote, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return ledger(s.commentVotes, commentIDs), nil
+}
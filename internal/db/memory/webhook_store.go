@@ -0,0 +1,180 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func (s *Store) Webhooks(ctx context.Context) ([]store.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ww := make([]store.Webhook, 0, len(s.webhooks))
+	for _, w := range s.webhooks {
+		ww = append(ww, w)
+	}
+	sort.Slice(ww, func(i, j int) bool {
+		if !ww[i].CreatedAt.Equal(ww[j].CreatedAt) {
+			return ww[i].CreatedAt.Before(ww[j].CreatedAt)
+		}
+		return bytes.Compare(ww[i].ID[:], ww[j].ID[:]) < 0
+	})
+	return ww, nil
+}
+
+func (s *Store) Webhook(ctx context.Context, id uuid.UUID) (store.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	w, ok := s.webhooks[id]
+	if !ok {
+		return store.Webhook{}, fmt.Errorf("error getting webhook: %w", sql.ErrNoRows)
+	}
+	return w, nil
+}
+
+func (s *Store) CreateWebhook(ctx context.Context, w *store.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.webhooks[w.ID]; ok {
+		return fmt.Errorf("error creating webhook: duplicate id %s", w.ID)
+	}
+	w.CreatedAt = now()
+	w.UpdatedAt = w.CreatedAt
+	s.webhooks[w.ID] = *w
+	return nil
+}
+
+func (s *Store) UpdateWebhook(ctx context.Context, w *store.Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	stored, ok := s.webhooks[w.ID]
+	if !ok {
+		return fmt.Errorf("error updating webhook: %w", sql.ErrNoRows)
+	}
+	stored.URL = w.URL
+	stored.Secret = w.Secret
+	stored.Events = w.Events
+	stored.UpdatedAt = now()
+	s.webhooks[w.ID] = stored
+	*w = stored
+	return nil
+}
+
+func (s *Store) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	// the deliveries go away with the webhook like ON DELETE CASCADE does
+	for _, d := range s.deliveries {
+		if d.WebhookID == id {
+			delete(s.deliveries, d.ID)
+		}
+	}
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *Store) CreateDelivery(ctx context.Context, d *store.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	if _, ok := s.deliveries[d.ID]; ok {
+		return fmt.Errorf("error creating delivery: duplicate id %s", d.ID)
+	}
+	if _, ok := s.webhooks[d.WebhookID]; !ok {
+		return fmt.Errorf("error creating delivery: webhook %s does not exist", d.WebhookID)
+	}
+	d.CreatedAt = now()
+	if d.NextAttemptAt.IsZero() {
+		d.NextAttemptAt = d.CreatedAt
+	}
+	d.Status = store.DeliveryPending
+	d.Attempts = 0
+	d.LastAttemptAt = sql.NullTime{}
+	d.ResponseStatus = 0
+	d.Error = ""
+	s.deliveries[d.ID] = *d
+	return nil
+}
+
+func (s *Store) DeliveriesByWebhook(ctx context.Context, webhookID uuid.UUID, limit int) ([]store.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dd := []store.WebhookDelivery{}
+	for _, d := range s.deliveries {
+		if d.WebhookID == webhookID {
+			dd = append(dd, d)
+		}
+	}
+	sort.Slice(dd, func(i, j int) bool {
+		if !dd[i].CreatedAt.Equal(dd[j].CreatedAt) {
+			return dd[i].CreatedAt.After(dd[j].CreatedAt)
+		}
+		return bytes.Compare(dd[i].ID[:], dd[j].ID[:]) > 0
+	})
+	if len(dd) > limit {
+		dd = dd[:limit]
+	}
+	return dd, nil
+}
+
+func (s *Store) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]store.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := now()
+	dd := []store.WebhookDelivery{}
+	for _, d := range s.deliveries {
+		if d.Status == store.DeliveryPending && !d.NextAttemptAt.After(t) {
+			dd = append(dd, d)
+		}
+	}
+	sort.Slice(dd, func(i, j int) bool { return dd[i].NextAttemptAt.Before(dd[j].NextAttemptAt) })
+	if len(dd) > limit {
+		dd = dd[:limit]
+	}
+	// the sender polls all the time, only the claims which change something count as writes
+	if len(dd) > 0 {
+		s.version++
+	}
+	for i := range dd {
+		dd[i].NextAttemptAt = t.Add(lease)
+		s.deliveries[dd[i].ID] = dd[i]
+	}
+	return dd, nil
+}
+
+func (s *Store) UpdateDelivery(ctx context.Context, d *store.WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+
+	stored, ok := s.deliveries[d.ID]
+	if !ok {
+		return fmt.Errorf("error updating delivery: %w", sql.ErrNoRows)
+	}
+	stored.Status = d.Status
+	stored.Attempts = d.Attempts
+	stored.NextAttemptAt = d.NextAttemptAt
+	stored.LastAttemptAt = d.LastAttemptAt
+	stored.ResponseStatus = d.ResponseStatus
+	stored.Error = d.Error
+	s.deliveries[d.ID] = stored
+	*d = stored
+	return nil
+}
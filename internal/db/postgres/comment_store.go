@@ -1,61 +1,199 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
 )
 
-func NewCommentStore(db *sqlx.DB) *CommentStore {
+func NewCommentStore(db DB) *CommentStore {
 	return &CommentStore{DB: db}
 }
 
 type CommentStore struct {
-	*sqlx.DB
+	DB
 }
 
-func (s *CommentStore) CommentsByPost(postID uuid.UUID) ([]store.Comment, error) {
+// commentColumns selects a comment together with the thread of its post, its author name and its score from the comment_votes ledger
+const commentColumns = `
+	comments.id,
+	comments.post_id,
+	` + commentThread + `,
+	comments.parent_id,
+	comments.author_id,
+	COALESCE((SELECT username FROM users WHERE users.id = comments.author_id), '` + store.DeletedAuthor + `') AS author_name,
+	comments.content,
+	COALESCE((SELECT SUM(value) FROM comment_votes WHERE comment_votes.comment_id = comments.id), 0) AS votes,
+	comments.created_at,
+	comments.updated_at,
+	comments.deleted_at`
+
+// commentThread selects the thread of the post of a comment
+const commentThread = `(SELECT thread_id FROM posts WHERE posts.id = comments.post_id) AS thread_id`
+
+func (s *CommentStore) CommentsByPost(ctx context.Context, postID uuid.UUID) ([]store.Comment, error) {
 	var c []store.Comment
-	if err := s.Select(&c, "SELECT * FROM comments WHERE post_id = $1 ORDER BY votes DESC", postID); err != nil {
+	if err := s.SelectContext(ctx, &c, "SELECT "+commentColumns+" FROM comments WHERE post_id = $1 AND deleted_at IS NULL ORDER BY votes DESC, created_at DESC, id DESC", postID); err != nil {
 		return []store.Comment{}, fmt.Errorf("error getting comments: %w", err)
 	}
 	return c, nil
 }
 
-func (s *CommentStore) Comment(id uuid.UUID) (store.Comment, error) {
+// sortedComment is a comment together with the sort key used to paginate it
+type sortedComment struct {
+	store.Comment
+	SortKey float64 `db:"sort_key"`
+}
+
+func (c sortedComment) cursor() store.Cursor {
+	return store.Cursor{SortKey: c.SortKey, CreatedAt: c.CreatedAt, ID: c.ID}
+}
+
+// CommentsByPostPage lists the comments of the post by votes like CommentsByPost
+func (s *CommentStore) CommentsByPostPage(ctx context.Context, postID uuid.UUID, page store.Page) ([]store.Comment, store.Cursors, error) {
+	var query = `
+		SELECT *, votes::float AS sort_key
+		FROM (
+			SELECT ` + commentColumns + `
+			FROM comments
+			WHERE post_id = $1 AND deleted_at IS NULL
+		) comments`
+	sc, cc, err := selectPage(ctx, s.DB, query, []interface{}{postID}, "", page, sortedComment.cursor)
+	if err != nil {
+		return []store.Comment{}, store.Cursors{}, fmt.Errorf("error getting comments: %w", err)
+	}
+	c := make([]store.Comment, len(sc))
+	for i, sc := range sc {
+		c[i] = sc.Comment
+	}
+	return c, cc, nil
+}
+
+// commentTreeQuery selects the roots selected by the roots query, which has to select an id column, together with
+// their replies up to the depth given by the parameter number depthParam
+func commentTreeQuery(roots string, depthParam int) string {
+	return fmt.Sprintf(`
+		WITH RECURSIVE tree (id, depth) AS (
+			SELECT id, 0 FROM (%s) roots
+			UNION ALL
+			SELECT comments.id, tree.depth + 1
+			FROM comments
+			JOIN tree ON comments.parent_id = tree.id
+			WHERE tree.depth < $%d
+		)
+		SELECT %s,
+			tree.depth,
+			(SELECT COUNT(*) FROM comments replies WHERE replies.parent_id = comments.id) AS replies_count
+		FROM tree
+		JOIN comments ON comments.id = tree.id`, roots, depthParam, commentColumns)
+}
+
+func commentCursor(c store.Comment) store.Cursor {
+	return store.Cursor{SortKey: float64(c.Votes), CreatedAt: c.CreatedAt, ID: c.ID}
+}
+
+func (s *CommentStore) CommentTree(ctx context.Context, postID uuid.UUID, page store.Page, maxDepth int) ([]store.Comment, store.Cursors, error) {
+	k, err := newKeyset("", page)
+	if err != nil {
+		return []store.Comment{}, store.Cursors{}, fmt.Errorf("error getting comment tree: %w", err)
+	}
+	// the roots are a page of the top level comments
+	roots, args := k.wrap(`
+		SELECT *, votes::float AS sort_key
+		FROM (
+			SELECT `+commentColumns+`
+			FROM comments
+			WHERE post_id = $1 AND parent_id IS NULL
+				AND (deleted_at IS NULL OR EXISTS (SELECT 1 FROM comments replies WHERE replies.parent_id = comments.id))
+		) comments`, []interface{}{postID})
+	args = append(args, maxDepth)
+
+	var cc []store.Comment
+	if err := s.SelectContext(ctx, &cc, commentTreeQuery(roots, len(args)), args...); err != nil {
+		return []store.Comment{}, store.Cursors{}, fmt.Errorf("error getting comment tree: %w", err)
+	}
+
+	// put the roots back in the order of the keyset query to trim the page
+	var top, replies []store.Comment
+	for _, c := range cc {
+		if c.Depth == 0 {
+			top = append(top, c)
+		} else {
+			replies = append(replies, c)
+		}
+	}
+	store.SortComments(top)
+	if k.cursor.Before {
+		for i, j := 0, len(top)-1; i < j; i, j = i+1, j-1 {
+			top[i], top[j] = top[j], top[i]
+		}
+	}
+	top, cursors := trimPage(k, top, commentCursor)
+
+	// the replies of the root trimmed from the page come out as roots too, so they are skipped
+	var tree []store.Comment
+	for _, c := range store.NestComments(append(top, replies...)) {
+		if c.Depth == 0 {
+			tree = append(tree, c)
+		}
+	}
+	return store.PruneDeleted(tree), cursors, nil
+}
+
+func (s *CommentStore) CommentThread(ctx context.Context, id uuid.UUID, maxDepth int) (store.Comment, error) {
+	var cc []store.Comment
+	if err := s.SelectContext(ctx, &cc, commentTreeQuery("SELECT id FROM comments WHERE id = $1", 2), id, maxDepth); err != nil {
+		return store.Comment{}, fmt.Errorf("error getting comment thread: %w", err)
+	}
+	for _, c := range store.NestComments(cc) {
+		if c.ID != id {
+			continue
+		}
+		// a deleted comment is shown only to hold its replies
+		if pruned := store.PruneDeleted([]store.Comment{c}); len(pruned) > 0 {
+			return pruned[0], nil
+		}
+	}
+	return store.Comment{}, fmt.Errorf("error getting comment thread: %w", sql.ErrNoRows)
+}
+
+func (s *CommentStore) Comment(ctx context.Context, id uuid.UUID) (store.Comment, error) {
 	var c store.Comment
-	if err := s.Get(&c, "SELECT * FROM comments WHERE id = $1", id); err != nil {
+	if err := s.GetContext(ctx, &c, "SELECT "+commentColumns+" FROM comments WHERE id = $1", id); err != nil {
 		return store.Comment{}, fmt.Errorf("error getting comment: %w", err)
 	}
 	return c, nil
 }
 
-func (s *CommentStore) CreateComment(c *store.Comment) error {
-	if err := s.Get(c, "INSERT INTO comments VALUES ($1, $2, $3, $4) RETURNING *",
+func (s *CommentStore) CreateComment(ctx context.Context, c *store.Comment) error {
+	if err := s.GetContext(ctx, c, "INSERT INTO comments (id, post_id, parent_id, author_id, content, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()), COALESCE($7, $6, now())) RETURNING id, post_id, "+commentThread+", parent_id, author_id, content, created_at, updated_at, deleted_at",
 		c.ID,
 		c.PostID,
+		c.ParentID,
+		c.AuthorID,
 		c.Content,
-		c.Votes); err != nil {
+		nullTime(c.CreatedAt),
+		nullTime(c.UpdatedAt)); err != nil {
 		return fmt.Errorf("error creating comment: %w", err)
 	}
 	return nil
 }
 
-func (s *CommentStore) UpdateComment(c *store.Comment) error {
-	if err := s.Get(c, "UPDATE comments SET post_id = $1, content = $2, votes = $3 WHERE id = $4 RETURNING *",
+func (s *CommentStore) UpdateComment(ctx context.Context, c *store.Comment) error {
+	if err := s.GetContext(ctx, c, "UPDATE comments SET post_id = $1, content = $2, updated_at = now() WHERE id = $3 RETURNING id, post_id, "+commentThread+", parent_id, author_id, content, created_at, updated_at, deleted_at",
 		c.PostID,
 		c.Content,
-		c.Votes,
 		c.ID); err != nil {
 		return fmt.Errorf("error updating comment: %w", err)
 	}
 	return nil
 }
 
-func (s *CommentStore) DeleteComment(id uuid.UUID) error {
-	if _, err := s.Exec("DELETE FROM comments WHERE id = $1", id); err != nil {
+func (s *CommentStore) DeleteComment(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, "UPDATE comments SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", id); err != nil {
 		return fmt.Errorf("error deleting comment: %w", err)
 	}
 	return nil
@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewEmailVerificationStore(db DB) *EmailVerificationStore {
+	return &EmailVerificationStore{DB: db}
+}
+
+type EmailVerificationStore struct {
+	DB
+}
+
+func (s *EmailVerificationStore) EmailVerificationByHash(ctx context.Context, hash []byte) (store.EmailVerification, error) {
+	var v store.EmailVerification
+	if err := s.GetContext(ctx, &v, `SELECT * FROM email_verifications WHERE hash = $1`, hash); err != nil {
+		return store.EmailVerification{}, fmt.Errorf("error getting email verification: %w", err)
+	}
+	return v, nil
+}
+
+func (s *EmailVerificationStore) LastEmailVerification(ctx context.Context, userID uuid.UUID) (store.EmailVerification, error) {
+	var v store.EmailVerification
+	if err := s.GetContext(ctx, &v, `SELECT * FROM email_verifications WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT 1`, userID); err != nil {
+		return store.EmailVerification{}, fmt.Errorf("error getting email verification: %w", err)
+	}
+	return v, nil
+}
+
+func (s *EmailVerificationStore) CreateEmailVerification(ctx context.Context, v *store.EmailVerification) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM email_verifications WHERE expires_at <= now()`); err != nil {
+		return fmt.Errorf("error creating email verification: %w", err)
+	}
+	if err := s.GetContext(ctx, v, `INSERT INTO email_verifications (id, user_id, email, hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING *`,
+		v.ID,
+		v.UserID,
+		v.Email,
+		v.Hash,
+		v.ExpiresAt); err != nil {
+		return fmt.Errorf("error creating email verification: %w", err)
+	}
+	return nil
+}
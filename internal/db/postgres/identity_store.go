@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewIdentityStore(db DB) *IdentityStore {
+	return &IdentityStore{DB: db}
+}
+
+type IdentityStore struct {
+	DB
+}
+
+func (s *IdentityStore) IdentityBySubject(ctx context.Context, provider, subject string) (store.Identity, error) {
+	var i store.Identity
+	if err := s.GetContext(ctx, &i, `SELECT * FROM identities WHERE provider = $1 AND subject = $2`, provider, subject); err != nil {
+		return store.Identity{}, fmt.Errorf("error getting identity: %w", err)
+	}
+	return i, nil
+}
+
+func (s *IdentityStore) IdentitiesByUser(ctx context.Context, userID uuid.UUID) ([]store.Identity, error) {
+	var ii []store.Identity
+	if err := s.SelectContext(ctx, &ii, `SELECT * FROM identities WHERE user_id = $1 ORDER BY provider`, userID); err != nil {
+		return []store.Identity{}, fmt.Errorf("error getting identities: %w", err)
+	}
+	return ii, nil
+}
+
+func (s *IdentityStore) CreateIdentity(ctx context.Context, i *store.Identity) error {
+	if err := s.GetContext(ctx, i, `INSERT INTO identities (id, user_id, provider, subject, email) VALUES ($1, $2, $3, $4, $5) RETURNING *`,
+		i.ID,
+		i.UserID,
+		i.Provider,
+		i.Subject,
+		i.Email); err != nil {
+		return fmt.Errorf("error creating identity: %w", err)
+	}
+	return nil
+}
+
+func (s *IdentityStore) DeleteIdentity(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM identities WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error deleting identity: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewLockoutStore(db DB) *LockoutStore {
+	return &LockoutStore{DB: db}
+}
+
+type LockoutStore struct {
+	DB
+}
+
+func (s *LockoutStore) Lockout(ctx context.Context, key string) (store.Lockout, error) {
+	var l store.Lockout
+	if err := s.GetContext(ctx, &l, `SELECT * FROM lockouts WHERE key = $1`, key); err != nil {
+		return store.Lockout{}, fmt.Errorf("error getting lockout: %w", err)
+	}
+	return l, nil
+}
+
+func (s *LockoutStore) CountAttempt(ctx context.Context, key string, window time.Duration) (store.Lockout, error) {
+	if _, err := s.ExecContext(ctx, `
+		DELETE FROM lockouts
+		WHERE updated_at < now() - $1 * interval '1 second' AND (locked_until IS NULL OR locked_until <= now())`,
+		window.Seconds()); err != nil {
+		return store.Lockout{}, fmt.Errorf("error counting attempt: %w", err)
+	}
+	// the upsert counts the attempts of every instance one after the other
+	var l store.Lockout
+	if err := s.GetContext(ctx, &l, `
+		INSERT INTO lockouts (key, attempts) VALUES ($1, 1)
+		ON CONFLICT (key) DO UPDATE SET attempts = lockouts.attempts + 1, updated_at = now()
+		RETURNING *`, key); err != nil {
+		return store.Lockout{}, fmt.Errorf("error counting attempt: %w", err)
+	}
+	return l, nil
+}
+
+func (s *LockoutStore) LockOut(ctx context.Context, key string, until time.Time) error {
+	if _, err := s.ExecContext(ctx, `UPDATE lockouts SET locked_until = $1 WHERE key = $2`, until, key); err != nil {
+		return fmt.Errorf("error locking out: %w", err)
+	}
+	return nil
+}
+
+func (s *LockoutStore) ClearLockout(ctx context.Context, key string) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM lockouts WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("error clearing lockout: %w", err)
+	}
+	return nil
+}
+
+func (s *LockoutStore) Lockouts(ctx context.Context, at time.Time) ([]store.Lockout, error) {
+	var ll []store.Lockout
+	if err := s.SelectContext(ctx, &ll, `SELECT * FROM lockouts WHERE locked_until > $1 ORDER BY locked_until DESC, key`, at); err != nil {
+		return []store.Lockout{}, fmt.Errorf("error getting lockouts: %w", err)
+	}
+	return ll, nil
+}
@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewModerationStore(db DB) *ModerationStore {
+	return &ModerationStore{DB: db}
+}
+
+type ModerationStore struct {
+	DB
+}
+
+func (s *ModerationStore) Moderators(ctx context.Context, threadID uuid.UUID) ([]store.User, error) {
+	var uu []store.User
+	if err := s.SelectContext(ctx, &uu, `
+		SELECT users.*
+		FROM users
+		JOIN thread_moderators ON thread_moderators.user_id = users.id
+		WHERE thread_moderators.thread_id = $1
+		ORDER BY users.username`, threadID); err != nil {
+		return []store.User{}, fmt.Errorf("error getting moderators: %w", err)
+	}
+	return uu, nil
+}
+
+func (s *ModerationStore) AddModerator(ctx context.Context, threadID, userID uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `INSERT INTO thread_moderators (thread_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, threadID, userID); err != nil {
+		return fmt.Errorf("error adding moderator: %w", err)
+	}
+	return nil
+}
+
+func (s *ModerationStore) RemoveModerator(ctx context.Context, threadID, userID uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM thread_moderators WHERE thread_id = $1 AND user_id = $2`, threadID, userID); err != nil {
+		return fmt.Errorf("error removing moderator: %w", err)
+	}
+	return nil
+}
+
+func (s *ModerationStore) PinnedPosts(ctx context.Context, threadID uuid.UUID) ([]store.Post, error) {
+	var p []store.Post
+	var query = `
+		SELECT ` + postColumns + `
+		FROM ` + postFrom + `
+		WHERE thread_id = $1 AND pinned AND ` + postListed + `
+		ORDER BY posts.created_at DESC, posts.id DESC`
+	if err := s.SelectContext(ctx, &p, query, threadID); err != nil {
+		return []store.Post{}, fmt.Errorf("error getting pinned posts: %w", err)
+	}
+	return p, nil
+}
+
+func (s *ModerationStore) PinPost(ctx context.Context, id uuid.UUID, pinned bool) error {
+	if _, err := s.ExecContext(ctx, `UPDATE posts SET pinned = $1 WHERE id = $2`, pinned, id); err != nil {
+		return fmt.Errorf("error pinning post: %w", err)
+	}
+	return nil
+}
+
+func (s *ModerationStore) LockThread(ctx context.Context, id uuid.UUID, locked bool) error {
+	if _, err := s.ExecContext(ctx, `UPDATE threads SET locked = $1 WHERE id = $2`, locked, id); err != nil {
+		return fmt.Errorf("error locking thread: %w", err)
+	}
+	return nil
+}
+
+// sortedModAction is an action together with the sort key used to paginate it, the log is sorted by time only
+type sortedModAction struct {
+	store.ModAction
+	SortKey float64 `db:"sort_key"`
+}
+
+func (a sortedModAction) cursor() store.Cursor {
+	return store.Cursor{CreatedAt: a.CreatedAt, ID: a.ID}
+}
+
+func (s *ModerationStore) ModLog(ctx context.Context, threadID uuid.UUID, page store.Page) ([]store.ModAction, store.Cursors, error) {
+	var query = `
+		SELECT
+			mod_actions.*,
+			COALESCE((SELECT username FROM users WHERE users.id = mod_actions.moderator_id), '` + store.DeletedAuthor + `') AS moderator_name,
+			0::float AS sort_key
+		FROM mod_actions
+		WHERE thread_id = $1`
+	sa, cc, err := selectPage(ctx, s.DB, query, []interface{}{threadID}, "", page, sortedModAction.cursor)
+	if err != nil {
+		return []store.ModAction{}, store.Cursors{}, fmt.Errorf("error getting mod log: %w", err)
+	}
+	aa := make([]store.ModAction, len(sa))
+	for i, a := range sa {
+		aa[i] = a.ModAction
+	}
+	return aa, cc, nil
+}
+
+func (s *ModerationStore) LogModAction(ctx context.Context, a *store.ModAction) error {
+	if err := s.GetContext(ctx, a, `
+		INSERT INTO mod_actions (id, thread_id, moderator_id, action, target_id, target) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING *, COALESCE((SELECT username FROM users WHERE users.id = moderator_id), '`+store.DeletedAuthor+`') AS moderator_name`,
+		a.ID,
+		a.ThreadID,
+		a.ModeratorID,
+		a.Action,
+		a.TargetID,
+		a.Target); err != nil {
+		return fmt.Errorf("error logging mod action: %w", err)
+	}
+	return nil
+}
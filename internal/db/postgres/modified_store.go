@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func NewModifiedStore(db DB) *ModifiedStore {
+	return &ModifiedStore{DB: db}
+}
+
+// ModifiedStore reads the modified_at columns the triggers keep up to date together with the times of the votes,
+// which bump no row so the votes of a busy thread do not all write the same ones
+type ModifiedStore struct {
+	DB
+}
+
+// the latest votes on the posts and on the comments of a thread, of a post
+const (
+	threadVotedAt = `
+		(SELECT MAX(post_votes.voted_at) FROM post_votes JOIN posts ON posts.id = post_votes.post_id
+		WHERE posts.thread_id = threads.id),
+		(SELECT MAX(comment_votes.voted_at) FROM comment_votes JOIN comments ON comments.id = comment_votes.comment_id
+		JOIN posts ON posts.id = comments.post_id WHERE posts.thread_id = threads.id)`
+	postVotedAt = `
+		(SELECT MAX(voted_at) FROM post_votes WHERE post_votes.post_id = posts.id),
+		(SELECT MAX(comment_votes.voted_at) FROM comment_votes JOIN comments ON comments.id = comment_votes.comment_id
+		WHERE comments.post_id = posts.id)`
+)
+
+func (s *ModifiedStore) LastModified(ctx context.Context) (time.Time, error) {
+	var t sql.NullTime
+	if err := s.GetContext(ctx, &t, `SELECT GREATEST(
+		(SELECT MAX(modified_at) FROM threads),
+		(SELECT MAX(voted_at) FROM post_votes),
+		(SELECT MAX(voted_at) FROM comment_votes))`); err != nil {
+		return time.Time{}, fmt.Errorf("error getting last modification: %w", err)
+	}
+	return t.Time, nil
+}
+
+func (s *ModifiedStore) ThreadModified(ctx context.Context, id uuid.UUID) (time.Time, error) {
+	var t time.Time
+	if err := s.GetContext(ctx, &t, `SELECT GREATEST(modified_at, `+threadVotedAt+`) FROM threads WHERE id = $1`, id); err != nil {
+		return time.Time{}, fmt.Errorf("error getting thread modification: %w", err)
+	}
+	return t, nil
+}
+
+func (s *ModifiedStore) PostModified(ctx context.Context, id uuid.UUID) (time.Time, error) {
+	var t time.Time
+	if err := s.GetContext(ctx, &t, `
+		SELECT GREATEST(posts.modified_at, threads.updated_at, `+postVotedAt+`)
+		FROM posts JOIN threads ON threads.id = posts.thread_id
+		WHERE posts.id = $1`, id); err != nil {
+		return time.Time{}, fmt.Errorf("error getting post modification: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// keyset is a page of a listing translated into SQL
+type keyset struct {
+	page   store.Page
+	size   int
+	cursor store.Cursor
+	order  string
+}
+
+// newKeyset parses the cursor of the page, it fails if the cursor was made for a different sort order
+func newKeyset(order string, page store.Page) (keyset, error) {
+	k := keyset{page: page, size: page.Size, order: order}
+	if k.size <= 0 {
+		k.size = store.DefaultPageSize
+	}
+	if page.Cursor != "" {
+		c, err := store.ParseCursor(page.Cursor)
+		if err != nil {
+			return keyset{}, err
+		}
+		// a cursor of another sort order points to a meaningless position
+		if c.Order != order {
+			return keyset{}, store.ErrInvalidCursor
+		}
+		k.cursor = c
+	}
+	return k, nil
+}
+
+// wrap limits a query selecting the sort_key, created_at and id columns to the page, the rows are sorted
+// by those columns descending, or ascending when reading the previous page backwards from the cursor
+func (k keyset) wrap(query string, args []interface{}) (string, []interface{}) {
+	cmp, dir := "<", "DESC"
+	if k.cursor.Before {
+		cmp, dir = ">", "ASC"
+	}
+	where := ""
+	if k.page.Cursor != "" {
+		n := len(args)
+		where = fmt.Sprintf("WHERE (sort_key, created_at, id) %s ($%d, $%d, $%d)", cmp, n+1, n+2, n+3)
+		args = append(args, k.cursor.SortKey, k.cursor.CreatedAt, k.cursor.ID)
+	}
+	// one more row than the page size tells if there is a page after this one
+	query = fmt.Sprintf("SELECT * FROM (%s) page %s ORDER BY sort_key %s, created_at %s, id %s LIMIT %d",
+		query, where, dir, dir, dir, k.size+1)
+	return query, args
+}
+
+// trimPage turns the rows read by a keyset query, in the order of the query, into the page in listing order
+// and the cursors of the pages around it
+func trimPage[T any](k keyset, rows []T, key func(T) store.Cursor) ([]T, store.Cursors) {
+	more := len(rows) > k.size
+	if more {
+		rows = rows[:k.size]
+	}
+	if k.cursor.Before {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	if len(rows) == 0 {
+		return []T{}, store.Cursors{}
+	}
+
+	var cc store.Cursors
+	// going backwards the extra row is before the page, going forward it is after it
+	if k.cursor.Before && more || !k.cursor.Before && k.page.Cursor != "" {
+		prev := key(rows[0])
+		prev.Order, prev.Before = k.order, true
+		cc.Prev = prev.String()
+	}
+	if k.cursor.Before || more {
+		next := key(rows[len(rows)-1])
+		next.Order = k.order
+		cc.Next = next.String()
+	}
+	return rows, cc
+}
+
+// selectPage runs a keyset paginated listing. The query has to select the sort_key, created_at and id columns,
+// the page is sorted by them descending and key returns the cursor pointing at a row.
+func selectPage[T any](ctx context.Context, db sqlx.QueryerContext, query string, args []interface{}, order string, page store.Page, key func(T) store.Cursor) ([]T, store.Cursors, error) {
+	k, err := newKeyset(order, page)
+	if err != nil {
+		return []T{}, store.Cursors{}, err
+	}
+	query, args = k.wrap(query, args)
+
+	var rows []T
+	if err := sqlx.SelectContext(ctx, db, &rows, query, args...); err != nil {
+		return []T{}, store.Cursors{}, err
+	}
+	rows, cc := trimPage(k, rows, key)
+	return rows, cc, nil
+}
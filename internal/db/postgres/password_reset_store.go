@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewPasswordResetStore(db DB) *PasswordResetStore {
+	return &PasswordResetStore{DB: db}
+}
+
+type PasswordResetStore struct {
+	DB
+}
+
+func (s *PasswordResetStore) PasswordResetByHash(ctx context.Context, hash []byte) (store.PasswordReset, error) {
+	var r store.PasswordReset
+	if err := s.GetContext(ctx, &r, `SELECT * FROM password_resets WHERE hash = $1`, hash); err != nil {
+		return store.PasswordReset{}, fmt.Errorf("error getting password reset: %w", err)
+	}
+	return r, nil
+}
+
+func (s *PasswordResetStore) CreatePasswordReset(ctx context.Context, r *store.PasswordReset) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM password_resets WHERE expires_at <= now()`); err != nil {
+		return fmt.Errorf("error creating password reset: %w", err)
+	}
+	if err := s.GetContext(ctx, r, `INSERT INTO password_resets (id, user_id, hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING *`,
+		r.ID,
+		r.UserID,
+		r.Hash,
+		r.ExpiresAt); err != nil {
+		return fmt.Errorf("error creating password reset: %w", err)
+	}
+	return nil
+}
+
+func (s *PasswordResetStore) UsePasswordReset(ctx context.Context, id uuid.UUID) error {
+	var userID uuid.UUID
+	if err := s.GetContext(ctx, &userID, `
+		UPDATE password_resets SET used_at = now()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING user_id`, id); err != nil {
+		return fmt.Errorf("error using password reset: %w", err)
+	}
+	if _, err := s.ExecContext(ctx, `UPDATE password_resets SET used_at = now() WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("error using password reset: %w", err)
+	}
+	return nil
+}
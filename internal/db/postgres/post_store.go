@@ -1,92 +1,185 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
 )
 
-func NewPostStore(db *sqlx.DB) *PostStore {
+func NewPostStore(db DB) *PostStore {
 	return &PostStore{DB: db}
 }
 
 type PostStore struct {
-	*sqlx.DB
+	DB
 }
 
-func (s *PostStore) PostsByThread(threadID uuid.UUID) ([]store.Post, error) {
+// postColumns selects a post together with its author name, its score from the post_votes ledger and its comments count,
+// it has to be used together with postFrom
+const postColumns = `
+	posts.id,
+	posts.thread_id,
+	posts.author_id,
+	COALESCE((SELECT username FROM users WHERE users.id = posts.author_id), '` + store.DeletedAuthor + `') AS author_name,
+	posts.title,
+	posts.content,
+	posts.pinned,
+	score.votes AS votes,
+	(SELECT COUNT(*) FROM comments WHERE comments.post_id = posts.id AND comments.deleted_at IS NULL) AS comments_count,
+	posts.created_at,
+	posts.updated_at,
+	posts.deleted_at`
+
+// postListed keeps the posts out of the listings while they or their thread are in the trash
+const postListed = `
+	posts.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM threads WHERE threads.id = posts.thread_id AND threads.deleted_at IS NOT NULL)`
+
+// postFrom joins every post with the up and down votes of its ledger, so they can be used for sorting
+const postFrom = `
+	posts
+	JOIN LATERAL (
+		SELECT
+			COALESCE(SUM(value), 0) AS votes,
+			COUNT(*) FILTER (WHERE value > 0) AS ups,
+			COUNT(*) FILTER (WHERE value < 0) AS downs
+		FROM post_votes
+		WHERE post_votes.post_id = posts.id
+	) score ON true`
+
+// the sort key of every order, ties are broken by creation time and id, the hot and controversial formulas are the ones reddit uses
+var postSortKeys = map[store.PostOrder]string{
+	store.OrderHot: `SIGN(score.votes) * LOG(GREATEST(ABS(score.votes), 1)::float) + (EXTRACT(EPOCH FROM posts.created_at)::float - 1134028003) / 45000`,
+	store.OrderNew: `EXTRACT(EPOCH FROM posts.created_at)::float`,
+	store.OrderTop: `score.votes::float`,
+	store.OrderControversial: `CASE WHEN score.ups = 0 OR score.downs = 0 THEN 0
+		ELSE POWER(score.ups + score.downs, LEAST(score.ups, score.downs)::float / GREATEST(score.ups, score.downs)) END`,
+}
+
+// postSortKey returns the sort key of the order, unknown orders fall back to hot
+func postSortKey(order store.PostOrder) string {
+	if key, ok := postSortKeys[order]; ok {
+		return key
+	}
+	return postSortKeys[store.OrderHot]
+}
+
+// postOrderBy returns the ORDER BY clause of the sort, the id makes the order total so posts do not swap between queries
+func postOrderBy(sort store.PostSort) string {
+	return "ORDER BY " + postSortKey(sort.Order) + " DESC, posts.created_at DESC, posts.id DESC"
+}
+
+// sortedPost is a post together with the sort key used to paginate it
+type sortedPost struct {
+	store.Post
+	SortKey float64 `db:"sort_key"`
+}
+
+func (p sortedPost) cursor() store.Cursor {
+	return store.Cursor{SortKey: p.SortKey, CreatedAt: p.CreatedAt, ID: p.ID}
+}
+
+func unwrapPosts(sp []sortedPost) []store.Post {
+	pp := make([]store.Post, len(sp))
+	for i, p := range sp {
+		pp[i] = p.Post
+	}
+	return pp
+}
+
+func (s *PostStore) PostsByThread(ctx context.Context, threadID uuid.UUID, sort store.PostSort) ([]store.Post, error) {
 	var p []store.Post
 	var query = `
-		SELECT
-			posts.*,
-			COUNT(comments.*) AS comments_count
-		FROM posts
-		JOIN comments ON comments.post_id = posts.id
-		WHERE thread_id = $1
-		GROUP BY posts.id
-		ORDER BY votes DESC
-	`
-	if err := s.Select(&p, query, threadID); err != nil {
+		SELECT ` + postColumns + `
+		FROM ` + postFrom + `
+		WHERE thread_id = $1 AND posts.created_at >= $2 AND ` + postListed + `
+		` + postOrderBy(sort)
+	if err := s.SelectContext(ctx, &p, query, threadID, sort.Since); err != nil {
 		return []store.Post{}, fmt.Errorf("error getting posts: %w", err)
 	}
 	return p, nil
 }
 
-func (s *PostStore) Posts() ([]store.Post, error) {
+func (s *PostStore) PostsByThreadPage(ctx context.Context, threadID uuid.UUID, sort store.PostSort, page store.Page) ([]store.Post, store.Cursors, error) {
+	var query = `
+		SELECT ` + postColumns + `,
+			` + postSortKey(sort.Order) + ` AS sort_key
+		FROM ` + postFrom + `
+		WHERE thread_id = $1 AND posts.created_at >= $2 AND ` + postListed
+	sp, cc, err := selectPage(ctx, s.DB, query, []interface{}{threadID, sort.Since}, string(sort.Order), page, sortedPost.cursor)
+	if err != nil {
+		return []store.Post{}, store.Cursors{}, fmt.Errorf("error getting posts: %w", err)
+	}
+	return unwrapPosts(sp), cc, nil
+}
+
+func (s *PostStore) Posts(ctx context.Context, sort store.PostSort) ([]store.Post, error) {
 	var p []store.Post
 	var query = `
-		SELECT
-			posts.*,
-			COUNT(comments.*) AS comments_count,
+		SELECT ` + postColumns + `,
 			threads.title AS thread_title
-		FROM posts
-		JOIN comments ON comments.post_id = posts.id
+		FROM ` + postFrom + `
 		JOIN threads ON threads.id = posts.thread_id
-		GROUP BY posts.id, threads.title
-		ORDER BY votes DESC
-	`
-	if err := s.Select(&p, query); err != nil {
+		WHERE posts.created_at >= $1 AND ` + postListed + `
+		` + postOrderBy(sort)
+	if err := s.SelectContext(ctx, &p, query, sort.Since); err != nil {
 		return []store.Post{}, fmt.Errorf("error getting posts: %w", err)
 	}
 	return p, nil
 }
 
-func (s *PostStore) Post(id uuid.UUID) (store.Post, error) {
+func (s *PostStore) PostsPage(ctx context.Context, sort store.PostSort, page store.Page) ([]store.Post, store.Cursors, error) {
+	var query = `
+		SELECT ` + postColumns + `,
+			threads.title AS thread_title,
+			` + postSortKey(sort.Order) + ` AS sort_key
+		FROM ` + postFrom + `
+		JOIN threads ON threads.id = posts.thread_id
+		WHERE posts.created_at >= $1 AND ` + postListed
+	sp, cc, err := selectPage(ctx, s.DB, query, []interface{}{sort.Since}, string(sort.Order), page, sortedPost.cursor)
+	if err != nil {
+		return []store.Post{}, store.Cursors{}, fmt.Errorf("error getting posts: %w", err)
+	}
+	return unwrapPosts(sp), cc, nil
+}
+
+func (s *PostStore) Post(ctx context.Context, id uuid.UUID) (store.Post, error) {
 	var p store.Post
-	if err := s.Get(&p, "SELECT * FROM posts WHERE id = $1", id); err != nil {
+	if err := s.GetContext(ctx, &p, "SELECT "+postColumns+" FROM "+postFrom+" WHERE posts.id = $1", id); err != nil {
 		return store.Post{}, fmt.Errorf("error getting post: %w", err)
 	}
 	return p, nil
 }
 
-func (s *PostStore) CreatePost(p *store.Post) error {
-	if err := s.Get(p, "INSERT INTO posts VALUES ($1, $2, $3, $4, $5) RETURNING *",
+func (s *PostStore) CreatePost(ctx context.Context, p *store.Post) error {
+	if err := s.GetContext(ctx, p, "INSERT INTO posts (id, thread_id, author_id, title, content, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()), COALESCE($7, $6, now())) RETURNING id, thread_id, author_id, title, content, pinned, created_at, updated_at, deleted_at",
 		p.ID,
 		p.ThreadID,
+		p.AuthorID,
 		p.Title,
 		p.Content,
-		p.Votes); err != nil {
+		nullTime(p.CreatedAt),
+		nullTime(p.UpdatedAt)); err != nil {
 		return fmt.Errorf("error creating post: %w", err)
 	}
 	return nil
 }
 
-func (s *PostStore) UpdatePost(p *store.Post) error {
-	if err := s.Get(p, "UPDATE posts SET thread_id = $1, title = $2, content = $3, votes = $4 WHERE id = $5 RETURNING *",
+func (s *PostStore) UpdatePost(ctx context.Context, p *store.Post) error {
+	if err := s.GetContext(ctx, p, "UPDATE posts SET thread_id = $1, title = $2, content = $3, updated_at = now() WHERE id = $4 RETURNING id, thread_id, author_id, title, content, pinned, created_at, updated_at, deleted_at",
 		p.ThreadID,
 		p.Title,
 		p.Content,
-		p.Votes,
 		p.ID); err != nil {
 		return fmt.Errorf("error updating post: %w", err)
 	}
 	return nil
 }
 
-func (s *PostStore) DeletePost(id uuid.UUID) error {
-	if _, err := s.Exec("DELETE FROM posts WHERE id = $1", id); err != nil {
+func (s *PostStore) DeletePost(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, "UPDATE posts SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", id); err != nil {
 		return fmt.Errorf("error deleting post: %w", err)
 	}
 	return nil
@@ -1,12 +1,26 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // import the postgres driver as side effect
+	"github.com/lib/pq" // the postgres driver, its error codes tell which transactions to retry
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
 )
 
+// maxTxAttempts is how many times WithTx runs a transaction that keeps failing to serialize
+const maxTxAttempts = 5
+
+// eventsChannel is where the triggers announce the changes, see the add_events migration
+const eventsChannel = "goreddit_events"
+
 func NewStore(dataSourceName string) (*Store, error) {
 	db, err := sqlx.Open("postgres", dataSourceName)
 	if err != nil {
@@ -15,12 +29,82 @@ func NewStore(dataSourceName string) (*Store, error) {
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("error pinging database: %w", err)
 	}
+	s := newStore(db)
+	s.db = db
+
+	// a listener keeps its own connection, reconnecting when it drops
+	l := pq.NewListener(dataSourceName, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("events listener: %v", err)
+		}
+	})
+	if err := l.Listen(eventsChannel); err != nil {
+		return nil, fmt.Errorf("error listening to events: %w", err)
+	}
+	go s.listen(l)
+	return s, nil
+}
+
+// listen publishes the notifications of every server to the subscribers of this one
+func (s *Store) listen(l *pq.Listener) {
+	for {
+		select {
+		case n := <-l.Notify:
+			// a nil notification follows a reconnection, what happened in the meantime is lost
+			if n == nil {
+				s.events.Forget()
+				continue
+			}
+			// the id comes from a sequence of the database, every server gives the event the same one
+			var e struct {
+				store.Event
+				ID int64 `json:"id"`
+			}
+			if err := json.Unmarshal([]byte(n.Extra), &e); err != nil {
+				log.Printf("error decoding event %q: %v", n.Extra, err)
+				continue
+			}
+			e.Event.ID = strconv.FormatInt(e.ID, 10)
+			s.events.Publish(e.Event)
+		case <-time.After(90 * time.Second):
+			// check the connection is alive when nothing happens for a while
+			go l.Ping()
+		}
+	}
+}
+
+// Subscribe delivers the changes committed by this server and every other one listening to the same database
+func (s *Store) Subscribe(ctx context.Context, lastID string) (<-chan store.Event, bool) {
+	return s.events.Subscribe(ctx, lastID)
+}
+
+// DB runs the queries of the sub-stores, both *sqlx.DB and *sqlx.Tx implement it
+type DB interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+func newStore(db DB) *Store {
 	return &Store{
-		ThreadStore:  NewThreadStore(db),
-		PostStore:    NewPostStore(db),
-		CommentStore: NewCommentStore(db),
-		UserStore:    NewUserStore(db),
-	}, nil
+		ThreadStore:            NewThreadStore(db),
+		PostStore:              NewPostStore(db),
+		CommentStore:           NewCommentStore(db),
+		UserStore:              NewUserStore(db),
+		VoteStore:              NewVoteStore(db),
+		SearchStore:            NewSearchStore(db),
+		TrashStore:             NewTrashStore(db),
+		TokenStore:             NewTokenStore(db),
+		WebhookStore:           NewWebhookStore(db),
+		ModifiedStore:          NewModifiedStore(db),
+		ModerationStore:        NewModerationStore(db),
+		PasswordResetStore:     NewPasswordResetStore(db),
+		EmailVerificationStore: NewEmailVerificationStore(db),
+		TwoFactorStore:         NewTwoFactorStore(db),
+		IdentityStore:          NewIdentityStore(db),
+		LockoutStore:           NewLockoutStore(db),
+		events:                 store.NewHub(),
+	}
 }
 
 type Store struct {
@@ -28,4 +112,84 @@ type Store struct {
 	*PostStore
 	*CommentStore
 	*UserStore
+	*VoteStore
+	*SearchStore
+	*TrashStore
+	*TokenStore
+	*WebhookStore
+	*ModifiedStore
+	*ModerationStore
+	*PasswordResetStore
+	*EmailVerificationStore
+	*TwoFactorStore
+	*IdentityStore
+	*LockoutStore
+	// db is nil when the store already runs inside a transaction
+	db     *sqlx.DB
+	events *store.Hub
+}
+
+// WithTx runs fn with a store whose sub-stores share one serializable transaction.
+// The transaction commits when fn returns nil and rolls back otherwise, when postgres
+// cannot serialize it the whole fn runs again, so fn must not have other side effects.
+// Calling WithTx on the store fn receives joins the running transaction.
+func (s *Store) WithTx(ctx context.Context, fn func(store.Store) error) error {
+	if s.db == nil {
+		return fn(s)
+	}
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		if err = s.runTx(ctx, fn); !retryable(err) {
+			return err
+		}
+		// back off a little so the conflicting transaction can finish
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("error running transaction after %d attempts: %w", maxTxAttempts, err)
+}
+
+func (s *Store) runTx(ctx context.Context, fn func(store.Store) error) (err error) {
+	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		// roll back on errors and panics alike, the panic goes on after it
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	txStore := newStore(tx)
+	txStore.events = s.events
+	if err := fn(txStore); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// retryable reports whether the transaction failed only because it conflicted with another one
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	// serialization_failure and deadlock_detected
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+// nullTime turns the zero time into NULL, so the COALESCE of the inserts falls back to the creation time, or to now()
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
 }
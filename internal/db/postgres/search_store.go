@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewSearchStore(db DB) *SearchStore {
+	return &SearchStore{DB: db}
+}
+
+type SearchStore struct {
+	DB
+}
+
+// headlineOptions make ts_headline mark the matches with the store markers and show a couple of fragments
+const headlineOptions = `StartSel="` + store.HighlightStart + `", StopSel="` + store.HighlightStop + `", ` +
+	`MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=" … "`
+
+// authorName selects the name of the author of the rows of table
+func authorName(table string) string {
+	return `COALESCE((SELECT username FROM users WHERE users.id = ` + table + `.author_id), '` + store.DeletedAuthor + `')`
+}
+
+// searchQuery matches the three kinds of content against the query in $1, each branch is skipped when
+// the type in $3 excludes it and limited to the thread in $2 when it is set
+var searchQuery = `
+	SELECT results.*, results.rank AS sort_key
+	FROM (
+		SELECT
+			'thread' AS type,
+			threads.id,
+			threads.id AS thread_id,
+			threads.title AS thread_title,
+			NULL::uuid AS post_id,
+			threads.title,
+			ts_headline('english', threads.description, query, $4) AS headline,
+			` + authorName("threads") + ` AS author_name,
+			ts_rank(threads.search, query)::float AS rank,
+			threads.created_at
+		FROM threads, websearch_to_tsquery('english', $1) query
+		WHERE threads.search @@ query
+			AND threads.deleted_at IS NULL
+			AND ($2::uuid IS NULL OR threads.id = $2)
+			AND ($3 = '' OR $3 = 'thread')
+		UNION ALL
+		SELECT
+			'post',
+			posts.id,
+			posts.thread_id,
+			threads.title,
+			posts.id,
+			posts.title,
+			ts_headline('english', posts.content, query, $4),
+			` + authorName("posts") + `,
+			ts_rank(posts.search, query)::float,
+			posts.created_at
+		FROM posts JOIN threads ON threads.id = posts.thread_id, websearch_to_tsquery('english', $1) query
+		WHERE posts.search @@ query
+			AND posts.deleted_at IS NULL AND threads.deleted_at IS NULL
+			AND ($2::uuid IS NULL OR posts.thread_id = $2)
+			AND ($3 = '' OR $3 = 'post')
+		UNION ALL
+		SELECT
+			'comment',
+			comments.id,
+			posts.thread_id,
+			threads.title,
+			comments.post_id,
+			posts.title,
+			ts_headline('english', comments.content, query, $4),
+			` + authorName("comments") + `,
+			ts_rank(comments.search, query)::float,
+			comments.created_at
+		FROM comments JOIN posts ON posts.id = comments.post_id JOIN threads ON threads.id = posts.thread_id,
+			websearch_to_tsquery('english', $1) query
+		WHERE comments.search @@ query
+			AND comments.deleted_at IS NULL AND posts.deleted_at IS NULL AND threads.deleted_at IS NULL
+			AND ($2::uuid IS NULL OR posts.thread_id = $2)
+			AND ($3 = '' OR $3 = 'comment')
+	) results`
+
+// sortedResult is a search result together with the sort key used to paginate it
+type sortedResult struct {
+	store.SearchResult
+	SortKey float64 `db:"sort_key"`
+}
+
+func (r sortedResult) cursor() store.Cursor {
+	return store.Cursor{SortKey: r.SortKey, CreatedAt: r.CreatedAt, ID: r.ID}
+}
+
+// Search ranks the matches with ts_rank, newer content first among equally ranked ones
+func (s *SearchStore) Search(ctx context.Context, q store.SearchQuery, page store.Page) ([]store.SearchResult, store.Cursors, error) {
+	args := []interface{}{q.Terms, q.ThreadID, string(q.Type), headlineOptions}
+	sr, cc, err := selectPage(ctx, s.DB, searchQuery, args, "search", page, sortedResult.cursor)
+	if err != nil {
+		return []store.SearchResult{}, store.Cursors{}, fmt.Errorf("error searching: %w", err)
+	}
+	rr := make([]store.SearchResult, len(sr))
+	for i, r := range sr {
+		rr[i] = r.SearchResult
+	}
+	return rr, cc, nil
+}
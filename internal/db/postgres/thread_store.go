@@ -1,50 +1,92 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
 )
 
-func NewThreadStore(db *sqlx.DB) *ThreadStore {
+func NewThreadStore(db DB) *ThreadStore {
 	return &ThreadStore{DB: db}
 }
 
 type ThreadStore struct {
 	// embedded structure so we inherit all the methods from it
-	*sqlx.DB
+	DB
 }
 
-func (s *ThreadStore) Threads() ([]store.Thread, error) {
+// threadColumns selects a thread together with its author name
+const threadColumns = `
+	threads.id,
+	threads.author_id,
+	COALESCE((SELECT username FROM users WHERE users.id = threads.author_id), '` + store.DeletedAuthor + `') AS author_name,
+	threads.title,
+	threads.description,
+	threads.locked,
+	threads.created_at,
+	threads.updated_at,
+	threads.deleted_at`
+
+func (s *ThreadStore) Threads(ctx context.Context) ([]store.Thread, error) {
 	var t []store.Thread
-	if err := s.Select(&t, "SELECT * FROM threads"); err != nil {
+	if err := s.SelectContext(ctx, &t, "SELECT "+threadColumns+" FROM threads WHERE deleted_at IS NULL ORDER BY created_at DESC, id DESC"); err != nil {
 		return []store.Thread{}, fmt.Errorf("error getting threads: %w", err)
 	}
 	return t, nil
 }
 
-func (s *ThreadStore) Thread(id uuid.UUID) (store.Thread, error) {
+// sortedThread is a thread together with the sort key used to paginate it
+type sortedThread struct {
+	store.Thread
+	SortKey float64 `db:"sort_key"`
+}
+
+func (t sortedThread) cursor() store.Cursor {
+	return store.Cursor{SortKey: t.SortKey, CreatedAt: t.CreatedAt, ID: t.ID}
+}
+
+func (s *ThreadStore) ThreadsPage(ctx context.Context, page store.Page) ([]store.Thread, store.Cursors, error) {
+	var query = `
+		SELECT ` + threadColumns + `,
+			EXTRACT(EPOCH FROM threads.created_at)::float AS sort_key
+		FROM threads
+		WHERE deleted_at IS NULL`
+	st, cc, err := selectPage(ctx, s.DB, query, nil, "", page, sortedThread.cursor)
+	if err != nil {
+		return []store.Thread{}, store.Cursors{}, fmt.Errorf("error getting threads: %w", err)
+	}
+	tt := make([]store.Thread, len(st))
+	for i, t := range st {
+		tt[i] = t.Thread
+	}
+	return tt, cc, nil
+}
+
+func (s *ThreadStore) Thread(ctx context.Context, id uuid.UUID) (store.Thread, error) {
 	var t store.Thread
-	if err := s.Get(&t, "SELECT * FROM threads WHERE id = $1", id); err != nil {
+	if err := s.GetContext(ctx, &t, "SELECT "+threadColumns+" FROM threads WHERE id = $1", id); err != nil {
 		return store.Thread{}, fmt.Errorf("error getting thread: %w", err)
 	}
 	return t, nil
 }
 
-func (s *ThreadStore) CreateThread(t *store.Thread) error {
-	if err := s.Get(t, "INSERT INTO threads VALUES ($1, $2, $3) RETURNING *",
+func (s *ThreadStore) CreateThread(ctx context.Context, t *store.Thread) error {
+	if err := s.GetContext(ctx, t, "INSERT INTO threads (id, author_id, title, description, created_at, updated_at) VALUES ($1, $2, $3, $4, COALESCE($5, now()), COALESCE($6, $5, now())) RETURNING id, author_id, title, description, locked, created_at, updated_at, deleted_at",
 		t.ID,
+		t.AuthorID,
 		t.Title,
-		t.Description); err != nil {
+		t.Description,
+		nullTime(t.CreatedAt),
+		nullTime(t.UpdatedAt)); err != nil {
 		return fmt.Errorf("error creating thread: %w", err)
 	}
 	return nil
 }
 
-func (s *ThreadStore) UpdateThread(t *store.Thread) error {
-	if err := s.Get(t, "UPDATE threads SET title = $1, description = $2 WHERE id = $3 RETURNING *",
+func (s *ThreadStore) UpdateThread(ctx context.Context, t *store.Thread) error {
+	if err := s.GetContext(ctx, t, "UPDATE threads SET title = $1, description = $2, updated_at = now() WHERE id = $3 RETURNING id, author_id, title, description, locked, created_at, updated_at, deleted_at",
 		t.Title,
 		t.Description,
 		t.ID); err != nil {
@@ -53,8 +95,8 @@ func (s *ThreadStore) UpdateThread(t *store.Thread) error {
 	return nil
 }
 
-func (s *ThreadStore) DeleteThread(id uuid.UUID) error {
-	if _, err := s.Exec("DELETE FROM threads WHERE id = $1", id); err != nil {
+func (s *ThreadStore) DeleteThread(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, "UPDATE threads SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", id); err != nil {
 		return fmt.Errorf("error deleting thread: %w", err)
 	}
 	return nil
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewTokenStore(db DB) *TokenStore {
+	return &TokenStore{DB: db}
+}
+
+type TokenStore struct {
+	DB
+}
+
+func (s *TokenStore) Token(ctx context.Context, id uuid.UUID) (store.Token, error) {
+	var t store.Token
+	if err := s.GetContext(ctx, &t, `SELECT * FROM tokens WHERE id = $1`, id); err != nil {
+		return store.Token{}, fmt.Errorf("error getting token: %w", err)
+	}
+	return t, nil
+}
+
+func (s *TokenStore) TokenByHash(ctx context.Context, hash []byte) (store.Token, error) {
+	var t store.Token
+	if err := s.GetContext(ctx, &t, `SELECT * FROM tokens WHERE hash = $1`, hash); err != nil {
+		return store.Token{}, fmt.Errorf("error getting token: %w", err)
+	}
+	return t, nil
+}
+
+func (s *TokenStore) TokensByUser(ctx context.Context, userID uuid.UUID) ([]store.Token, error) {
+	var tt []store.Token
+	if err := s.SelectContext(ctx, &tt, `SELECT * FROM tokens WHERE user_id = $1 ORDER BY created_at DESC, id DESC`, userID); err != nil {
+		return []store.Token{}, fmt.Errorf("error getting tokens: %w", err)
+	}
+	return tt, nil
+}
+
+func (s *TokenStore) CreateToken(ctx context.Context, t *store.Token) error {
+	if err := s.GetContext(ctx, t, `INSERT INTO tokens (id, user_id, name, scope, prefix, hash) VALUES ($1, $2, $3, $4, $5, $6) RETURNING *`,
+		t.ID,
+		t.UserID,
+		t.Name,
+		t.Scope,
+		t.Prefix,
+		t.Hash); err != nil {
+		return fmt.Errorf("error creating token: %w", err)
+	}
+	return nil
+}
+
+func (s *TokenStore) TouchToken(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `UPDATE tokens SET last_used_at = now() WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error touching token: %w", err)
+	}
+	return nil
+}
+
+func (s *TokenStore) DeleteToken(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM tokens WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error deleting token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewTrashStore(db DB) *TrashStore {
+	return &TrashStore{DB: db}
+}
+
+type TrashStore struct {
+	DB
+}
+
+// trashQuery lists the threads, posts and comments in the trash
+var trashQuery = `
+	SELECT trash.*, EXTRACT(EPOCH FROM trash.deleted_at)::float AS sort_key
+	FROM (
+		SELECT
+			'thread' AS type,
+			threads.id,
+			threads.id AS thread_id,
+			NULL::uuid AS post_id,
+			threads.title,
+			threads.description AS content,
+			` + authorName("threads") + ` AS author_name,
+			threads.created_at,
+			threads.deleted_at
+		FROM threads
+		WHERE threads.deleted_at IS NOT NULL
+		UNION ALL
+		SELECT 'post', posts.id, posts.thread_id, posts.id, posts.title, posts.content,
+			` + authorName("posts") + `, posts.created_at, posts.deleted_at
+		FROM posts
+		WHERE posts.deleted_at IS NOT NULL
+		UNION ALL
+		SELECT 'comment', comments.id, posts.thread_id, comments.post_id, posts.title, comments.content,
+			` + authorName("comments") + `, comments.created_at, comments.deleted_at
+		FROM comments JOIN posts ON posts.id = comments.post_id
+		WHERE comments.deleted_at IS NOT NULL
+	) trash`
+
+// sortedTrashItem is an item of the trash together with the sort key used to paginate it
+type sortedTrashItem struct {
+	store.TrashItem
+	SortKey float64 `db:"sort_key"`
+}
+
+func (i sortedTrashItem) cursor() store.Cursor {
+	return store.Cursor{SortKey: i.SortKey, CreatedAt: i.CreatedAt, ID: i.ID}
+}
+
+func (s *TrashStore) Trash(ctx context.Context, page store.Page) ([]store.TrashItem, store.Cursors, error) {
+	st, cc, err := selectPage(ctx, s.DB, trashQuery, nil, "trash", page, sortedTrashItem.cursor)
+	if err != nil {
+		return []store.TrashItem{}, store.Cursors{}, fmt.Errorf("error getting trash: %w", err)
+	}
+	ii := make([]store.TrashItem, len(st))
+	for i, t := range st {
+		ii[i] = t.TrashItem
+	}
+	return ii, cc, nil
+}
+
+// trashTables maps the content types to their tables
+var trashTables = map[store.ContentType]string{
+	store.ContentThread:  "threads",
+	store.ContentPost:    "posts",
+	store.ContentComment: "comments",
+}
+
+func (s *TrashStore) Restore(ctx context.Context, t store.ContentType, id uuid.UUID) error {
+	table, ok := trashTables[t]
+	if !ok {
+		return fmt.Errorf("error restoring %s: unknown content type", t)
+	}
+	res, err := s.ExecContext(ctx, "UPDATE "+table+" SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return fmt.Errorf("error restoring %s: %w", t, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("error restoring %s: %w", t, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// purgeQueries delete the rows for good, ON DELETE CASCADE takes away what is below them. The comments kept are the
+// ones staying and their ancestors, the cascade of a comment would take its live replies with it.
+var purgeQueries = []struct{ table, query string }{
+	{"comments", `WITH RECURSIVE kept AS (
+		SELECT id, parent_id FROM comments WHERE deleted_at IS NULL OR deleted_at >= $1
+		UNION
+		SELECT c.id, c.parent_id FROM comments c JOIN kept k ON c.id = k.parent_id
+	)
+	DELETE FROM comments WHERE deleted_at < $1 AND id NOT IN (SELECT id FROM kept)`},
+	{"posts", "DELETE FROM posts WHERE deleted_at < $1"},
+	{"threads", "DELETE FROM threads WHERE deleted_at < $1"},
+}
+
+func (s *TrashStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	// comments first, so the ones removed by the cascade of their post or thread are not counted twice
+	for _, q := range purgeQueries {
+		table := q.table
+		res, err := s.ExecContext(ctx, q.query, before)
+		if err != nil {
+			return purged, fmt.Errorf("error purging %s: %w", table, err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return purged, fmt.Errorf("error purging %s: %w", table, err)
+		}
+		purged += n
+	}
+	return purged, nil
+}
@@ -0,0 +1,86 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewTwoFactorStore(db DB) *TwoFactorStore {
+	return &TwoFactorStore{DB: db}
+}
+
+type TwoFactorStore struct {
+	DB
+}
+
+func (s *TwoFactorStore) TOTP(ctx context.Context, userID uuid.UUID) (store.TOTP, error) {
+	var t store.TOTP
+	if err := s.GetContext(ctx, &t, `SELECT * FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return store.TOTP{}, fmt.Errorf("error getting totp: %w", err)
+	}
+	return t, nil
+}
+
+func (s *TwoFactorStore) EnableTOTP(ctx context.Context, t *store.TOTP, codes []store.RecoveryCode) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, t.UserID); err != nil {
+		return fmt.Errorf("error enabling totp: %w", err)
+	}
+	if err := s.GetContext(ctx, t, `
+		INSERT INTO user_totp (user_id, secret, last_step) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = EXCLUDED.last_step, created_at = now()
+		RETURNING *`,
+		t.UserID,
+		t.Secret,
+		t.LastStep); err != nil {
+		return fmt.Errorf("error enabling totp: %w", err)
+	}
+	for i := range codes {
+		if err := s.GetContext(ctx, &codes[i], `INSERT INTO recovery_codes (id, user_id, hash) VALUES ($1, $2, $3) RETURNING *`,
+			codes[i].ID,
+			codes[i].UserID,
+			codes[i].Hash); err != nil {
+			return fmt.Errorf("error enabling totp: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error disabling totp: %w", err)
+	}
+	if _, err := s.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("error disabling totp: %w", err)
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	var id uuid.UUID
+	if err := s.GetContext(ctx, &id, `UPDATE user_totp SET last_step = $1 WHERE user_id = $2 AND last_step < $1 RETURNING user_id`, step, userID); err != nil {
+		return fmt.Errorf("error using totp step: %w", err)
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash []byte) error {
+	var id uuid.UUID
+	if err := s.GetContext(ctx, &id, `
+		UPDATE recovery_codes SET used_at = now()
+		WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+		RETURNING id`, userID, hash); err != nil {
+		return fmt.Errorf("error using recovery code: %w", err)
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) RecoveryCodesLeft(ctx context.Context, userID uuid.UUID) (int, error) {
+	var n int
+	if err := s.GetContext(ctx, &n, `SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return 0, fmt.Errorf("error counting recovery codes: %w", err)
+	}
+	return n, nil
+}
@@ -1,67 +1,91 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
 )
 
-func NewUserStore(db *sqlx.DB) *UserStore {
+func NewUserStore(db DB) *UserStore {
 	return &UserStore{DB: db}
 }
 
 type UserStore struct {
-	*sqlx.DB
+	DB
 }
 
-func (s *UserStore) User(id uuid.UUID) (store.User, error) {
+func (s *UserStore) User(ctx context.Context, id uuid.UUID) (store.User, error) {
 	var u store.User
-	if err := s.Get(&u, `SELECT * FROM users WHERE id = $1`, id); err != nil {
+	if err := s.GetContext(ctx, &u, `SELECT * FROM users WHERE id = $1`, id); err != nil {
+		return store.User{}, fmt.Errorf("error getting user: %w", err)
+	}
+	if err := s.SelectContext(ctx, &u.Moderates, `SELECT thread_id FROM thread_moderators WHERE user_id = $1`, id); err != nil {
 		return store.User{}, fmt.Errorf("error getting user: %w", err)
 	}
 	return u, nil
 }
 
-func (s *UserStore) UserByUsername(username string) (store.User, error) {
+func (s *UserStore) UserByUsername(ctx context.Context, username string) (store.User, error) {
 	var u store.User
-	if err := s.Get(&u, `SELECT * FROM users WHERE username = $1`, username); err != nil {
+	if err := s.GetContext(ctx, &u, `SELECT * FROM users WHERE username = $1`, username); err != nil {
 		return store.User{}, fmt.Errorf("error getting user: %w", err)
 	}
 	return u, nil
 }
 
-func (s *UserStore) Users() ([]store.User, error) {
+func (s *UserStore) UserByEmail(ctx context.Context, email string) (store.User, error) {
+	var u store.User
+	if err := s.GetContext(ctx, &u, `SELECT * FROM users WHERE lower(email) = lower($1) AND email <> ''`, email); err != nil {
+		return store.User{}, fmt.Errorf("error getting user: %w", err)
+	}
+	return u, nil
+}
+
+func (s *UserStore) Users(ctx context.Context) ([]store.User, error) {
 	var uu []store.User
-	if err := s.Select(&uu, `SELECT * FROM users`); err != nil {
+	if err := s.SelectContext(ctx, &uu, `SELECT * FROM users`); err != nil {
 		return []store.User{}, fmt.Errorf("error getting users: %w", err)
 	}
 	return uu, nil
 }
 
-func (s *UserStore) CreateUser(u *store.User) error {
-	if err := s.Get(u, `INSERT INTO users VALUES ($1, $2, $3) RETURNING *`,
+func (s *UserStore) CreateUser(ctx context.Context, u *store.User) error {
+	if err := s.GetContext(ctx, u, `INSERT INTO users (id, username, password, email, email_verified, role) VALUES ($1, $2, $3, $4, $5, COALESCE(NULLIF($6, ''), 'user')) RETURNING *`,
 		u.ID,
 		u.Username,
-		u.Password); err != nil {
+		u.Password,
+		u.Email,
+		u.EmailVerified,
+		u.Role); err != nil {
 		return fmt.Errorf("error creating user: %w", err)
 	}
 	return nil
 }
 
-func (s *UserStore) UpdateUser(u *store.User) error {
-	if err := s.Get(u, `UPDATE users SET username = $1, password = $2 WHERE id = $3 RETURNING *`,
+func (s *UserStore) UpdateUser(ctx context.Context, u *store.User) error {
+	if err := s.GetContext(ctx, u, `UPDATE users SET username = $1, password = $2, email = $3, email_verified = $4, role = $5, updated_at = now() WHERE id = $6 RETURNING *`,
 		u.Username,
 		u.Password,
+		u.Email,
+		u.EmailVerified,
+		u.Role,
 		u.ID); err != nil {
 		return fmt.Errorf("error updating user: %w", err)
 	}
 	return nil
 }
 
-func (s *UserStore) DeleteUser(id uuid.UUID) error {
-	if _, err := s.Exec(`DELETE FROM users WHERE id = $1`, id); err != nil {
+func (s *UserStore) RevokeSessions(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `UPDATE users SET session_generation = session_generation + 1 WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error revoking sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *UserStore) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id); err != nil {
 		return fmt.Errorf("error deleting user: %w", err)
 	}
 	return nil
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewVoteStore(db DB) *VoteStore {
+	return &VoteStore{DB: db}
+}
+
+type VoteStore struct {
+	DB
+}
+
+type vote struct {
+	TargetID uuid.UUID `db:"target_id"`
+	Value    int       `db:"value"`
+}
+
+// PostVotes returns the votes the user cast on the given posts, posts without a vote are not in the map
+func (s *VoteStore) PostVotes(ctx context.Context, userID uuid.UUID, postIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	var vv []vote
+	if err := s.SelectContext(ctx, &vv, `SELECT post_id AS target_id, value FROM post_votes WHERE user_id = $1 AND post_id = ANY($2) AND value <> 0`,
+		userID,
+		pq.Array(postIDs)); err != nil {
+		return map[uuid.UUID]int{}, fmt.Errorf("error getting post votes: %w", err)
+	}
+	return votesMap(vv), nil
+}
+
+// VotePost casts the vote of the user or changes it if the user already voted on the post
+func (s *VoteStore) VotePost(ctx context.Context, userID, postID uuid.UUID, value int) error {
+	if _, err := s.ExecContext(ctx, `
+		INSERT INTO post_votes (user_id, post_id, value) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, post_id) DO UPDATE SET value = EXCLUDED.value, voted_at = EXCLUDED.voted_at`,
+		userID,
+		postID,
+		value); err != nil {
+		return fmt.Errorf("error voting post: %w", err)
+	}
+	return nil
+}
+
+// RetractPostVote keeps the vote in the ledger with the value 0, so its time still tells the pages the score changed
+func (s *VoteStore) RetractPostVote(ctx context.Context, userID, postID uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `UPDATE post_votes SET value = 0, voted_at = clock_timestamp() WHERE user_id = $1 AND post_id = $2 AND value <> 0`, userID, postID); err != nil {
+		return fmt.Errorf("error retracting post vote: %w", err)
+	}
+	return nil
+}
+
+// CommentVotes returns the votes the user cast on the given comments, comments without a vote are not in the map
+func (s *VoteStore) CommentVotes(ctx context.Context, userID uuid.UUID, commentIDs []uuid.UUID) (map[uuid.UUID]int, error) {
+	var vv []vote
+	if err := s.SelectContext(ctx, &vv, `SELECT comment_id AS target_id, value FROM comment_votes WHERE user_id = $1 AND comment_id = ANY($2) AND value <> 0`,
+		userID,
+		pq.Array(commentIDs)); err != nil {
+		return map[uuid.UUID]int{}, fmt.Errorf("error getting comment votes: %w", err)
+	}
+	return votesMap(vv), nil
+}
+
+// VoteComment casts the vote of the user or changes it if the user already voted on the comment
+func (s *VoteStore) VoteComment(ctx context.Context, userID, commentID uuid.UUID, value int) error {
+	if _, err := s.ExecContext(ctx, `
+		INSERT INTO comment_votes (user_id, comment_id, value) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, comment_id) DO UPDATE SET value = EXCLUDED.value, voted_at = EXCLUDED.voted_at`,
+		userID,
+		commentID,
+		value); err != nil {
+		return fmt.Errorf("error voting comment: %w", err)
+	}
+	return nil
+}
+
+// RetractCommentVote keeps the vote in the ledger with the value 0 like RetractPostVote
+func (s *VoteStore) RetractCommentVote(ctx context.Context, userID, commentID uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `UPDATE comment_votes SET value = 0, voted_at = clock_timestamp() WHERE user_id = $1 AND comment_id = $2 AND value <> 0`, userID, commentID); err != nil {
+		return fmt.Errorf("error retracting comment vote: %w", err)
+	}
+	return nil
+}
+
+func (s *VoteStore) VotesOnPosts(ctx context.Context, postIDs []uuid.UUID) ([]store.Vote, error) {
+	var vv []store.Vote
+	if err := s.SelectContext(ctx, &vv, `SELECT user_id, post_id AS target_id, value FROM post_votes WHERE post_id = ANY($1) AND value <> 0`, pq.Array(postIDs)); err != nil {
+		return []store.Vote{}, fmt.Errorf("error getting post votes: %w", err)
+	}
+	return vv, nil
+}
+
+func (s *VoteStore) VotesOnComments(ctx context.Context, commentIDs []uuid.UUID) ([]store.Vote, error) {
+	var vv []store.Vote
+	if err := s.SelectContext(ctx, &vv, `SELECT user_id, comment_id AS target_id, value FROM comment_votes WHERE comment_id = ANY($1) AND value <> 0`, pq.Array(commentIDs)); err != nil {
+		return []store.Vote{}, fmt.Errorf("error getting comment votes: %w", err)
+	}
+	return vv, nil
+}
+
+func votesMap(vv []vote) map[uuid.UUID]int {
+	m := make(map[uuid.UUID]int, len(vv))
+	for _, v := range vv {
+		m[v.TargetID] = v.Value
+	}
+	return m
+}
@@ -0,0 +1,119 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func NewWebhookStore(db DB) *WebhookStore {
+	return &WebhookStore{DB: db}
+}
+
+type WebhookStore struct {
+	DB
+}
+
+func (s *WebhookStore) Webhooks(ctx context.Context) ([]store.Webhook, error) {
+	var ww []store.Webhook
+	if err := s.SelectContext(ctx, &ww, `SELECT * FROM webhooks ORDER BY created_at, id`); err != nil {
+		return []store.Webhook{}, fmt.Errorf("error getting webhooks: %w", err)
+	}
+	return ww, nil
+}
+
+func (s *WebhookStore) Webhook(ctx context.Context, id uuid.UUID) (store.Webhook, error) {
+	var w store.Webhook
+	if err := s.GetContext(ctx, &w, `SELECT * FROM webhooks WHERE id = $1`, id); err != nil {
+		return store.Webhook{}, fmt.Errorf("error getting webhook: %w", err)
+	}
+	return w, nil
+}
+
+func (s *WebhookStore) CreateWebhook(ctx context.Context, w *store.Webhook) error {
+	if err := s.GetContext(ctx, w, `INSERT INTO webhooks (id, url, secret, events) VALUES ($1, $2, $3, $4) RETURNING *`,
+		w.ID,
+		w.URL,
+		w.Secret,
+		w.Events); err != nil {
+		return fmt.Errorf("error creating webhook: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhookStore) UpdateWebhook(ctx context.Context, w *store.Webhook) error {
+	if err := s.GetContext(ctx, w, `UPDATE webhooks SET url = $1, secret = $2, events = $3, updated_at = now() WHERE id = $4 RETURNING *`,
+		w.URL,
+		w.Secret,
+		w.Events,
+		w.ID); err != nil {
+		return fmt.Errorf("error updating webhook: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhookStore) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhookStore) CreateDelivery(ctx context.Context, d *store.WebhookDelivery) error {
+	if err := s.GetContext(ctx, d, `INSERT INTO webhook_deliveries (id, webhook_id, event, payload, next_attempt_at) VALUES ($1, $2, $3, $4, $5) RETURNING *`,
+		d.ID,
+		d.WebhookID,
+		d.Event,
+		d.Payload,
+		d.NextAttemptAt); err != nil {
+		return fmt.Errorf("error creating delivery: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhookStore) DeliveriesByWebhook(ctx context.Context, webhookID uuid.UUID, limit int) ([]store.WebhookDelivery, error) {
+	var dd []store.WebhookDelivery
+	if err := s.SelectContext(ctx, &dd, `SELECT * FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`, webhookID, limit); err != nil {
+		return []store.WebhookDelivery{}, fmt.Errorf("error getting deliveries: %w", err)
+	}
+	return dd, nil
+}
+
+func (s *WebhookStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]store.WebhookDelivery, error) {
+	// SKIP LOCKED lets several senders claim batches side by side without waiting for each other
+	var dd []store.WebhookDelivery
+	if err := s.SelectContext(ctx, &dd, `
+		UPDATE webhook_deliveries SET next_attempt_at = now() + $2 * interval '1 microsecond'
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, limit, lease.Microseconds()); err != nil {
+		return []store.WebhookDelivery{}, fmt.Errorf("error claiming deliveries: %w", err)
+	}
+	return dd, nil
+}
+
+func (s *WebhookStore) UpdateDelivery(ctx context.Context, d *store.WebhookDelivery) error {
+	if err := s.GetContext(ctx, d, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_attempt_at = $4, response_status = $5, error = $6
+		WHERE id = $7
+		RETURNING *`,
+		d.Status,
+		d.Attempts,
+		d.NextAttemptAt,
+		d.LastAttemptAt,
+		d.ResponseStatus,
+		d.Error,
+		d.ID); err != nil {
+		return fmt.Errorf("error updating delivery: %w", err)
+	}
+	return nil
+}
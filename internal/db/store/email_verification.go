@@ -0,0 +1,28 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailVerification proves the user receives the mails sent to the address. The token is mailed with a link,
+// only its hash is stored.
+type EmailVerification struct {
+	ID     uuid.UUID `db:"id"`
+	UserID uuid.UUID `db:"user_id"`
+	// Email is the address the link was sent to, the link verifies nothing once the user changed address
+	Email     string    `db:"email"`
+	Hash      []byte    `db:"hash"`
+	CreatedAt time.Time `db:"created_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
+
+type EmailVerificationStore interface {
+	EmailVerificationByHash(ctx context.Context, hash []byte) (EmailVerification, error)
+	// LastEmailVerification returns the verification sent last to the user, it fails with sql.ErrNoRows when there is none
+	LastEmailVerification(ctx context.Context, userID uuid.UUID) (EmailVerification, error)
+	// CreateEmailVerification drops the expired verifications of every user on the way
+	CreateEmailVerification(ctx context.Context, v *EmailVerification) error
+}
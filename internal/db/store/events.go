@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// EventType tells what changed
+type EventType string
+
+const (
+	EventCommentCreated EventType = "comment_created"
+	// EventCommentUpdated is sent when a comment is edited, moved to the trash or restored
+	EventCommentUpdated EventType = "comment_updated"
+	// EventPostUpdated is sent when a post is edited, moved to the trash or restored
+	EventPostUpdated EventType = "post_updated"
+	// EventScoreChanged is sent when a vote on a post or a comment is cast, changed or retracted
+	EventScoreChanged EventType = "score_changed"
+)
+
+// Event announces a change of a post or of one of its comments
+type Event struct {
+	// ID tells the event apart for the subscribers coming back, the hub sets it when the store gives none
+	ID       string    `json:"-"`
+	Type     EventType `json:"type"`
+	ThreadID uuid.UUID `json:"thread_id"`
+	PostID   uuid.UUID `json:"post_id"`
+	// CommentID is set when the change is about a comment
+	CommentID uuid.NullUUID `json:"comment_id"`
+	// Votes is the new score of a score_changed event
+	Votes int `json:"votes"`
+}
+
+type EventStore interface {
+	// Subscribe delivers the changes committed from now on until ctx is done, then it closes the channel.
+	// A subscriber that does not keep up misses events rather than holding the others up.
+	// With the id of the last event a subscriber got, the changes committed since then come first. complete is false
+	// when some of them are not known anymore, like after a restart, the subscriber gets the changes from now on only.
+	Subscribe(ctx context.Context, lastID string) (events <-chan Event, complete bool)
+}
+
+const (
+	// eventBuffer is how many events wait for a subscriber before the next ones are dropped
+	eventBuffer = 64
+	// eventHistory is how many of the last events a hub keeps for the subscribers coming back
+	eventHistory = 256
+)
+
+// Hub fans the events out to the subscribers of one process, the stores feed it with their changes.
+// The events keep the ids the store gives them, so a store shared by several processes can give ids every one of them
+// knows, the postgres one numbers its notifications. The hub numbers the others starting with its epoch, so the ids
+// of another process or of a restart are told apart.
+type Hub struct {
+	mu    sync.Mutex
+	subs  map[chan Event]struct{}
+	epoch string
+	seq   uint64
+	// history holds the last events in the order they were published
+	history []Event
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[chan Event]struct{}{}, epoch: uuid.NewString()[:8]}
+}
+
+func (h *Hub) Subscribe(ctx context.Context, lastID string) (<-chan Event, bool) {
+	h.mu.Lock()
+	missed, complete := h.since(lastID)
+	ch := make(chan Event, eventBuffer+len(missed))
+	for _, e := range missed {
+		ch <- e
+	}
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subs, ch)
+		close(ch)
+		h.mu.Unlock()
+	}()
+	return ch, complete
+}
+
+// since returns the events published after the one of the id and whether they are all there, the caller has to hold
+// the lock
+func (h *Hub) since(id string) ([]Event, bool) {
+	if id == "" {
+		return nil, true
+	}
+	for i := len(h.history) - 1; i >= 0; i-- {
+		if h.history[i].ID == id {
+			return append([]Event(nil), h.history[i+1:]...), true
+		}
+	}
+	return nil, false
+}
+
+// Forget drops the history when the store may have missed events, the subscribers coming back get the changes from
+// now on only rather than a replay with a gap
+func (h *Hub) Forget() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.history = nil
+}
+
+// Publish numbers the event when it has no id yet and sends it to every subscriber with room for it
+func (h *Hub) Publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if e.ID == "" {
+		h.seq++
+		e.ID = fmt.Sprintf("%s-%d", h.epoch, h.seq)
+	}
+	if len(h.history) == eventHistory {
+		h.history = h.history[1:]
+	}
+	h.history = append(h.history, e)
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
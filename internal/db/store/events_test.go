@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+// two servers get the notifications of the database in the same order with the same ids, a subscriber coming back
+// to the other one gets what it missed
+func TestHubReplaysSharedIDs(t *testing.T) {
+	here, there := NewHub(), NewHub()
+	publish := func(ids ...int) {
+		for _, id := range ids {
+			e := Event{ID: strconv.Itoa(id), Type: EventCommentCreated}
+			here.Publish(e)
+			there.Publish(e)
+		}
+	}
+	publish(1, 2, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tests := []struct {
+		name     string
+		hub      *Hub
+		lastID   string
+		complete bool
+		replayed []string
+	}{
+		{"same server", here, "2", true, []string{"3"}},
+		{"other server", there, "1", true, []string{"2", "3"}},
+		{"up to date", there, "3", true, nil},
+		{"unknown id", there, "7", false, nil},
+		{"id of a process", there, here.epoch + "-1", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, complete := tt.hub.Subscribe(ctx, tt.lastID)
+			if complete != tt.complete {
+				t.Errorf("complete is %v, want %v", complete, tt.complete)
+			}
+			for _, want := range tt.replayed {
+				if e := <-ch; e.ID != want {
+					t.Errorf("replayed %s, want %s", e.ID, want)
+				}
+			}
+			if len(ch) != 0 {
+				t.Errorf("%d more events replayed", len(ch))
+			}
+		})
+	}
+
+	// after a reconnection the server may have missed events, it replays nothing rather than a gap
+	there.Forget()
+	publish(4)
+	if _, complete := there.Subscribe(ctx, "3"); complete {
+		t.Error("the replay after a reconnection is complete")
+	}
+	if ch, complete := there.Subscribe(ctx, "4"); !complete || len(ch) != 0 {
+		t.Error("the events published after a reconnection are not known")
+	}
+}
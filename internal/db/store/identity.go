@@ -0,0 +1,31 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Identity links a user to an account at an OpenID Connect provider, the user signs in with that account.
+// A user has at most one account per provider and an account belongs to one user.
+type Identity struct {
+	ID     uuid.UUID `db:"id"`
+	UserID uuid.UUID `db:"user_id"`
+	// Provider is the name of the provider in the configuration of the site
+	Provider string `db:"provider"`
+	// Subject identifies the account at the provider, it never changes
+	Subject string `db:"subject"`
+	// Email is the address of the account when it was linked, to tell the accounts apart
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type IdentityStore interface {
+	// IdentityBySubject fails with sql.ErrNoRows when nobody linked the account
+	IdentityBySubject(ctx context.Context, provider, subject string) (Identity, error)
+	// IdentitiesByUser lists the accounts linked to the user by provider
+	IdentitiesByUser(ctx context.Context, userID uuid.UUID) ([]Identity, error)
+	CreateIdentity(ctx context.Context, i *Identity) error
+	DeleteIdentity(ctx context.Context, id uuid.UUID) error
+}
@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Lockout counts the recent attempts of a key, like the address or the username logging in, and keeps the key locked
+// out for a while once they are too many. The counters live in the store so every instance of the site agrees on them.
+type Lockout struct {
+	Key         string       `db:"key"`
+	Attempts    int          `db:"attempts"`
+	LockedUntil sql.NullTime `db:"locked_until"`
+	// UpdatedAt is the time of the last attempt
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// Locked reports whether the key is locked out at the time
+func (l Lockout) Locked(at time.Time) bool {
+	return l.LockedUntil.Valid && at.Before(l.LockedUntil.Time)
+}
+
+type LockoutStore interface {
+	// Lockout fails with sql.ErrNoRows when the key has no recent attempts
+	Lockout(ctx context.Context, key string) (Lockout, error)
+	// CountAttempt adds an attempt to the counter of the key and returns it. The counters whose last attempt is older
+	// than the window are dropped first, unless they are still locked out, so the key starts over after a quiet window.
+	CountAttempt(ctx context.Context, key string, window time.Duration) (Lockout, error)
+	// LockOut keeps the key locked out until the time
+	LockOut(ctx context.Context, key string, until time.Time) error
+	// ClearLockout forgets the attempts of the key and unlocks it
+	ClearLockout(ctx context.Context, key string) error
+	// Lockouts lists the keys locked out at the time, from the one staying locked the longest
+	Lockouts(ctx context.Context, at time.Time) ([]Lockout, error)
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ModActionType names what a moderator did in a thread
+type ModActionType string
+
+const (
+	ModRemoveThread    ModActionType = "remove_thread"
+	ModRemovePost      ModActionType = "remove_post"
+	ModRemoveComment   ModActionType = "remove_comment"
+	ModRestoreThread   ModActionType = "restore_thread"
+	ModRestorePost     ModActionType = "restore_post"
+	ModRestoreComment  ModActionType = "restore_comment"
+	ModPinPost         ModActionType = "pin_post"
+	ModUnpinPost       ModActionType = "unpin_post"
+	ModLockThread      ModActionType = "lock_thread"
+	ModUnlockThread    ModActionType = "unlock_thread"
+	ModAddModerator    ModActionType = "add_moderator"
+	ModRemoveModerator ModActionType = "remove_moderator"
+)
+
+// ModAction is an entry of the public mod log of a thread
+type ModAction struct {
+	ID            uuid.UUID     `db:"id"`
+	ThreadID      uuid.UUID     `db:"thread_id"`
+	ModeratorID   uuid.NullUUID `db:"moderator_id"`
+	ModeratorName string        `db:"moderator_name"`
+	Action        ModActionType `db:"action"`
+	// TargetID is the thread, post, comment or user the action was done to
+	TargetID uuid.UUID `db:"target_id"`
+	// Target describes the target as it was at the time, the title, the start of the comment or the username,
+	// so the log still tells what it was about once the target changed or went away
+	Target    string    `db:"target"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// ModerationStore keeps the moderators of the threads, the pins and the locks they set and the log of what they did.
+// The stores do not log by themselves, the callers record the action in the same transaction as the change.
+type ModerationStore interface {
+	// Moderators lists the moderators appointed to the thread by username
+	Moderators(ctx context.Context, threadID uuid.UUID) ([]User, error)
+	// AddModerator does nothing when the user moderates the thread already
+	AddModerator(ctx context.Context, threadID, userID uuid.UUID) error
+	RemoveModerator(ctx context.Context, threadID, userID uuid.UUID) error
+	// PinnedPosts lists the pinned posts of the thread from the newest, the ones in the trash are left out
+	PinnedPosts(ctx context.Context, threadID uuid.UUID) ([]Post, error)
+	PinPost(ctx context.Context, id uuid.UUID, pinned bool) error
+	LockThread(ctx context.Context, id uuid.UUID, locked bool) error
+	// ModLog lists the actions done in the thread from the newest
+	ModLog(ctx context.Context, threadID uuid.UUID, page Page) ([]ModAction, Cursors, error)
+	LogModAction(ctx context.Context, a *ModAction) error
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ModifiedStore tells when the content shown by the pages last changed, the pages validate the HTTP caches with it.
+// Every change counts: the edits, the deletions and restorations, the new posts and comments, the votes
+// and the authors being renamed or deleted. The times only move forward, two changes never share one.
+type ModifiedStore interface {
+	// LastModified returns when any thread, post or comment last changed
+	LastModified(ctx context.Context) (time.Time, error)
+	// ThreadModified returns when the thread or anything below it last changed
+	ThreadModified(ctx context.Context, id uuid.UUID) (time.Time, error)
+	// PostModified returns when the post, the title of its thread or anything below the post last changed
+	PostModified(ctx context.Context, id uuid.UUID) (time.Time, error)
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultPageSize is used when a Page does not ask for a size
+const DefaultPageSize = 25
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page asks a listing for Size items starting from Cursor, an empty cursor asks for the first page
+type Page struct {
+	Size   int
+	Cursor string
+}
+
+// Cursors point to the pages around the one returned by a listing, they are empty when there is no such page
+type Cursors struct {
+	Prev string
+	Next string
+}
+
+// Cursor is the position of an item in a listing. Listings are sorted by SortKey, CreatedAt and ID, all descending,
+// so the cursor keeps pointing at the same position when new items are added.
+// Clients only see the opaque string returned by String.
+type Cursor struct {
+	Order     string    `json:"o,omitempty"`
+	SortKey   float64   `json:"k"`
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+	// Before is set by the cursors pointing to the previous page
+	Before bool `json:"b,omitempty"`
+}
+
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a cursor returned by String
+func ParseCursor(s string) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordReset lets a user who forgot the password choose a new one. The token is mailed to the user and only its
+// hash is stored, a reset works once and only until it expires.
+type PasswordReset struct {
+	ID        uuid.UUID    `db:"id"`
+	UserID    uuid.UUID    `db:"user_id"`
+	Hash      []byte       `db:"hash"`
+	CreatedAt time.Time    `db:"created_at"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+}
+
+// Usable reports whether the reset can still change the password at the time
+func (r PasswordReset) Usable(at time.Time) bool {
+	return !r.UsedAt.Valid && at.Before(r.ExpiresAt)
+}
+
+type PasswordResetStore interface {
+	PasswordResetByHash(ctx context.Context, hash []byte) (PasswordReset, error)
+	// CreatePasswordReset drops the expired resets of every user on the way, nobody can use them anymore
+	CreatePasswordReset(ctx context.Context, r *PasswordReset) error
+	// UsePasswordReset marks the reset used together with the other pending resets of its user, so the links mailed
+	// before stop working as well. It fails with sql.ErrNoRows when the reset is used already or expired.
+	UsePasswordReset(ctx context.Context, id uuid.UUID) error
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the markers around the matched words in SearchResult.Headline
+const (
+	HighlightStart = "\x02"
+	HighlightStop  = "\x03"
+)
+
+// SearchQuery is what to search for, the zero ThreadID and Type search everything
+type SearchQuery struct {
+	Terms    string
+	ThreadID uuid.NullUUID
+	Type     ContentType
+}
+
+// SearchResult is a thread, post or comment matching a search.
+// Title is the title of the thread or post, for comments the one of the post they belong to.
+// Headline is an excerpt of the matching text with the matched words between HighlightStart and HighlightStop,
+// it is plain text so it has to be escaped before the markers are turned into markup.
+type SearchResult struct {
+	Type        ContentType   `db:"type"`
+	ID          uuid.UUID     `db:"id"`
+	ThreadID    uuid.UUID     `db:"thread_id"`
+	ThreadTitle string        `db:"thread_title"`
+	PostID      uuid.NullUUID `db:"post_id"`
+	Title       string        `db:"title"`
+	Headline    string        `db:"headline"`
+	AuthorName  string        `db:"author_name"`
+	Rank        float64       `db:"rank"`
+	CreatedAt   time.Time     `db:"created_at"`
+}
+
+type SearchStore interface {
+	// Search lists the matches of the query, the best ranked first
+	Search(ctx context.Context, q SearchQuery, page Page) ([]SearchResult, Cursors, error)
+}
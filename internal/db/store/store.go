@@ -1,71 +1,227 @@
 package store
 
 import (
+	"context"
+	"database/sql"
+	"time"
+
 	"github.com/google/uuid"
 )
 
+// DeletedAuthor is shown in place of the username of a deleted author
+const DeletedAuthor = "[deleted]"
+
+// DeletedContent is shown in place of a deleted comment that is kept in a tree because of its replies
+const DeletedContent = "[deleted]"
+
+// ContentType is the kind of content searches and the trash list
+type ContentType string
+
+const (
+	ContentThread  ContentType = "thread"
+	ContentPost    ContentType = "post"
+	ContentComment ContentType = "comment"
+)
+
 type Thread struct {
-	ID          uuid.UUID `db:"id"`
-	Title       string    `db:"title"`
-	Description string    `db:"description"`
+	ID          uuid.UUID     `db:"id"`
+	AuthorID    uuid.NullUUID `db:"author_id"`
+	AuthorName  string        `db:"author_name"`
+	Title       string        `db:"title"`
+	Description string        `db:"description"`
+	// Locked threads take no new posts but from their moderators
+	Locked    bool         `db:"locked"`
+	CreatedAt time.Time    `db:"created_at"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	DeletedAt sql.NullTime `db:"deleted_at"`
 }
 
 type Post struct {
-	ID            uuid.UUID `db:"id"`
-	ThreadID      uuid.UUID `db:"thread_id"`
-	Title         string    `db:"title"`
-	Content       string    `db:"content"`
-	Votes         int       `db:"votes"`
-	CommentsCount int       `db:"comments_count"`
-	ThreadTitle   string    `db:"thread_title"`
+	ID            uuid.UUID     `db:"id"`
+	ThreadID      uuid.UUID     `db:"thread_id"`
+	AuthorID      uuid.NullUUID `db:"author_id"`
+	AuthorName    string        `db:"author_name"`
+	Title         string        `db:"title"`
+	Content       string        `db:"content"`
+	Votes         int           `db:"votes"`
+	CommentsCount int           `db:"comments_count"`
+	ThreadTitle   string        `db:"thread_title"`
+	// Pinned posts are shown above the others on the page of their thread
+	Pinned    bool         `db:"pinned"`
+	CreatedAt time.Time    `db:"created_at"`
+	UpdatedAt time.Time    `db:"updated_at"`
+	DeletedAt sql.NullTime `db:"deleted_at"`
 }
 
 type Comment struct {
-	ID      uuid.UUID `db:"id"`
-	PostID  uuid.UUID `db:"post_id"`
-	Content string    `db:"content"`
-	Votes   int       `db:"votes"`
+	ID         uuid.UUID     `db:"id"`
+	PostID     uuid.UUID     `db:"post_id"`
+	ThreadID   uuid.UUID     `db:"thread_id"`
+	ParentID   uuid.NullUUID `db:"parent_id"`
+	AuthorID   uuid.NullUUID `db:"author_id"`
+	AuthorName string        `db:"author_name"`
+	Content    string        `db:"content"`
+	Votes      int           `db:"votes"`
+	CreatedAt  time.Time     `db:"created_at"`
+	UpdatedAt  time.Time     `db:"updated_at"`
+	DeletedAt  sql.NullTime  `db:"deleted_at"`
+
+	// filled by the comment tree queries only
+	Depth        int       `db:"depth"`
+	RepliesCount int       `db:"replies_count"`
+	Replies      []Comment `db:"-"`
 }
 
+// Vote values stored in the post_votes and comment_votes ledgers
+const (
+	VoteDown = -1
+	VoteNone = 0
+	VoteUp   = 1
+)
+
+// Vote is an entry of a ledger, the vote of a user on a post or a comment
+type Vote struct {
+	UserID   uuid.UUID `db:"user_id"`
+	TargetID uuid.UUID `db:"target_id"`
+	Value    int       `db:"value"`
+}
+
+// Role is the site-wide role of a user
+type Role string
+
+const (
+	RoleUser Role = "user"
+	// RoleAdmin users moderate every thread, appoint the moderators, see the trash and restore what it holds
+	RoleAdmin Role = "admin"
+)
+
 type User struct {
 	ID       uuid.UUID `db:"id"`
 	Username string    `db:"username"`
 	Password string    `db:"password"`
+	// Email is where the mails of the site are sent, it is empty when the user gave none.
+	// The addresses are unique regardless of the case.
+	Email string `db:"email"`
+	// EmailVerified is set once the user followed the link mailed to the address
+	EmailVerified bool `db:"email_verified"`
+	Role          Role `db:"role"`
+	// SessionGeneration is stored in the sessions of the user at login, the sessions of an older generation are over
+	SessionGeneration int       `db:"session_generation"`
+	CreatedAt         time.Time `db:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at"`
+
+	// filled by User only, the threads the user moderates
+	Moderates []uuid.UUID `db:"-"`
+}
+
+func (u User) Admin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Moderator reports whether the user moderates the thread, the admins moderate them all
+func (u User) Moderator(threadID uuid.UUID) bool {
+	if u.Admin() {
+		return true
+	}
+	for _, id := range u.Moderates {
+		if id == threadID {
+			return true
+		}
+	}
+	return false
+}
+
+// PostOrder is the order in which listings of posts are sorted
+type PostOrder string
+
+const (
+	// OrderHot ranks posts by score decayed by age, like reddit does
+	OrderHot PostOrder = "hot"
+	// OrderNew ranks the most recent posts first
+	OrderNew PostOrder = "new"
+	// OrderTop ranks posts by score
+	OrderTop PostOrder = "top"
+	// OrderControversial ranks first the posts with many votes evenly split between up and down
+	OrderControversial PostOrder = "controversial"
+)
+
+// PostSort tells the listings of posts how to sort and which time window to consider
+type PostSort struct {
+	Order PostOrder
+	// Since excludes the posts created before it, the zero value includes all posts
+	Since time.Time
 }
 
 // Lets define what sort of storing and retrieving operations our database should be able to do on our entities
 
+// The listings, counts and searches skip what is in the trash, while the getters of single items return it
+// with DeletedAt set so callers can tell it apart.
+
+// The create methods set CreatedAt and UpdatedAt to the current time unless they are set already,
+// so the imports keep the original times.
+
 type ThreadStore interface {
-	Threads() ([]Thread, error)
-	Thread(id uuid.UUID) (Thread, error)
-	CreateThread(t *Thread) error
-	UpdateThread(t *Thread) error
-	DeleteThread(id uuid.UUID) error
+	Threads(ctx context.Context) ([]Thread, error)
+	// ThreadsPage lists the threads from the newest
+	ThreadsPage(ctx context.Context, page Page) ([]Thread, Cursors, error)
+	Thread(ctx context.Context, id uuid.UUID) (Thread, error)
+	CreateThread(ctx context.Context, t *Thread) error
+	UpdateThread(ctx context.Context, t *Thread) error
+	// DeleteThread moves the thread to the trash, its posts are hidden together with it
+	DeleteThread(ctx context.Context, id uuid.UUID) error
 }
 
 type PostStore interface {
-	PostsByThread(threadID uuid.UUID) ([]Post, error)
-	Posts() ([]Post, error)
-	Post(id uuid.UUID) (Post, error)
-	CreatePost(t *Post) error
-	UpdatePost(t *Post) error
-	DeletePost(id uuid.UUID) error
+	PostsByThread(ctx context.Context, threadID uuid.UUID, sort PostSort) ([]Post, error)
+	Posts(ctx context.Context, sort PostSort) ([]Post, error)
+	PostsByThreadPage(ctx context.Context, threadID uuid.UUID, sort PostSort, page Page) ([]Post, Cursors, error)
+	PostsPage(ctx context.Context, sort PostSort, page Page) ([]Post, Cursors, error)
+	Post(ctx context.Context, id uuid.UUID) (Post, error)
+	CreatePost(ctx context.Context, t *Post) error
+	UpdatePost(ctx context.Context, t *Post) error
+	// DeletePost moves the post to the trash, its comments are hidden together with it
+	DeletePost(ctx context.Context, id uuid.UUID) error
 }
 
 type CommentStore interface {
-	CommentsByPost(postID uuid.UUID) ([]Comment, error)
-	Comment(id uuid.UUID) (Comment, error)
-	CreateComment(t *Comment) error
-	UpdateComment(t *Comment) error
-	DeleteComment(id uuid.UUID) error
+	CommentsByPost(ctx context.Context, postID uuid.UUID) ([]Comment, error)
+	CommentsByPostPage(ctx context.Context, postID uuid.UUID, page Page) ([]Comment, Cursors, error)
+	// CommentTree returns a page of the top level comments of the post, each with its replies nested up to maxDepth levels below it
+	CommentTree(ctx context.Context, postID uuid.UUID, page Page, maxDepth int) ([]Comment, Cursors, error)
+	// CommentThread returns the comment with its replies nested up to maxDepth levels below it
+	CommentThread(ctx context.Context, id uuid.UUID, maxDepth int) (Comment, error)
+	Comment(ctx context.Context, id uuid.UUID) (Comment, error)
+	CreateComment(ctx context.Context, t *Comment) error
+	UpdateComment(ctx context.Context, t *Comment) error
+	// DeleteComment moves the comment to the trash, the trees keep it as a placeholder while it has replies
+	DeleteComment(ctx context.Context, id uuid.UUID) error
 }
 
 type UserStore interface {
-	User(id uuid.UUID) (User, error)
-	UserByUsername(username string) (User, error)
-	CreateUser(u *User) error
-	UpdateUser(u *User) error
-	DeleteUser(id uuid.UUID) error
+	User(ctx context.Context, id uuid.UUID) (User, error)
+	UserByUsername(ctx context.Context, username string) (User, error)
+	// UserByEmail finds the user with the address regardless of the case, there is none for the empty address
+	UserByEmail(ctx context.Context, email string) (User, error)
+	// CreateUser gives the user the RoleUser unless the Role is set
+	CreateUser(ctx context.Context, u *User) error
+	// UpdateUser leaves the SessionGeneration alone, RevokeSessions moves it
+	UpdateUser(ctx context.Context, u *User) error
+	// RevokeSessions logs the user out everywhere by moving the SessionGeneration on
+	RevokeSessions(ctx context.Context, id uuid.UUID) error
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+}
+
+// VoteStore keeps one vote per user and target, the Votes score of posts and comments is the sum of their ledger
+type VoteStore interface {
+	PostVotes(ctx context.Context, userID uuid.UUID, postIDs []uuid.UUID) (map[uuid.UUID]int, error)
+	VotePost(ctx context.Context, userID, postID uuid.UUID, value int) error
+	RetractPostVote(ctx context.Context, userID, postID uuid.UUID) error
+	CommentVotes(ctx context.Context, userID uuid.UUID, commentIDs []uuid.UUID) (map[uuid.UUID]int, error)
+	VoteComment(ctx context.Context, userID, commentID uuid.UUID, value int) error
+	RetractCommentVote(ctx context.Context, userID, commentID uuid.UUID) error
+	// VotesOnPosts and VotesOnComments return the ledger entries of the given targets, whoever cast them
+	VotesOnPosts(ctx context.Context, postIDs []uuid.UUID) ([]Vote, error)
+	VotesOnComments(ctx context.Context, commentIDs []uuid.UUID) ([]Vote, error)
 }
 
 type Store interface {
@@ -73,4 +229,20 @@ type Store interface {
 	PostStore
 	CommentStore
 	UserStore
+	VoteStore
+	SearchStore
+	TrashStore
+	TokenStore
+	EventStore
+	WebhookStore
+	ModifiedStore
+	ModerationStore
+	PasswordResetStore
+	EmailVerificationStore
+	TwoFactorStore
+	IdentityStore
+	LockoutStore
+	// WithTx runs fn atomically against the store it receives: everything fn does is kept when it returns nil and undone otherwise.
+	// fn may run more than once when the transaction conflicts with another one.
+	WithTx(ctx context.Context, fn func(Store) error) error
 }
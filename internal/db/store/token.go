@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TokenScope limits what a personal access token can do, every scope includes the ones before it
+type TokenScope string
+
+const (
+	// ScopeRead only reads
+	ScopeRead TokenScope = "read"
+	// ScopeWrite creates, edits, votes and deletes like the user can
+	ScopeWrite TokenScope = "write"
+	// ScopeAdmin reaches the admin pages too, as long as the user is an admin
+	ScopeAdmin TokenScope = "admin"
+)
+
+// TokenScopes lists the scopes from the narrowest to the widest
+var TokenScopes = []TokenScope{ScopeRead, ScopeWrite, ScopeAdmin}
+
+// Allows reports whether a token of the scope can be used where the wanted scope is needed
+func (s TokenScope) Allows(want TokenScope) bool {
+	return s.rank() >= want.rank() && want.rank() >= 0
+}
+
+func (s TokenScope) rank() int {
+	for i, scope := range TokenScopes {
+		if scope == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Token is a personal access token, it authenticates scripts as the user who created it.
+// The token itself is shown once when it is created, only its hash is stored.
+type Token struct {
+	ID     uuid.UUID  `db:"id"`
+	UserID uuid.UUID  `db:"user_id"`
+	Name   string     `db:"name"`
+	Scope  TokenScope `db:"scope"`
+	// Prefix is the start of the token, it tells the tokens apart
+	Prefix     string       `db:"prefix"`
+	Hash       []byte       `db:"hash"`
+	CreatedAt  time.Time    `db:"created_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+}
+
+type TokenStore interface {
+	Token(ctx context.Context, id uuid.UUID) (Token, error)
+	TokenByHash(ctx context.Context, hash []byte) (Token, error)
+	// TokensByUser lists the tokens of the user, the newest first
+	TokensByUser(ctx context.Context, userID uuid.UUID) ([]Token, error)
+	CreateToken(ctx context.Context, t *Token) error
+	// TouchToken records that the token has just been used
+	TouchToken(ctx context.Context, id uuid.UUID) error
+	// DeleteToken revokes the token
+	DeleteToken(ctx context.Context, id uuid.UUID) error
+}
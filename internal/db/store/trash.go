@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashItem is a thread, post or comment in the trash.
+// Title is the title of the thread or post, for comments the one of the post they belong to.
+type TrashItem struct {
+	Type       ContentType   `db:"type"`
+	ID         uuid.UUID     `db:"id"`
+	ThreadID   uuid.UUID     `db:"thread_id"`
+	PostID     uuid.NullUUID `db:"post_id"`
+	Title      string        `db:"title"`
+	Content    string        `db:"content"`
+	AuthorName string        `db:"author_name"`
+	CreatedAt  time.Time     `db:"created_at"`
+	DeletedAt  time.Time     `db:"deleted_at"`
+}
+
+type TrashStore interface {
+	// Trash lists what is in the trash, the last deleted first
+	Trash(ctx context.Context, page Page) ([]TrashItem, Cursors, error)
+	// Restore takes the item out of the trash
+	Restore(ctx context.Context, t ContentType, id uuid.UUID) error
+	// Purge permanently removes what was moved to the trash before the time, together with everything below it,
+	// and returns how many items it removed from the trash. A comment with a reply below it that is not purged stays
+	// as the placeholder of its replies, it goes with the purge after the last of them.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// NestComments builds the trees of the given comments, the comments whose parent is not in the list are the roots.
+// Replies are sorted by votes within each level, the roots keep the order they have in the list.
+func NestComments(cc []Comment) []Comment {
+	ids := make(map[uuid.UUID]bool, len(cc))
+	children := make(map[uuid.UUID][]Comment, len(cc))
+	for _, c := range cc {
+		ids[c.ID] = true
+	}
+	var roots []Comment
+	for _, c := range cc {
+		if c.ParentID.Valid && ids[c.ParentID.UUID] {
+			children[c.ParentID.UUID] = append(children[c.ParentID.UUID], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+
+	var nest func(c Comment) Comment
+	nest = func(c Comment) Comment {
+		replies := children[c.ID]
+		SortComments(replies)
+		c.Replies = make([]Comment, len(replies))
+		for i, r := range replies {
+			c.Replies[i] = nest(r)
+		}
+		return c
+	}
+	for i, r := range roots {
+		roots[i] = nest(r)
+	}
+	return roots
+}
+
+// SortComments sorts comments by votes, the newest first on a tie
+func SortComments(cc []Comment) {
+	sort.Slice(cc, func(i, j int) bool {
+		if cc[i].Votes != cc[j].Votes {
+			return cc[i].Votes > cc[j].Votes
+		}
+		if !cc[i].CreatedAt.Equal(cc[j].CreatedAt) {
+			return cc[i].CreatedAt.After(cc[j].CreatedAt)
+		}
+		return cc[i].ID.String() > cc[j].ID.String()
+	})
+}
+
+// PruneDeleted removes the deleted comments without replies from the trees and blanks the ones kept
+// to hold their replies in place. A comment whose replies were not loaded is kept when it has some.
+func PruneDeleted(cc []Comment) []Comment {
+	pruned := make([]Comment, 0, len(cc))
+	for _, c := range cc {
+		loaded := len(c.Replies) > 0
+		c.Replies = PruneDeleted(c.Replies)
+		if loaded {
+			c.RepliesCount = len(c.Replies)
+		}
+		if c.DeletedAt.Valid {
+			if c.RepliesCount == 0 {
+				continue
+			}
+			c.AuthorID = uuid.NullUUID{}
+			c.AuthorName = DeletedAuthor
+			c.Content = DeletedContent
+		}
+		pruned = append(pruned, c)
+	}
+	return pruned
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTP is the second factor of a user, the secret shared with the authenticator app of the user.
+// The secret has to be readable to compute the codes so it is stored as it is.
+type TOTP struct {
+	UserID uuid.UUID `db:"user_id"`
+	Secret string    `db:"secret"`
+	// LastStep is the time step of the last code accepted, every code is accepted once
+	LastStep  int64     `db:"last_step"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// RecoveryCode logs in in place of a code of the app when the user lost it, once.
+// The code itself is shown once when it is generated, only its hash is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID    `db:"id"`
+	UserID    uuid.UUID    `db:"user_id"`
+	Hash      []byte       `db:"hash"`
+	CreatedAt time.Time    `db:"created_at"`
+	UsedAt    sql.NullTime `db:"used_at"`
+}
+
+type TwoFactorStore interface {
+	// TOTP fails with sql.ErrNoRows when the user has no second factor
+	TOTP(ctx context.Context, userID uuid.UUID) (TOTP, error)
+	// EnableTOTP sets the second factor of the user with its recovery codes, replacing the ones the user had
+	EnableTOTP(ctx context.Context, t *TOTP, codes []RecoveryCode) error
+	// DisableTOTP removes the second factor of the user and the recovery codes with it
+	DisableTOTP(ctx context.Context, userID uuid.UUID) error
+	// UseTOTPStep records the step of a code just accepted. It fails with sql.ErrNoRows when the step is not after
+	// the last one recorded, the code has been used already then.
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error
+	// UseRecoveryCode marks the unused recovery code of the user with the hash used,
+	// it fails with sql.ErrNoRows when the user has no such code
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash []byte) error
+	// RecoveryCodesLeft counts the recovery codes the user has not used
+	RecoveryCodesLeft(ctx context.Context, userID uuid.UUID) (int, error)
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookEvent names a change of the content webhooks can subscribe to
+type WebhookEvent string
+
+const (
+	WebhookThreadCreated  WebhookEvent = "thread.created"
+	WebhookThreadUpdated  WebhookEvent = "thread.updated"
+	WebhookThreadDeleted  WebhookEvent = "thread.deleted"
+	WebhookPostCreated    WebhookEvent = "post.created"
+	WebhookPostUpdated    WebhookEvent = "post.updated"
+	WebhookPostDeleted    WebhookEvent = "post.deleted"
+	WebhookCommentCreated WebhookEvent = "comment.created"
+	WebhookCommentUpdated WebhookEvent = "comment.updated"
+	WebhookCommentDeleted WebhookEvent = "comment.deleted"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []WebhookEvent{
+	WebhookThreadCreated, WebhookThreadUpdated, WebhookThreadDeleted,
+	WebhookPostCreated, WebhookPostUpdated, WebhookPostDeleted,
+	WebhookCommentCreated, WebhookCommentUpdated, WebhookCommentDeleted,
+}
+
+// ContentType returns the type of the content the event is about
+func (e WebhookEvent) ContentType() ContentType {
+	t, _, _ := strings.Cut(string(e), ".")
+	return ContentType(t)
+}
+
+// WebhookEventList is stored as a comma separated list of events
+type WebhookEventList []WebhookEvent
+
+func (l WebhookEventList) Value() (driver.Value, error) {
+	ss := make([]string, len(l))
+	for i, e := range l {
+		ss[i] = string(e)
+	}
+	return strings.Join(ss, ","), nil
+}
+
+func (l *WebhookEventList) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a list of webhook events", src)
+	}
+	*l = WebhookEventList{}
+	if s == "" {
+		return nil
+	}
+	for _, e := range strings.Split(s, ",") {
+		*l = append(*l, WebhookEvent(e))
+	}
+	return nil
+}
+
+// Webhook posts the content events it subscribes to to its url, signed with its secret
+type Webhook struct {
+	ID     uuid.UUID `db:"id"`
+	URL    string    `db:"url"`
+	Secret string    `db:"secret"`
+	// Events filters the events delivered, an empty list subscribes to all of them
+	Events    WebhookEventList `db:"events"`
+	CreatedAt time.Time        `db:"created_at"`
+	UpdatedAt time.Time        `db:"updated_at"`
+}
+
+// Wants reports whether the webhook subscribes to the event
+func (w Webhook) Wants(e WebhookEvent) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, want := range w.Events {
+		if want == e {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryStatus tells where a delivery stands
+type DeliveryStatus string
+
+const (
+	// DeliveryPending deliveries are waiting for their next attempt
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliveryDelivered deliveries got a 2xx answer
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed deliveries ran out of attempts
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is an event queued for a webhook, it keeps the outcome of its last attempt
+type WebhookDelivery struct {
+	ID        uuid.UUID    `db:"id"`
+	WebhookID uuid.UUID    `db:"webhook_id"`
+	Event     WebhookEvent `db:"event"`
+	// Payload is the JSON body posted, the signature is computed over it
+	Payload       []byte         `db:"payload"`
+	Status        DeliveryStatus `db:"status"`
+	Attempts      int            `db:"attempts"`
+	NextAttemptAt time.Time      `db:"next_attempt_at"`
+	LastAttemptAt sql.NullTime   `db:"last_attempt_at"`
+	// ResponseStatus is the status code of the last answer, 0 when the webhook did not answer
+	ResponseStatus int       `db:"response_status"`
+	Error          string    `db:"error"`
+	CreatedAt      time.Time `db:"created_at"`
+}
+
+type WebhookStore interface {
+	// Webhooks lists the webhooks from the oldest
+	Webhooks(ctx context.Context) ([]Webhook, error)
+	Webhook(ctx context.Context, id uuid.UUID) (Webhook, error)
+	CreateWebhook(ctx context.Context, w *Webhook) error
+	UpdateWebhook(ctx context.Context, w *Webhook) error
+	// DeleteWebhook removes the webhook together with its deliveries
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+
+	// CreateDelivery queues a delivery, it is due at its NextAttemptAt
+	CreateDelivery(ctx context.Context, d *WebhookDelivery) error
+	// DeliveriesByWebhook lists the latest deliveries of the webhook, the newest first
+	DeliveriesByWebhook(ctx context.Context, webhookID uuid.UUID, limit int) ([]WebhookDelivery, error)
+	// ClaimDeliveries returns up to limit pending deliveries which are due, the oldest first.
+	// They are postponed by the lease so no other sender picks them up while they are attempted.
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]WebhookDelivery, error)
+	// UpdateDelivery records the outcome of an attempt
+	UpdateDelivery(ctx context.Context, d *WebhookDelivery) error
+}
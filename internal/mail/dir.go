@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DirMailer writes every message to a file of its own in Dir instead of sending it, for development and tests.
+// The files are named after the time they were written so they list in order, and mail clients open them as .eml.
+type DirMailer struct {
+	Dir  string
+	From string
+}
+
+// NewDirMailer creates the directory when the first message is written
+func NewDirMailer(dir, from string) *DirMailer {
+	return &DirMailer{Dir: dir, From: from}
+}
+
+func (m *DirMailer) Send(ctx context.Context, msg Message) error {
+	if err := m.write(msg); err != nil {
+		return fmt.Errorf("error writing mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+func (m *DirMailer) write(msg Message) error {
+	now := time.Now()
+	data, err := format(m.From, msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := now.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+	// the message is written aside and renamed into place, whoever watches the directory never reads half of one
+	tmp, err := os.CreateTemp(m.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(m.Dir, name))
+}
@@ -0,0 +1,214 @@
+// Package oidc signs the users in with OpenID Connect providers, using the authorization code flow with PKCE.
+// It discovers the endpoints of the providers, exchanges the codes and verifies the ID tokens, signed with RS256
+// as every provider has to support.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config describes a provider, the deployments list them in a json file
+type Config struct {
+	// Name identifies the provider in the urls, like "company"
+	Name string `json:"name"`
+	// DisplayName is shown on the buttons, the name is used when it is empty
+	DisplayName  string `json:"display_name"`
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// Scopes are asked for besides openid, email and profile
+	Scopes []string `json:"scopes"`
+}
+
+// LoadConfigs reads the providers from the json file, an array of configs
+func LoadConfigs(path string) ([]Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cc []Config
+	if err := json.Unmarshal(b, &cc); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	names := map[string]bool{}
+	for _, c := range cc {
+		if c.Name == "" || c.Issuer == "" || c.ClientID == "" {
+			return nil, fmt.Errorf("error reading %s: every provider needs a name, an issuer and a client_id", path)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("error reading %s: duplicate provider %q", path, c.Name)
+		}
+		names[c.Name] = true
+	}
+	return cc, nil
+}
+
+// metadata is the part of the discovery document the flow needs
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider. It discovers its endpoints on first use, so the site starts
+// while the provider is down.
+type Provider struct {
+	Config
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *metadata
+	keys *keySet
+}
+
+// NewProvider talks to the provider with the client, a client with a timeout is used when it is nil
+func NewProvider(c Config, client *http.Client) *Provider {
+	if c.DisplayName == "" {
+		c.DisplayName = c.Name
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p := &Provider{Config: c, client: client}
+	p.keys = &keySet{provider: p}
+	return p
+}
+
+// discover fetches the discovery document of the issuer once
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var m metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("error discovering %s: %w", p.Name, err)
+	}
+	// the document has to come from the issuer it names, or tokens of another issuer would pass
+	if m.Issuer != p.Issuer {
+		return nil, fmt.Errorf("error discovering %s: the document is for issuer %q", p.Name, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("error discovering %s: the document lacks endpoints", p.Name)
+	}
+	p.meta = &m
+	return p.meta, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", u, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// AuthURL returns where to send the user to sign in. The state comes back with the code, the nonce in the ID token
+// and the verifier proves the code is exchanged by whoever started the flow.
+func (p *Provider) AuthURL(ctx context.Context, redirectURL, state, nonce, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid", "email", "profile"}, p.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", Challenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange trades the code for the tokens and returns the ID token, still to be verified
+func (p *Provider) Exchange(ctx context.Context, code, redirectURL, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("code_verifier", verifier)
+	v.Set("client_id", p.ClientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error exchanging the code with %s: %w", p.Name, err)
+	}
+	defer res.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("error exchanging the code with %s: %s: %w", p.Name, res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("error exchanging the code with %s: %s: %s %s", p.Name, res.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("error exchanging the code with %s: no id_token", p.Name)
+	}
+	return body.IDToken, nil
+}
+
+// RandomString returns a random url safe string for the states, the nonces and the verifiers
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge derives the S256 code challenge of the verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ErrInvalidToken wraps every reason to reject an ID token
+var ErrInvalidToken = errors.New("invalid id token")
@@ -0,0 +1,188 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://site.test/auth/test/callback"
+
+var alice = oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"}
+
+// authorize runs the browser part of the flow and returns the code the provider sends back
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	u, err := p.AuthURL(context.Background(), redirectURL, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(back.String(), redirectURL+"?") {
+		t.Fatalf("the provider sent the browser to %q", back)
+	}
+	if got := back.Query().Get("state"); got != state {
+		t.Fatalf("the provider sent back the state %q, want %q", got, state)
+	}
+	if e := back.Query().Get("error"); e != "" {
+		t.Fatalf("the provider answered %s", e)
+	}
+	return back.Query().Get("code")
+}
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	idp := oidctest.NewServer("goreddit", "secret")
+	t.Cleanup(idp.Close)
+	idp.SetUser(alice)
+	return idp, oidc.NewProvider(idp.Config("test"), nil)
+}
+
+func TestFlow(t *testing.T) {
+	_, p := newProvider(t)
+	ctx := context.Background()
+
+	code := authorize(t, p, "state", "nonce", "verifier")
+	raw, err := p.Exchange(ctx, code, redirectURL, "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := p.Verify(ctx, raw, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != alice.Subject || c.Email != alice.Email || !c.EmailVerified || c.PreferredUsername != alice.PreferredUsername {
+		t.Errorf("got the claims %+v of %+v", c, alice)
+	}
+
+	// the code is good for one exchange
+	if _, err := p.Exchange(ctx, code, redirectURL, "verifier"); err == nil {
+		t.Error("a code was exchanged twice")
+	}
+}
+
+func TestExchangeChecksVerifier(t *testing.T) {
+	_, p := newProvider(t)
+	code := authorize(t, p, "state", "nonce", "verifier")
+	if _, err := p.Exchange(context.Background(), code, redirectURL, "another verifier"); err == nil {
+		t.Error("a code was exchanged with the verifier of another flow")
+	}
+}
+
+func TestExchangeChecksRedirectURL(t *testing.T) {
+	_, p := newProvider(t)
+	code := authorize(t, p, "state", "nonce", "verifier")
+	if _, err := p.Exchange(context.Background(), code, "http://evil.test/callback", "verifier"); err == nil {
+		t.Error("a code was exchanged for another redirect url")
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		nonce  string
+		tamper func(claims map[string]interface{})
+	}{
+		{name: "nonce of another flow", nonce: "another nonce"},
+		{name: "other audience", nonce: "nonce", tamper: func(c map[string]interface{}) { c["aud"] = "someone-else" }},
+		{name: "other authorized party", nonce: "nonce", tamper: func(c map[string]interface{}) {
+			c["aud"] = []string{"goreddit", "someone-else"}
+			c["azp"] = "someone-else"
+		}},
+		{name: "other issuer", nonce: "nonce", tamper: func(c map[string]interface{}) { c["iss"] = "http://evil.test" }},
+		{name: "expired", nonce: "nonce", tamper: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "issued in the future", nonce: "nonce", tamper: func(c map[string]interface{}) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{name: "no subject", nonce: "nonce", tamper: func(c map[string]interface{}) { c["sub"] = "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp, p := newProvider(t)
+			idp.Tamper(tt.tamper)
+			ctx := context.Background()
+			raw, err := p.Exchange(ctx, authorize(t, p, "state", "nonce", "verifier"), redirectURL, "verifier")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.Verify(ctx, raw, tt.nonce); !errors.Is(err, oidc.ErrInvalidToken) {
+				t.Errorf("got %v, want an invalid token", err)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksSignature(t *testing.T) {
+	idp, p := newProvider(t)
+	ctx := context.Background()
+	raw := idp.Sign(idp.Claims(alice, "nonce"))
+	if _, err := p.Verify(ctx, raw, "nonce"); err != nil {
+		t.Fatalf("a good token was rejected: %v", err)
+	}
+
+	parts := strings.Split(raw, ".")
+	forged := idp.Claims(oidctest.User{Subject: "mallory"}, "nonce")
+	payload := strings.Split(idp.Sign(forged), ".")[1]
+	if _, err := p.Verify(ctx, parts[0]+"."+payload+"."+parts[2], "nonce"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("got %v for claims under the signature of others, want an invalid token", err)
+	}
+
+	// a token signed by another key with the same key id
+	other := oidctest.NewServer("goreddit", "secret")
+	defer other.Close()
+	claims := idp.Claims(alice, "nonce")
+	if _, err := p.Verify(ctx, other.Sign(claims), "nonce"); err == nil {
+		t.Error("a token signed by another key was accepted")
+	}
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	if _, err := p.Verify(ctx, none+"."+parts[1]+".", "nonce"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("got %v for an unsigned token, want an invalid token", err)
+	}
+}
+
+func TestDiscoveryChecksIssuer(t *testing.T) {
+	idp := oidctest.NewServer("goreddit", "secret")
+	defer idp.Close()
+	c := idp.Config("test")
+	// the document names the url of the server, not this one
+	c.Issuer = strings.Replace(idp.URL, "127.0.0.1", "localhost", 1)
+	p := oidc.NewProvider(c, nil)
+	if _, err := p.AuthURL(context.Background(), redirectURL, "state", "nonce", "verifier"); err == nil {
+		t.Error("a discovery document of another issuer was accepted")
+	}
+}
+
+func TestDeniedFlowHasNoCode(t *testing.T) {
+	idp, p := newProvider(t)
+	idp.SetUser(oidctest.User{})
+	u, err := p.AuthURL(context.Background(), redirectURL, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	back, _ := url.Parse(res.Header.Get("Location"))
+	if back.Query().Get("error") != "access_denied" || back.Query().Get("code") != "" {
+		t.Errorf("a denied flow came back to %q", back)
+	}
+}
@@ -0,0 +1,241 @@
+// Package oidctest runs an OpenID Connect provider for the tests of the sign in flows. It signs in whoever its User
+// is without asking anything, and checks the requests of the flow as strictly as a real provider does.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc"
+)
+
+// User is the account signing in at the provider
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// Server is the provider, its issuer is the url of the server
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	mu sync.Mutex
+	// user signs in at the authorization endpoint, nobody does when its subject is empty and the flow is denied
+	user User
+	// tamper changes the claims of the ID tokens before they are signed
+	tamper func(claims map[string]interface{})
+	codes  map[string]grant
+	key    *rsa.PrivateKey
+}
+
+// grant is what the provider remembers of an authorization until its code is exchanged
+type grant struct {
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        User
+}
+
+const keyID = "test-key"
+
+// NewServer starts a provider for the client, the caller closes it
+func NewServer(clientID, clientSecret string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{ClientID: clientID, ClientSecret: clientSecret, codes: map[string]grant{}, key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns the configuration of the provider for the site, under the name
+func (s *Server) Config(name string) oidc.Config {
+	return oidc.Config{Name: name, DisplayName: "Test " + name, Issuer: s.URL, ClientID: s.ClientID, ClientSecret: s.ClientSecret}
+}
+
+// SetUser changes who signs in from now on
+func (s *Server) SetUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.user = u
+}
+
+// Tamper changes the claims of the ID tokens issued from now on, nil stops tampering
+func (s *Server) Tamper(fn func(claims map[string]interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tamper = fn
+}
+
+// Sign signs the claims into an ID token with the key of the provider
+func (s *Server) Sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Claims returns the claims of an ID token of the user, as the provider issues them
+func (s *Server) Claims(u User, nonce string) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                s.URL,
+		"sub":                u.Subject,
+		"aud":                s.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              nonce,
+		"email":              u.Email,
+		"email_verified":     u.EmailVerified,
+		"preferred_username": u.PreferredUsername,
+		"name":               u.Name,
+	}
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize signs the user in at once and sends the browser back with a code, or with an error when the request is
+// invalid or nobody signs in
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Host == "" || q.Get("client_id") != s.ClientID {
+		http.Error(w, "invalid client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	back := redirect.Query()
+	back.Set("state", q.Get("state"))
+
+	s.mu.Lock()
+	user := s.user
+	s.mu.Unlock()
+	switch {
+	case q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "":
+		back.Set("error", "invalid_request")
+	case user.Subject == "":
+		back.Set("error", "access_denied")
+	default:
+		code := random()
+		s.mu.Lock()
+		s.codes[code] = grant{redirectURI: redirect.String(), challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), user: user}
+		s.mu.Unlock()
+		back.Set("code", code)
+	}
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges a code once, for the client which got it and with the verifier of its challenge
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tokenError(w, http.StatusMethodNotAllowed, "invalid_request")
+		return
+	}
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	}
+	if !ok || id != s.ClientID || secret != s.ClientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	s.mu.Lock()
+	g, ok := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code"))
+	tamper := s.tamper
+	s.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	switch {
+	case r.PostFormValue("grant_type") != "authorization_code":
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	case !ok || g.redirectURI != r.PostFormValue("redirect_uri"):
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	case base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge:
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	claims := s.Claims(g.user, g.nonce)
+	if tamper != nil {
+		tamper(claims)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": random(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     s.Sign(claims),
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func random() string {
+	s, err := oidc.RandomString()
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkew is how far the clocks of the provider and of the site may be apart
+	clockSkew = time.Minute
+	// keysRefresh is how often at most the keys are fetched again for an unknown key id, the providers rotate them
+	keysRefresh = time.Minute
+)
+
+// Claims are the claims of an ID token the site reads
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	Expiry          int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	AuthorizedParty string   `json:"azp"`
+
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+}
+
+// audience is a single string or an array of them in the tokens
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the signature of the ID token and that it was issued by the provider, for the site, not long ago
+// and in answer to the flow of the nonce. It returns the claims of the token.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return Claims{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return Claims{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if header.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+
+	key, err := p.keys.key(ctx, header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return Claims{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return Claims{}, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	now := time.Now()
+	switch {
+	case c.Issuer != p.Issuer:
+		return Claims{}, fmt.Errorf("%w: issued by %q", ErrInvalidToken, c.Issuer)
+	case !c.Audience.contains(p.ClientID):
+		return Claims{}, fmt.Errorf("%w: issued for %v", ErrInvalidToken, []string(c.Audience))
+	case len(c.Audience) > 1 && c.AuthorizedParty != p.ClientID:
+		return Claims{}, fmt.Errorf("%w: authorized party %q", ErrInvalidToken, c.AuthorizedParty)
+	case now.After(time.Unix(c.Expiry, 0).Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(clockSkew)):
+		return Claims{}, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case c.Nonce == "" || c.Nonce != nonce:
+		return Claims{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case c.Subject == "":
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return c, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// keySet caches the signing keys of the provider by key id
+type keySet struct {
+	provider *Provider
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// key returns the key of the id, fetching the keys again when it is unknown as the provider may have rotated them
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(s.fetched) < keysRefresh {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	if err := s.fetch(ctx); err != nil {
+		return nil, err
+	}
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+}
+
+// lookup finds the key, a token without key id is accepted when the provider has a single key.
+// The caller has to hold the lock.
+func (s *keySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// fetch reads the json web key set of the provider, keeping the RSA signing keys. The caller has to hold the lock.
+func (s *keySet) fetch(ctx context.Context) error {
+	m, err := s.provider.discover(ctx)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := s.provider.getJSON(ctx, m.JWKSURI, &set); err != nil {
+		return fmt.Errorf("error getting the keys of %s: %w", s.provider.Name, err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	s.keys, s.fetched = keys, time.Now()
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package policy decides who may create, edit, delete, vote on or moderate the content. The handlers enforce it
+// and the templates ask it which controls to show, so both always agree.
+package policy
+
+import (
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// Action is what a user does to a resource
+type Action string
+
+const (
+	// Create adds content to the resource: a thread to the site, a post to a thread,
+	// a comment to a post or a reply to a comment
+	Create Action = "create"
+	Edit   Action = "edit"
+	// Delete moves the resource to the trash
+	Delete Action = "delete"
+	Vote   Action = "vote"
+	// Pin pins and unpins a post on its thread
+	Pin Action = "pin"
+	// Lock locks and unlocks a thread
+	Lock Action = "lock"
+	// Appoint adds and removes the moderators of a thread
+	Appoint Action = "appoint"
+)
+
+// Resource is the content an action applies to, the zero Resource is the site itself where the threads are created
+type Resource struct {
+	Type     store.ContentType
+	AuthorID uuid.NullUUID
+	// ThreadID is the thread the content belongs to, its moderators moderate the content
+	ThreadID uuid.UUID
+	// Locked is set on the locked threads
+	Locked bool
+	// Deleted is set while the content is in the trash, nothing can be done to it but a restoration by an admin
+	Deleted bool
+}
+
+// Site is the resource the threads are created in
+func Site() Resource {
+	return Resource{}
+}
+
+func Thread(t store.Thread) Resource {
+	return Resource{Type: store.ContentThread, AuthorID: t.AuthorID, ThreadID: t.ID, Locked: t.Locked, Deleted: t.DeletedAt.Valid}
+}
+
+func Post(p store.Post) Resource {
+	return Resource{Type: store.ContentPost, AuthorID: p.AuthorID, ThreadID: p.ThreadID, Deleted: p.DeletedAt.Valid}
+}
+
+func Comment(c store.Comment) Resource {
+	return Resource{Type: store.ContentComment, AuthorID: c.AuthorID, ThreadID: c.ThreadID, Deleted: c.DeletedAt.Valid}
+}
+
+// Can reports whether the user may do the action on the resource. Anonymous visitors are the zero user and may do nothing.
+//
+// Every user creates content, but posts in the locked threads, and votes on posts and comments. Only the authors edit
+// their content. The authors delete it too, as do the moderators of the thread for the posts and the comments
+// and the admins for everything. The moderators pin the posts and lock the thread, the admins appoint the moderators.
+func Can(u store.User, a Action, r Resource) bool {
+	if u.ID == uuid.Nil || r.Deleted {
+		return false
+	}
+	switch a {
+	case Create:
+		return !r.Locked || u.Moderator(r.ThreadID)
+	case Vote:
+		return r.Type == store.ContentPost || r.Type == store.ContentComment
+	case Edit:
+		return r.Type != "" && isAuthor(u, r)
+	case Delete:
+		if r.Type == store.ContentThread {
+			return isAuthor(u, r) || u.Admin()
+		}
+		return r.Type != "" && (isAuthor(u, r) || u.Moderator(r.ThreadID))
+	case Pin:
+		return r.Type == store.ContentPost && u.Moderator(r.ThreadID)
+	case Lock:
+		return r.Type == store.ContentThread && u.Moderator(r.ThreadID)
+	case Appoint:
+		return r.Type == store.ContentThread && u.Admin()
+	}
+	return false
+}
+
+// Moderates reports whether the user acts as a moderator rather than as the author when doing the action,
+// such actions go to the mod log of the thread
+func Moderates(u store.User, a Action, r Resource) bool {
+	switch a {
+	case Pin, Lock, Appoint:
+		return true
+	case Delete:
+		return !isAuthor(u, r)
+	}
+	return false
+}
+
+func isAuthor(u store.User, r Resource) bool {
+	return r.AuthorID.Valid && r.AuthorID.UUID == u.ID
+}
@@ -0,0 +1,238 @@
+package web
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/archive"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// importMaxSize is the largest archive the import accepts, in bytes. The upload and the import in its transaction have
+// to fit in the timeout of a request, the larger archives go through the archive command.
+const importMaxSize = 4 << 20
+
+type AdminHandler struct {
+	store    store.Store
+	sessions *scs.SessionManager
+}
+
+func (h *AdminHandler) trashView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		PageData
+		Items []store.TrashItem
+		CSRF  template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/trash.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		ii, cc, err := h.store.Trash(r.Context(), parsePage(r))
+		if err != nil {
+			listingError(w, err)
+			return
+		}
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			PageData:    newPageData(r, cc),
+			Items:       ii,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *AdminHandler) restore() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		//parse the type and the id of the item
+		t := store.ContentType(chi.URLParam(r, "type"))
+		switch t {
+		case store.ContentThread, store.ContentPost, store.ContentComment:
+		default:
+			http.Error(w, "invalid content type", http.StatusBadRequest)
+			return
+		}
+		id, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// the restoration goes to the mod log of the thread of the item
+		item, err := contentByType(r.Context(), h.store, t, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user, _ := UserFromContext(r.Context())
+		if err := moderate(r.Context(), h.store, modAction(user, restorations[t], item), func(s store.Store) error {
+			return s.Restore(r.Context(), t, id)
+		}); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.NotFound(w, r)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// add flash message
+		h.sessions.Put(r.Context(), "flash", "The "+string(t)+" has been restored.")
+
+		// redirect to the trash
+		http.Redirect(w, r, "/admin/trash", http.StatusFound)
+	}
+}
+
+// exportThread downloads the archive of the thread, see the archive package
+func (h *AdminHandler) exportThread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a, err := archive.Export(r.Context(), h.store, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="thread-`+id.String()+`.json"`)
+		archive.Write(w, a)
+	}
+}
+
+func (h *AdminHandler) importView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		// MaxSize is the largest archive in MB
+		MaxSize int
+		CSRF    template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/import.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			MaxSize:     importMaxSize >> 20,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+// importArchive imports the archive uploaded in the archive field of a multipart form
+func (h *AdminHandler) importArchive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, importMaxSize)
+		form := ImportForm{
+			Merge:       r.FormValue("merge") != "",
+			CreateUsers: r.FormValue("create_users") != "",
+		}
+		var a *archive.Archive
+		f, _, err := r.FormFile("archive")
+		if err != nil {
+			form.Invalid = fmt.Sprintf("Please choose an archive of at most %d MB, import the larger ones with the archive command.", importMaxSize>>20)
+		} else {
+			defer f.Close()
+			if a, err = archive.Read(f); err != nil {
+				form.Invalid = err.Error()
+			}
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, "/admin/import", http.StatusFound)
+			return
+		}
+
+		res, err := archive.Import(r.Context(), h.store, a, archive.Options{Merge: form.Merge, CreateUsers: form.CreateUsers})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", fmt.Sprintf("The thread has been imported: %d posts, %d comments, %d votes and %d users created, %d items kept, %d renamed, %d users missing.",
+			res.Posts, res.Comments, res.Votes, res.Users, res.Kept, res.Renamed, res.MissingUsers))
+		http.Redirect(w, r, "/threads/"+res.ThreadID.String(), http.StatusFound)
+	}
+}
+
+// lockoutKinds describe the limits on the lockouts page
+var lockoutKinds = map[string]string{
+	loginUserLimit.prefix:  "Account",
+	loginIPLimit.prefix:    "Login address",
+	registerIPLimit.prefix: "Registration address",
+	resetUserLimit.prefix:  "Password reset account",
+	resetIPLimit.prefix:    "Password reset address",
+}
+
+// lockoutItem is a lockout as the lockouts page shows it, the username or the address with the kind of limit
+type lockoutItem struct {
+	store.Lockout
+	Kind    string
+	Subject string
+}
+
+func (h *AdminHandler) lockoutsView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Lockouts []lockoutItem
+		CSRF     template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/lockouts.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		ll, err := h.store.Lockouts(r.Context(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		items := make([]lockoutItem, len(ll))
+		for i, l := range ll {
+			items[i] = lockoutItem{Lockout: l, Kind: "Other", Subject: l.Key}
+			for prefix, kind := range lockoutKinds {
+				if strings.HasPrefix(l.Key, prefix) {
+					items[i].Kind, items[i].Subject = kind, strings.TrimPrefix(l.Key, prefix)
+				}
+			}
+		}
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			Lockouts:    items,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+// unlock lets the account or the address of the key in again and forgets its attempts
+func (h *AdminHandler) unlock() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.FormValue("key")
+		if err := h.store.ClearLockout(r.Context(), key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user, _ := UserFromContext(r.Context())
+		securityEvent("%s unlocked by %s", key, user.Username)
+
+		h.sessions.Put(r.Context(), "flash", "The lockout has been lifted.")
+		http.Redirect(w, r, "/admin/lockouts", http.StatusFound)
+	}
+}
@@ -0,0 +1,337 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// APIHandler serves the JSON api, it shares the store and the sessions of the html pages
+type APIHandler struct {
+	store    store.Store
+	sessions *scs.SessionManager
+	// levels of replies returned below a comment
+	commentDepth int
+	// verifiedPosting keeps the users who have not verified their address from posting
+	verifiedPosting bool
+}
+
+// Routes returns the router of version 1 of the api
+func (h *APIHandler) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(exposeCSRFToken)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, "no such endpoint")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	})
+
+	r.Get("/threads", h.listThreads())
+	r.Get("/threads/{threadId}", h.getThread())
+	r.Get("/threads/{threadId}/posts", h.listThreadPosts())
+	r.Get("/posts", h.listPosts())
+	r.Get("/posts/{postId}", h.getPost())
+	r.Get("/posts/{postId}/comments", h.listComments())
+	r.Get("/comments/{commentId}", h.getComment())
+
+	r.Group(func(r chi.Router) {
+		r.Use(apiRequireUser)
+		r.With(h.requireVerified).Post("/threads", h.createThread())
+		r.Put("/threads/{threadId}", h.updateThread())
+		r.Delete("/threads/{threadId}", h.deleteThread())
+		r.With(h.requireVerified).Post("/threads/{threadId}/posts", h.createPost())
+		r.Put("/posts/{postId}", h.updatePost())
+		r.Delete("/posts/{postId}", h.deletePost())
+		r.Put("/posts/{postId}/vote", h.votePost())
+		r.With(h.requireVerified).Post("/posts/{postId}/comments", h.createComment())
+		r.Put("/comments/{commentId}", h.updateComment())
+		r.Delete("/comments/{commentId}", h.deleteComment())
+		r.Put("/comments/{commentId}/vote", h.voteComment())
+	})
+	return r
+}
+
+// exposeCSRFToken sends the csrf token in a header, clients using the session cookie send it back in the
+// X-CSRF-Token header of the requests changing something
+func exposeCSRFToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-CSRF-Token", csrf.Token(r))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireVerified answers 403 to the users who have not verified their address when the deployment wants them to
+// before posting, it has to run after apiRequireUser
+func (h *APIHandler) requireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := UserFromContext(r.Context()); h.verifiedPosting && !user.EmailVerified {
+			apiError(w, http.StatusForbidden, "verify your email address before posting")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRequireUser answers 401 to anonymous clients, it has to run after withUser
+func apiRequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserFromContext(r.Context()); !ok {
+			apiError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiResponse is the envelope of every successful response, Cursors is set by listings only
+type apiResponse struct {
+	Data    interface{} `json:"data"`
+	Cursors *apiCursors `json:"cursors,omitempty"`
+}
+
+// apiCursors are passed back in the cursor query parameter to get the pages around the current one
+type apiCursors struct {
+	Prev string `json:"prev,omitempty"`
+	Next string `json:"next,omitempty"`
+}
+
+// apiErrorResponse is the envelope of every error, Fields holds the validation errors by field name
+type apiErrorResponse struct {
+	Error struct {
+		Status  int               `json:"status"`
+		Message string            `json:"message"`
+		Fields  map[string]string `json:"fields,omitempty"`
+	} `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeData(w http.ResponseWriter, status int, data interface{}) {
+	writeJSON(w, status, apiResponse{Data: data})
+}
+
+func writeList(w http.ResponseWriter, data interface{}, cc store.Cursors) {
+	writeJSON(w, http.StatusOK, apiResponse{Data: data, Cursors: &apiCursors{Prev: cc.Prev, Next: cc.Next}})
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	var e apiErrorResponse
+	e.Error.Status = status
+	e.Error.Message = message
+	writeJSON(w, status, e)
+}
+
+// apiValidationError answers 422 with the errors of a form keyed by the json name of their fields
+func apiValidationError(w http.ResponseWriter, fe FormErrors) {
+	var e apiErrorResponse
+	e.Error.Status = http.StatusUnprocessableEntity
+	e.Error.Message = "validation failed"
+	e.Error.Fields = make(map[string]string, len(fe))
+	for field, msg := range fe {
+		e.Error.Fields[jsonName(field)] = msg
+	}
+	writeJSON(w, http.StatusUnprocessableEntity, e)
+}
+
+// jsonName turns the name of a form field into the json one, e.g. ParentID into parent_id
+func jsonName(field string) string {
+	var b strings.Builder
+	for i, r := range field {
+		if unicode.IsUpper(r) && i > 0 && unicode.IsLower(rune(field[i-1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// csrfFailure answers to the requests failing the csrf check, with a json error on the api
+func csrfFailure(w http.ResponseWriter, r *http.Request) {
+	msg := http.StatusText(http.StatusForbidden) + " - " + csrf.FailureReason(r).Error()
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		apiError(w, http.StatusForbidden, msg)
+		return
+	}
+	http.Error(w, msg, http.StatusForbidden)
+}
+
+// apiStoreError answers to a failed store call, missing rows are 404 and stale cursors 400
+func apiStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		apiError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, store.ErrInvalidCursor):
+		apiError(w, http.StatusBadRequest, err.Error())
+	default:
+		apiError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// decodeJSON reads the json body into v, only json bodies are accepted so html forms of other sites cannot post here
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		apiError(w, http.StatusUnsupportedMediaType, "the body must be application/json")
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid json body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// urlID parses the uuid in the url parameter
+func urlID(w http.ResponseWriter, r *http.Request, param string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, param))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "invalid "+param+": "+err.Error())
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// the representations of the api are kept apart from the store types so the store can change without breaking v1
+
+type apiThread struct {
+	ID          uuid.UUID  `json:"id"`
+	AuthorID    *uuid.UUID `json:"author_id"`
+	Author      string     `json:"author"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Locked      bool       `json:"locked"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type apiPost struct {
+	ID            uuid.UUID  `json:"id"`
+	ThreadID      uuid.UUID  `json:"thread_id"`
+	ThreadTitle   string     `json:"thread_title,omitempty"`
+	AuthorID      *uuid.UUID `json:"author_id"`
+	Author        string     `json:"author"`
+	Title         string     `json:"title"`
+	Content       string     `json:"content"`
+	Votes         int        `json:"votes"`
+	CommentsCount int        `json:"comments_count"`
+	Pinned        bool       `json:"pinned"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+type apiComment struct {
+	ID        uuid.UUID  `json:"id"`
+	PostID    uuid.UUID  `json:"post_id"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	AuthorID  *uuid.UUID `json:"author_id"`
+	Author    string     `json:"author"`
+	Content   string     `json:"content"`
+	Votes     int        `json:"votes"`
+	Deleted   bool       `json:"deleted"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	// set in trees only, replies_count tells if there are replies deeper than the ones returned
+	Replies      []apiComment `json:"replies,omitempty"`
+	RepliesCount int          `json:"replies_count,omitempty"`
+}
+
+// apiVote is the body of the vote endpoints, 0 retracts the vote
+type apiVote struct {
+	Value int `json:"value"`
+}
+
+func nullableID(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+func newAPIThread(t store.Thread) apiThread {
+	return apiThread{
+		ID:          t.ID,
+		AuthorID:    nullableID(t.AuthorID),
+		Author:      t.AuthorName,
+		Title:       t.Title,
+		Description: t.Description,
+		Locked:      t.Locked,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+}
+
+func newAPIPost(p store.Post) apiPost {
+	return apiPost{
+		ID:            p.ID,
+		ThreadID:      p.ThreadID,
+		ThreadTitle:   p.ThreadTitle,
+		AuthorID:      nullableID(p.AuthorID),
+		Author:        p.AuthorName,
+		Title:         p.Title,
+		Content:       p.Content,
+		Votes:         p.Votes,
+		CommentsCount: p.CommentsCount,
+		Pinned:        p.Pinned,
+		CreatedAt:     p.CreatedAt,
+		UpdatedAt:     p.UpdatedAt,
+	}
+}
+
+func newAPIComment(c store.Comment) apiComment {
+	ac := apiComment{
+		ID:           c.ID,
+		PostID:       c.PostID,
+		ParentID:     nullableID(c.ParentID),
+		AuthorID:     nullableID(c.AuthorID),
+		Author:       c.AuthorName,
+		Content:      c.Content,
+		Votes:        c.Votes,
+		Deleted:      c.DeletedAt.Valid,
+		CreatedAt:    c.CreatedAt,
+		UpdatedAt:    c.UpdatedAt,
+		RepliesCount: c.RepliesCount,
+	}
+	for _, r := range c.Replies {
+		ac.Replies = append(ac.Replies, newAPIComment(r))
+	}
+	return ac
+}
+
+func newAPIThreads(tt []store.Thread) []apiThread {
+	at := make([]apiThread, len(tt))
+	for i, t := range tt {
+		at[i] = newAPIThread(t)
+	}
+	return at
+}
+
+func newAPIPosts(pp []store.Post) []apiPost {
+	ap := make([]apiPost, len(pp))
+	for i, p := range pp {
+		ap[i] = newAPIPost(p)
+	}
+	return ap
+}
+
+func newAPIComments(cc []store.Comment) []apiComment {
+	ac := make([]apiComment, len(cc))
+	for i, c := range cc {
+		ac[i] = newAPIComment(c)
+	}
+	return ac
+}
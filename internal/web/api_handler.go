@@ -0,0 +1,551 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/policy"
+)
+
+// apiAuthorize answers 403 when the user of the request may not do the action on the resource
+func apiAuthorize(w http.ResponseWriter, r *http.Request, a policy.Action, res policy.Resource) bool {
+	user, _ := UserFromContext(r.Context())
+	if policy.Can(user, a, res) {
+		return true
+	}
+	apiError(w, http.StatusForbidden, deniedMessage(a, res))
+	return false
+}
+
+// thread retrieves the thread in the url, the threads in the trash are not found
+func (h *APIHandler) thread(w http.ResponseWriter, r *http.Request) (store.Thread, bool) {
+	id, ok := urlID(w, r, "threadId")
+	if !ok {
+		return store.Thread{}, false
+	}
+	t, err := h.store.Thread(r.Context(), id)
+	if err != nil {
+		apiStoreError(w, err)
+		return store.Thread{}, false
+	}
+	if t.DeletedAt.Valid {
+		apiError(w, http.StatusNotFound, "not found")
+		return store.Thread{}, false
+	}
+	return t, true
+}
+
+// post retrieves the post in the url, the posts in the trash or in a thread in the trash are not found
+func (h *APIHandler) post(w http.ResponseWriter, r *http.Request) (store.Post, bool) {
+	id, ok := urlID(w, r, "postId")
+	if !ok {
+		return store.Post{}, false
+	}
+	p, err := h.store.Post(r.Context(), id)
+	if err != nil {
+		apiStoreError(w, err)
+		return store.Post{}, false
+	}
+	t, err := h.store.Thread(r.Context(), p.ThreadID)
+	if err != nil {
+		apiStoreError(w, err)
+		return store.Post{}, false
+	}
+	if p.DeletedAt.Valid || t.DeletedAt.Valid {
+		apiError(w, http.StatusNotFound, "not found")
+		return store.Post{}, false
+	}
+	return p, true
+}
+
+// comment retrieves the comment in the url, the comments in the trash are not found
+func (h *APIHandler) comment(w http.ResponseWriter, r *http.Request) (store.Comment, bool) {
+	id, ok := urlID(w, r, "commentId")
+	if !ok {
+		return store.Comment{}, false
+	}
+	c, err := h.store.Comment(r.Context(), id)
+	if err != nil {
+		apiStoreError(w, err)
+		return store.Comment{}, false
+	}
+	if c.DeletedAt.Valid {
+		apiError(w, http.StatusNotFound, "not found")
+		return store.Comment{}, false
+	}
+	return c, true
+}
+
+// threads
+
+func (h *APIHandler) listThreads() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tt, cc, err := h.store.ThreadsPage(r.Context(), parsePage(r))
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeList(w, newAPIThreads(tt), cc)
+	}
+}
+
+func (h *APIHandler) getThread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if t, ok := h.thread(w, r); ok {
+			writeData(w, http.StatusOK, newAPIThread(t))
+		}
+	}
+}
+
+func (h *APIHandler) createThread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !apiAuthorize(w, r, policy.Create, policy.Site()) {
+			return
+		}
+		var form CreateThreadForm
+		if !decodeJSON(w, r, &form) {
+			return
+		}
+		if !form.Validate() {
+			apiValidationError(w, form.Errors)
+			return
+		}
+
+		user, _ := UserFromContext(r.Context())
+		t := &store.Thread{
+			ID:          uuid.New(),
+			AuthorID:    uuid.NullUUID{UUID: user.ID, Valid: true},
+			Title:       form.Title,
+			Description: form.Description,
+		}
+		if err := h.store.CreateThread(r.Context(), t); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookThreadCreated, t.ID)
+		// read it back to get the computed fields
+		created, err := h.store.Thread(r.Context(), t.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/threads/"+t.ID.String())
+		writeData(w, http.StatusCreated, newAPIThread(created))
+	}
+}
+
+func (h *APIHandler) updateThread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Edit, policy.Thread(t)) {
+			return
+		}
+
+		var form CreateThreadForm
+		if !decodeJSON(w, r, &form) {
+			return
+		}
+		if !form.Validate() {
+			apiValidationError(w, form.Errors)
+			return
+		}
+
+		t.Title, t.Description = form.Title, form.Description
+		if err := h.store.UpdateThread(r.Context(), &t); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookThreadUpdated, t.ID)
+		updated, err := h.store.Thread(r.Context(), t.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, newAPIThread(updated))
+	}
+}
+
+func (h *APIHandler) deleteThread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Delete, policy.Thread(t)) {
+			return
+		}
+		user, _ := UserFromContext(r.Context())
+		if _, err := remove(r.Context(), h.store, user, t, func(s store.Store) error {
+			return s.DeleteThread(r.Context(), t.ID)
+		}); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookThreadDeleted, t.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// posts
+
+func (h *APIHandler) listPosts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sort, _ := parsePostSort(r.URL.Query())
+		pp, cc, err := h.store.PostsPage(r.Context(), sort, parsePage(r))
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeList(w, newAPIPosts(pp), cc)
+	}
+}
+
+func (h *APIHandler) listThreadPosts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		sort, _ := parsePostSort(r.URL.Query())
+		pp, cc, err := h.store.PostsByThreadPage(r.Context(), t.ID, sort, parsePage(r))
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeList(w, newAPIPosts(pp), cc)
+	}
+}
+
+func (h *APIHandler) getPost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := h.post(w, r); ok {
+			writeData(w, http.StatusOK, newAPIPost(p))
+		}
+	}
+}
+
+func (h *APIHandler) createPost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Create, policy.Thread(t)) {
+			return
+		}
+
+		var form CreatePostForm
+		if !decodeJSON(w, r, &form) {
+			return
+		}
+		if !form.Validate() {
+			apiValidationError(w, form.Errors)
+			return
+		}
+
+		user, _ := UserFromContext(r.Context())
+		p := &store.Post{
+			ID:       uuid.New(),
+			ThreadID: t.ID,
+			AuthorID: uuid.NullUUID{UUID: user.ID, Valid: true},
+			Title:    form.Title,
+			Content:  form.Content,
+		}
+		if err := h.store.CreatePost(r.Context(), p); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookPostCreated, p.ID)
+		created, err := h.store.Post(r.Context(), p.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/posts/"+p.ID.String())
+		writeData(w, http.StatusCreated, newAPIPost(created))
+	}
+}
+
+func (h *APIHandler) updatePost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Edit, policy.Post(p)) {
+			return
+		}
+
+		var form CreatePostForm
+		if !decodeJSON(w, r, &form) {
+			return
+		}
+		if !form.Validate() {
+			apiValidationError(w, form.Errors)
+			return
+		}
+
+		p.Title, p.Content = form.Title, form.Content
+		if err := h.store.UpdatePost(r.Context(), &p); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookPostUpdated, p.ID)
+		updated, err := h.store.Post(r.Context(), p.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, newAPIPost(updated))
+	}
+}
+
+func (h *APIHandler) deletePost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Delete, policy.Post(p)) {
+			return
+		}
+		user, _ := UserFromContext(r.Context())
+		if _, err := remove(r.Context(), h.store, user, p, func(s store.Store) error {
+			return s.DeletePost(r.Context(), p.ID)
+		}); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookPostDeleted, p.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *APIHandler) votePost() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Vote, policy.Post(p)) {
+			return
+		}
+		var v apiVote
+		if !decodeJSON(w, r, &v) {
+			return
+		}
+		if v.Value < store.VoteDown || v.Value > store.VoteUp {
+			apiValidationError(w, FormErrors{"Value": "Value must be -1, 0 or 1"})
+			return
+		}
+
+		user, _ := UserFromContext(r.Context())
+		var err error
+		if v.Value == store.VoteNone {
+			err = h.store.RetractPostVote(r.Context(), user.ID, p.ID)
+		} else {
+			err = h.store.VotePost(r.Context(), user.ID, p.ID, v.Value)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		voted, err := h.store.Post(r.Context(), p.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, newAPIPost(voted))
+	}
+}
+
+// comments
+
+func (h *APIHandler) listComments() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		cc, cursors, err := h.store.CommentTree(r.Context(), p.ID, parsePage(r), h.commentDepth)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeList(w, newAPIComments(cc), cursors)
+	}
+}
+
+// getComment returns the comment with its replies, a deleted comment is found as long as it holds replies
+func (h *APIHandler) getComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := urlID(w, r, "commentId")
+		if !ok {
+			return
+		}
+		c, err := h.store.CommentThread(r.Context(), id, h.commentDepth)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, newAPIComment(c))
+	}
+}
+
+func (h *APIHandler) createComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Create, policy.Post(p)) {
+			return
+		}
+
+		var form CreateCommentForm
+		if !decodeJSON(w, r, &form) {
+			return
+		}
+		if !form.Validate() {
+			apiValidationError(w, form.Errors)
+			return
+		}
+
+		// a reply has to answer a comment of the same post
+		var parentID uuid.NullUUID
+		if form.ParentID != "" {
+			id, err := uuid.Parse(form.ParentID)
+			if err != nil {
+				apiValidationError(w, FormErrors{"ParentID": "Parent is not a valid id"})
+				return
+			}
+			parent, err := h.store.Comment(r.Context(), id)
+			if err != nil || parent.PostID != p.ID || parent.DeletedAt.Valid {
+				apiValidationError(w, FormErrors{"ParentID": "Parent is not a comment of this post"})
+				return
+			}
+			if !apiAuthorize(w, r, policy.Create, policy.Comment(parent)) {
+				return
+			}
+			parentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		}
+
+		user, _ := UserFromContext(r.Context())
+		c := &store.Comment{
+			ID:       uuid.New(),
+			PostID:   p.ID,
+			ParentID: parentID,
+			AuthorID: uuid.NullUUID{UUID: user.ID, Valid: true},
+			Content:  form.Content,
+		}
+		if err := h.store.CreateComment(r.Context(), c); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookCommentCreated, c.ID)
+		created, err := h.store.Comment(r.Context(), c.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v1/comments/"+c.ID.String())
+		writeData(w, http.StatusCreated, newAPIComment(created))
+	}
+}
+
+func (h *APIHandler) updateComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.comment(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Edit, policy.Comment(c)) {
+			return
+		}
+
+		var form CreateCommentForm
+		if !decodeJSON(w, r, &form) {
+			return
+		}
+		if !form.Validate() {
+			apiValidationError(w, form.Errors)
+			return
+		}
+
+		c.Content = form.Content
+		if err := h.store.UpdateComment(r.Context(), &c); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookCommentUpdated, c.ID)
+		updated, err := h.store.Comment(r.Context(), c.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, newAPIComment(updated))
+	}
+}
+
+func (h *APIHandler) deleteComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.comment(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Delete, policy.Comment(c)) {
+			return
+		}
+		user, _ := UserFromContext(r.Context())
+		if _, err := remove(r.Context(), h.store, user, c, func(s store.Store) error {
+			return s.DeleteComment(r.Context(), c.ID)
+		}); err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookCommentDeleted, c.ID)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (h *APIHandler) voteComment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := h.comment(w, r)
+		if !ok {
+			return
+		}
+		if !apiAuthorize(w, r, policy.Vote, policy.Comment(c)) {
+			return
+		}
+		var v apiVote
+		if !decodeJSON(w, r, &v) {
+			return
+		}
+		if v.Value < store.VoteDown || v.Value > store.VoteUp {
+			apiValidationError(w, FormErrors{"Value": "Value must be -1, 0 or 1"})
+			return
+		}
+
+		user, _ := UserFromContext(r.Context())
+		var err error
+		if v.Value == store.VoteNone {
+			err = h.store.RetractCommentVote(r.Context(), user.ID, c.ID)
+		} else {
+			err = h.store.VoteComment(r.Context(), user.ID, c.ID, v.Value)
+		}
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		voted, err := h.store.Comment(r.Context(), c.ID)
+		if err != nil {
+			apiStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, newAPIComment(voted))
+	}
+}
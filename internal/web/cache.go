@@ -0,0 +1,100 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// csrfCookie is the cookie holding the token the csrf fields of the pages are made from
+const csrfCookie = "_gorilla_csrf"
+
+// sharedMaxAge is how many seconds the shared caches may serve an anonymous page before revalidating it
+const sharedMaxAge = 60
+
+// started changes the validators of every page when the server restarts, since the templates may have changed with it
+var started = time.Now()
+
+// notModified sets the caching headers of a page rendered from content last changed at modified and answers 304
+// when the request is conditional and the copy of the client is still fresh, then the handler is done.
+// A zero modified sets no validators, for the pages which change with the clock.
+//
+// The anonymous visitors all get the same page, so the shared caches may keep it when shared is set.
+// The pages showing per-user state, the vote markers of a user or the csrf fields, are private: only the browser
+// keeps them and revalidates them on every use. The pages showing a flash message or a form sent back are not
+// kept at all since the session forgets them once shown.
+func notModified(w http.ResponseWriter, r *http.Request, sessions *scs.SessionManager, modified time.Time, shared bool) bool {
+	// the page depends on the cookies, the csrf and the session middlewares tell the caches with Vary: Cookie already
+	if sessions.Exists(r.Context(), "flash") || sessions.Exists(r.Context(), "form") {
+		w.Header().Set("Cache-Control", "no-store")
+		return false
+	}
+
+	user, loggedIn := UserFromContext(r.Context())
+	// a new csrf cookie is being set, a shared cache must not hand it to everybody
+	if loggedIn || w.Header().Get("Set-Cookie") != "" {
+		shared = false
+	}
+	if shared {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=0, s-maxage=%d", sharedMaxAge))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	if modified.IsZero() {
+		return false
+	}
+
+	// the tag covers everything the page is made of besides the content: the url with the listing options,
+	// the user with the username, the role and the moderated threads which change the controls, and the token of the csrf fields
+	h := sha256.New()
+	fmt.Fprintf(h, "%d\n%d\n%s\n", started.UnixNano(), modified.UnixNano(), r.URL.RequestURI())
+	if loggedIn {
+		fmt.Fprintf(h, "%s\n%s\n%s\n%v\n", user.ID, user.Username, user.Role, user.Moderates)
+	}
+	if !shared {
+		if c, err := r.Cookie(csrfCookie); err == nil {
+			fmt.Fprintf(h, "%s\n", c.Value)
+		}
+	}
+	// weak since the csrf fields are masked differently on every rendering
+	etag := `W/"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	// If-None-Match takes precedence, If-Modified-Since only counts without it
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		// the header has a precision of a second, like the Last-Modified it comes from
+		if err != nil || modified.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+	w.Header().Del("Content-Type")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch compares the tags of an If-None-Match header with the weak comparison
+func etagMatch(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,18 @@
 package web
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
 	"net/http"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/policy"
 )
 
 type CommentHandler struct {
@@ -27,15 +33,23 @@ func (h *CommentHandler) save() http.HandlerFunc {
 		}
 
 		// retrieve the post from db to verify that it exists
-		p, err := h.store.Post(postId)
+		p, err := h.store.Post(r.Context(), postId)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if p.DeletedAt.Valid {
+			http.NotFound(w, r)
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Create, policy.Post(p)) {
+			return
+		}
 
 		//parse the form for new comment info
 		form := CreateCommentForm{
-			Content: r.FormValue("content"),
+			Content:  r.FormValue("content"),
+			ParentID: r.FormValue("parent_id"),
 		}
 
 		if !form.Validate() {
@@ -44,15 +58,47 @@ func (h *CommentHandler) save() http.HandlerFunc {
 			return
 		}
 
-		//send new comment to db
-		if err := h.store.CreateComment(&store.Comment{
-			ID:      uuid.New(),
-			PostID:  p.ID,
-			Content: form.Content,
-		}); err != nil {
+		// a reply has to answer a comment of the same post
+		var parentID uuid.NullUUID
+		if form.ParentID != "" {
+			id, err := uuid.Parse(form.ParentID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			parent, err := h.store.Comment(r.Context(), id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if parent.PostID != p.ID {
+				http.Error(w, "the comment answered belongs to another post", http.StatusBadRequest)
+				return
+			}
+			if parent.DeletedAt.Valid {
+				http.Error(w, "the comment answered has been deleted", http.StatusBadRequest)
+				return
+			}
+			if !authorize(w, r, h.sessions, policy.Create, policy.Comment(parent)) {
+				return
+			}
+			parentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		}
+
+		//send new comment to db, the author is the logged in user
+		user, _ := UserFromContext(r.Context())
+		c := &store.Comment{
+			ID:       uuid.New(),
+			PostID:   p.ID,
+			ParentID: parentID,
+			AuthorID: uuid.NullUUID{UUID: user.ID, Valid: true},
+			Content:  form.Content,
+		}
+		if err := h.store.CreateComment(r.Context(), c); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookCommentCreated, c.ID)
 
 		// add flash message
 		h.sessions.Put(r.Context(), "flash", "Your comment has been submitted.")
@@ -62,6 +108,48 @@ func (h *CommentHandler) save() http.HandlerFunc {
 	}
 }
 
+func (h *CommentHandler) delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		//parse the comment id
+		idStr := chi.URLParam(r, "id")
+
+		//parse and validate the id
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// retrieve the comment
+		c, err := h.store.Comment(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !authorize(w, r, h.sessions, policy.Delete, policy.Comment(c)) {
+			return
+		}
+
+		//move comment to the trash
+		user, _ := UserFromContext(r.Context())
+		moderated, err := remove(r.Context(), h.store, user, c, func(s store.Store) error {
+			return s.DeleteComment(r.Context(), c.ID)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookCommentDeleted, c.ID)
+
+		// add flash message
+		h.sessions.Put(r.Context(), "flash", removedMessage(store.ContentComment, moderated))
+
+		// redirect to the same page
+		http.Redirect(w, r, r.Referer(), http.StatusFound)
+	}
+}
+
 func (h *CommentHandler) vote() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		//parse the comment id
@@ -75,22 +163,40 @@ func (h *CommentHandler) vote() http.HandlerFunc {
 		}
 
 		// retrieve the comment
-		c, err := h.store.Comment(id)
+		c, err := h.store.Comment(r.Context(), id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if c.DeletedAt.Valid {
+			http.NotFound(w, r)
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Vote, policy.Comment(c)) {
+			return
+		}
 
-		//increase/decrease the vote
-		dir := r.URL.Query().Get("dir")
-		if dir == "up" {
-			c.Votes++
-		} else if dir == "down" {
-			c.Votes--
+		// parse the direction of the vote
+		value, ok := voteValue(r.FormValue("dir"))
+		if !ok {
+			http.Error(w, "invalid vote direction", http.StatusBadRequest)
+			return
 		}
 
-		//update the comment in db
-		if err := h.store.UpdateComment(&c); err != nil {
+		// voting twice in the same direction retracts the vote, reading and writing in one transaction
+		// so two quick clicks cannot both see the old vote
+		user, _ := UserFromContext(r.Context())
+		err = h.store.WithTx(r.Context(), func(s store.Store) error {
+			votes, err := s.CommentVotes(r.Context(), user.ID, []uuid.UUID{c.ID})
+			if err != nil {
+				return err
+			}
+			if votes[c.ID] == value {
+				return s.RetractCommentVote(r.Context(), user.ID, c.ID)
+			}
+			return s.VoteComment(r.Context(), user.ID, c.ID, value)
+		})
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -98,3 +204,114 @@ func (h *CommentHandler) vote() http.HandlerFunc {
 		http.Redirect(w, r, r.Referer(), http.StatusFound)
 	}
 }
+
+func (h *CommentHandler) editView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Post    store.Post
+		Comment store.Comment
+		CSRF    template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/comment_edit.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, c, ok := h.comment(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Edit, policy.Comment(c)) {
+			return
+		}
+		sd := GetSessionData(h.sessions, r.Context())
+		// the form starts with the current text, unless it is sent back after a validation error
+		if _, ok := sd.Form.(CreateCommentForm); !ok {
+			sd.Form = CreateCommentForm{Content: c.Content}
+		}
+		tmpl.Execute(w, data{
+			SessionData: sd,
+			Post:        p,
+			Comment:     c,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *CommentHandler) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, c, ok := h.comment(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Edit, policy.Comment(c)) {
+			return
+		}
+
+		form := CreateCommentForm{Content: r.FormValue("content")}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		c.Content = form.Content
+		if err := h.store.UpdateComment(r.Context(), &c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookCommentUpdated, c.ID)
+
+		h.sessions.Put(r.Context(), "flash", "Your comment has been saved.")
+		http.Redirect(w, r, "/threads/"+p.ThreadID.String()+"/"+p.ID.String()+"#comment-"+c.ID.String(), http.StatusFound)
+	}
+}
+
+// comment retrieves the comment in the url together with its post, what is in the trash is not found
+func (h *CommentHandler) comment(w http.ResponseWriter, r *http.Request) (store.Post, store.Comment, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return store.Post{}, store.Comment{}, false
+	}
+	c, err := h.store.Comment(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && c.DeletedAt.Valid {
+		http.NotFound(w, r)
+		return store.Post{}, store.Comment{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return store.Post{}, store.Comment{}, false
+	}
+	p, err := h.store.Post(r.Context(), c.PostID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return store.Post{}, store.Comment{}, false
+	}
+	if p.DeletedAt.Valid {
+		http.NotFound(w, r)
+		return store.Post{}, store.Comment{}, false
+	}
+	return p, c, true
+}
+
+// commentVotes returns the votes of the logged in user on the given comments, anonymous visitors get an empty map
+func commentVotes(s store.Store, ctx context.Context, cc []store.Comment) (map[uuid.UUID]int, error) {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return map[uuid.UUID]int{}, nil
+	}
+	ids := make([]uuid.UUID, len(cc))
+	for i, c := range cc {
+		ids[i] = c.ID
+	}
+	return s.CommentVotes(ctx, user.ID, ids)
+}
+
+// flattenComments lists the comments of the trees together with all their replies
+func flattenComments(tree []store.Comment) []store.Comment {
+	var cc []store.Comment
+	for _, c := range tree {
+		cc = append(cc, c)
+		cc = append(cc, flattenComments(c.Replies)...)
+	}
+	return cc
+}
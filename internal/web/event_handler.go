@@ -0,0 +1,159 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// eventRetry is how long the browsers wait before reconnecting to a stream, in milliseconds
+const eventRetry = 1000
+
+// eventHeartbeat keeps the idle streams open through the proxies, a variable so the tests do not wait for it
+var eventHeartbeat = 20 * time.Second
+
+// EventHandler streams the changes of a thread or of a post to the browsers with server-sent events.
+// The streams have no deadline, they last as long as the page is open. Every event has an id, a browser reconnecting
+// gets the events it missed, or a reset event telling it to load the page again when they are not known anymore.
+type EventHandler struct {
+	store store.Store
+}
+
+// eventMessage is the data of an event, the post and comment events of a post stream carry the changed item
+type eventMessage struct {
+	store.Event
+	Post    *apiPost    `json:"post,omitempty"`
+	Comment *apiComment `json:"comment,omitempty"`
+}
+
+// thread streams the new comments and the score changes of the posts of the thread
+func (h *EventHandler) thread() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := h.store.Thread(r.Context(), id)
+		if err != nil || t.DeletedAt.Valid {
+			feedError(w, r, err)
+			return
+		}
+		h.stream(w, r, func(e store.Event) (eventMessage, bool) {
+			// the thread only shows the score and the comments count of the posts
+			keep := e.ThreadID == t.ID && (e.Type == store.EventCommentCreated || e.Type == store.EventScoreChanged && !e.CommentID.Valid)
+			return eventMessage{Event: e}, keep
+		})
+	}
+}
+
+// post streams every change of the post and of its comments
+func (h *EventHandler) post() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, err := uuid.Parse(chi.URLParam(r, "postId"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, err := h.store.Post(r.Context(), postID)
+		if err != nil || p.DeletedAt.Valid || p.ThreadID.String() != chi.URLParam(r, "threadId") {
+			feedError(w, r, err)
+			return
+		}
+		h.stream(w, r, func(e store.Event) (eventMessage, bool) {
+			if e.PostID != p.ID {
+				return eventMessage{}, false
+			}
+			return h.message(r.Context(), e)
+		})
+	}
+}
+
+// message adds the changed post or comment to the event, as the page would show it
+func (h *EventHandler) message(ctx context.Context, e store.Event) (eventMessage, bool) {
+	m := eventMessage{Event: e}
+	switch e.Type {
+	case store.EventPostUpdated:
+		p, err := h.store.Post(ctx, e.PostID)
+		if err != nil || p.DeletedAt.Valid {
+			return m, false
+		}
+		ap := newAPIPost(p)
+		m.Post = &ap
+	case store.EventCommentCreated, store.EventCommentUpdated:
+		c, err := h.store.Comment(ctx, e.CommentID.UUID)
+		if err != nil {
+			return m, false
+		}
+		// a deleted comment stays as a placeholder
+		if c.DeletedAt.Valid {
+			c.AuthorID, c.AuthorName, c.Content = uuid.NullUUID{}, store.DeletedAuthor, store.DeletedContent
+		}
+		ac := newAPIComment(c)
+		m.Comment = &ac
+	}
+	return m, true
+}
+
+// stream writes the events the filter keeps until the client goes away
+func (h *EventHandler) stream(w http.ResponseWriter, r *http.Request, filter func(store.Event) (eventMessage, bool)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// the write timeout of the server would cut the stream
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the browsers send the id of the last event they got when they reconnect
+	lastID := r.Header.Get("Last-Event-ID")
+	events, complete := h.store.Subscribe(r.Context(), lastID)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+	if !complete {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			lastID = e.ID
+			m, ok := filter(e)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(m)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		case <-heartbeat.C:
+			// the heartbeat moves the id of the browser past the events the filter left out, so a reconnection
+			// does not ask for more of them than the hub keeps
+			fmt.Fprint(w, ": heartbeat\n")
+			if lastID != "" {
+				fmt.Fprintf(w, "id: %s\n", lastID)
+			}
+			fmt.Fprint(w, "\n")
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,166 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/memory"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// inRepoRoot runs the test from the root of the repository, where the handlers parse the templates from
+func inRepoRoot(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// eventStream reads a stream of server-sent events line by line, the channel closes when the stream ends
+type eventStream struct {
+	t     *testing.T
+	lines chan string
+}
+
+func openStream(t *testing.T, url, lastID string) *eventStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Accept of curl rather than the one of the browsers, the route alone makes the request a stream
+	req.Header.Set("Accept", "*/*")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s answered %s", url, res.Status)
+	}
+	s := &eventStream{t: t, lines: make(chan string, 100)}
+	go func() {
+		defer res.Body.Close()
+		defer close(s.lines)
+		sc := bufio.NewScanner(res.Body)
+		for sc.Scan() {
+			s.lines <- sc.Text()
+		}
+	}()
+	return s
+}
+
+// next waits for a line starting with the prefix and returns it, the lines before are skipped
+func (s *eventStream) next(prefix string, timeout time.Duration) string {
+	s.t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case line, ok := <-s.lines:
+			if !ok {
+				s.t.Fatalf("the stream ended waiting for %q", prefix)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-deadline:
+			s.t.Fatalf("no %q in %s", prefix, timeout)
+		}
+	}
+}
+
+func newEventSite(t *testing.T) (*httptest.Server, store.Store, store.Post) {
+	inRepoRoot(t)
+	heartbeat := eventHeartbeat
+	eventHeartbeat = 100 * time.Millisecond
+	t.Cleanup(func() { eventHeartbeat = heartbeat })
+
+	s := memory.NewStore()
+	ctx := context.Background()
+	th := store.Thread{ID: uuid.New(), Title: "Thread", Description: "About"}
+	if err := s.CreateThread(ctx, &th); err != nil {
+		t.Fatal(err)
+	}
+	p := store.Post{ID: uuid.New(), ThreadID: th.ID, Title: "Post", Content: "Hello"}
+	if err := s.CreatePost(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+
+	// the request and the server timeouts are short, the streams have to outlive them
+	site := httptest.NewUnstartedServer(NewHandler(s, NewMemorySessionManager(), make([]byte, 32), WithRequestTimeout(200*time.Millisecond)))
+	site.Config.WriteTimeout = 300 * time.Millisecond
+	site.Start()
+	t.Cleanup(site.Close)
+	return site, s, p
+}
+
+func addComment(t *testing.T, s store.Store, p store.Post) store.Comment {
+	t.Helper()
+	c := store.Comment{ID: uuid.New(), PostID: p.ID, ThreadID: p.ThreadID, Content: "Hi"}
+	if err := s.CreateComment(context.Background(), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEventStreamOutlivesTimeouts(t *testing.T) {
+	site, s, p := newEventSite(t)
+	stream := openStream(t, site.URL+"/threads/"+p.ThreadID.String()+"/"+p.ID.String()+"/events", "")
+	stream.next("retry:", time.Second)
+
+	// past the request and the write timeouts the stream is still there, beating
+	time.Sleep(500 * time.Millisecond)
+	stream.next(": heartbeat", time.Second)
+
+	c := addComment(t, s, p)
+	id := stream.next("id: ", time.Second)
+	if line := stream.next("event: ", time.Second); line != "event: comment_created" {
+		t.Errorf("got %q, want the comment_created event", line)
+	}
+	if line := stream.next("data: ", time.Second); !strings.Contains(line, c.ID.String()) {
+		t.Errorf("the event %q is not about the comment %s", line, c.ID)
+	}
+	if id == "id: " {
+		t.Error("the event has no id")
+	}
+}
+
+func TestEventStreamReplaysMissedEvents(t *testing.T) {
+	site, s, p := newEventSite(t)
+	url := site.URL + "/threads/" + p.ThreadID.String() + "/" + p.ID.String() + "/events"
+
+	first := openStream(t, url, "")
+	first.next("retry:", time.Second)
+	addComment(t, s, p)
+	lastID := strings.TrimPrefix(first.next("id: ", time.Second), "id: ")
+
+	// the comment comes while the browser reconnects
+	missed := addComment(t, s, p)
+	again := openStream(t, url, lastID)
+	again.next("retry:", time.Second)
+	if line := again.next("data: ", time.Second); !strings.Contains(line, missed.ID.String()) {
+		t.Errorf("got %q, want the missed comment %s", line, missed.ID)
+	}
+
+	// the events after an id of another process or of a restart are unknown, the page has to load again
+	unknown := openStream(t, url, "elsewhere-1")
+	if line := unknown.next("event: ", time.Second); line != "event: reset" {
+		t.Errorf("got %q, want a reset", line)
+	}
+}
@@ -0,0 +1,359 @@
+package web
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// feedSize is how many entries a feed holds, readers only look for what is new
+const feedSize = 50
+
+type feedFormat string
+
+const (
+	formatAtom feedFormat = "atom"
+	formatRSS  feedFormat = "rss"
+)
+
+// feed is what the atom and rss documents are built from, the links are paths made absolute when writing
+type feed struct {
+	ID       string
+	Title    string
+	Subtitle string
+	// Link is the html page of the feed and Self the feed itself, without extension
+	Link    string
+	Self    string
+	Updated time.Time
+	Entries []feedEntry
+}
+
+type feedEntry struct {
+	ID        string
+	Title     string
+	Link      string
+	Author    string
+	Content   string
+	Published time.Time
+	Updated   time.Time
+}
+
+// FeedHandler serves the atom and rss feeds of the front page, of the threads and of the comments of the posts
+type FeedHandler struct {
+	store store.Store
+	// baseURL starts the links of the feeds, the host of the request is used when it is empty
+	baseURL string
+}
+
+func (h *FeedHandler) front(format feedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pp, err := h.store.Posts(r.Context(), store.PostSort{Order: store.OrderNew})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeFeed(w, r, h.baseURL, format, feed{
+			ID:       siteURL(h.baseURL, r, "/"),
+			Title:    "goreddit",
+			Subtitle: "The newest posts of every thread",
+			Link:     "/",
+			Self:     "/feed",
+			Entries:  postEntries(pp),
+		})
+	}
+}
+
+func (h *FeedHandler) thread(format feedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := h.store.Thread(r.Context(), id)
+		if err != nil || t.DeletedAt.Valid {
+			feedError(w, r, err)
+			return
+		}
+		pp, err := h.store.PostsByThread(r.Context(), t.ID, store.PostSort{Order: store.OrderNew})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeFeed(w, r, h.baseURL, format, feed{
+			ID:       "urn:uuid:" + t.ID.String(),
+			Title:    t.Title,
+			Subtitle: t.Description,
+			Link:     "/threads/" + t.ID.String(),
+			Self:     "/threads/" + t.ID.String() + "/feed",
+			Updated:  t.UpdatedAt,
+			Entries:  postEntries(pp),
+		})
+	}
+}
+
+func (h *FeedHandler) post(format feedFormat) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		threadID, err := uuid.Parse(chi.URLParam(r, "threadId"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		postID, err := uuid.Parse(chi.URLParam(r, "postId"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := h.store.Thread(r.Context(), threadID)
+		if err != nil || t.DeletedAt.Valid {
+			feedError(w, r, err)
+			return
+		}
+		p, err := h.store.Post(r.Context(), postID)
+		if err != nil || p.DeletedAt.Valid || p.ThreadID != t.ID {
+			feedError(w, r, err)
+			return
+		}
+		cc, err := h.store.CommentsByPost(r.Context(), p.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// the newest comments first, whatever their score
+		sort.Slice(cc, func(i, j int) bool { return cc[i].CreatedAt.After(cc[j].CreatedAt) })
+		if len(cc) > feedSize {
+			cc = cc[:feedSize]
+		}
+		postURL := "/threads/" + t.ID.String() + "/" + p.ID.String()
+		entries := make([]feedEntry, len(cc))
+		for i, c := range cc {
+			entries[i] = feedEntry{
+				ID:        "urn:uuid:" + c.ID.String(),
+				Title:     c.AuthorName + " on " + p.Title,
+				Link:      postURL + "/comments/" + c.ID.String(),
+				Author:    c.AuthorName,
+				Content:   c.Content,
+				Published: c.CreatedAt,
+				Updated:   c.UpdatedAt,
+			}
+		}
+
+		writeFeed(w, r, h.baseURL, format, feed{
+			ID:       "urn:uuid:" + p.ID.String(),
+			Title:    "Comments on " + p.Title,
+			Subtitle: "in " + t.Title,
+			Link:     postURL,
+			Self:     postURL + "/feed",
+			Updated:  p.UpdatedAt,
+			Entries:  entries,
+		})
+	}
+}
+
+// postEntries turns the newest posts into entries
+func postEntries(pp []store.Post) []feedEntry {
+	if len(pp) > feedSize {
+		pp = pp[:feedSize]
+	}
+	entries := make([]feedEntry, len(pp))
+	for i, p := range pp {
+		entries[i] = feedEntry{
+			ID:        "urn:uuid:" + p.ID.String(),
+			Title:     p.Title,
+			Link:      "/threads/" + p.ThreadID.String() + "/" + p.ID.String(),
+			Author:    p.AuthorName,
+			Content:   p.Content,
+			Published: p.CreatedAt,
+			Updated:   p.UpdatedAt,
+		}
+	}
+	return entries
+}
+
+// feedError answers 404 for missing or trashed content and 500 otherwise
+func feedError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// absoluteURL makes the path absolute with the host of the request. The scheme is the one of the connection, or the
+// one the trusted proxy tells with forwardedProto.
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil || r.URL.Scheme == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// writeFeed writes the feed in the format, the feed is as recent as its most recent entry.
+// The links are made absolute with the base url, feed readers cannot resolve relative links.
+func writeFeed(w http.ResponseWriter, r *http.Request, baseURL string, format feedFormat, f feed) {
+	f.Link, f.Self = siteURL(baseURL, r, f.Link), siteURL(baseURL, r, f.Self)
+	entries := make([]feedEntry, len(f.Entries))
+	for i, e := range f.Entries {
+		e.Link = siteURL(baseURL, r, e.Link)
+		if e.Updated.After(f.Updated) {
+			f.Updated = e.Updated
+		}
+		entries[i] = e
+	}
+	f.Entries = entries
+
+	var doc interface{}
+	switch format {
+	case formatAtom:
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		doc = newAtomFeed(f)
+	case formatRSS:
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		doc = newRSSFeed(f)
+	}
+
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		// the response has started already, the reader gets a broken document
+		log.Printf("error writing feed %s: %v", f.Self, err)
+	}
+}
+
+// atom, see RFC 4287
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomPerson `xml:"author"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+func newAtomFeed(f feed) atomFeed {
+	a := atomFeed{
+		ID:       f.ID,
+		Title:    f.Title,
+		Subtitle: f.Subtitle,
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "alternate", Type: "text/html", Href: f.Link},
+			{Rel: "self", Type: "application/atom+xml", Href: f.Self + ".atom"},
+		},
+	}
+	for _, e := range f.Entries {
+		a.Entries = append(a.Entries, atomEntry{
+			ID:        e.ID,
+			Title:     e.Title,
+			Links:     []atomLink{{Rel: "alternate", Type: "text/html", Href: e.Link}},
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Updated:   e.Updated.UTC().Format(time.RFC3339),
+			Author:    atomPerson{Name: e.Author},
+			Content:   atomText{Type: "text", Body: e.Content},
+		})
+	}
+	return a
+}
+
+// rss, see https://www.rssboard.org/rss-specification
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	DCNS    string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string `xml:"title"`
+	Link          string `xml:"link"`
+	Description   string `xml:"description"`
+	LastBuildDate string `xml:"lastBuildDate"`
+	// the atom link tells where the feed lives, rss has no element for it
+	Self  atomLink  `xml:"atom:link"`
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	Creator     string  `xml:"dc:creator"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+func newRSSFeed(f feed) rssFeed {
+	description := f.Subtitle
+	if description == "" {
+		description = f.Title
+	}
+	doc := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		DCNS:    "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   description,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Self:          atomLink{Rel: "self", Type: "application/rss+xml", Href: f.Self + ".rss"},
+		},
+	}
+	for _, e := range f.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			Description: e.Content,
+			Creator:     e.Author,
+			GUID:        rssGUID{IsPermaLink: false, Value: e.ID},
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return doc
+}
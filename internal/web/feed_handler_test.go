@@ -0,0 +1,42 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/memory"
+)
+
+func TestFeedLinks(t *testing.T) {
+	inRepoRoot(t)
+
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"base url", []Option{WithBaseURL("https://goreddit.example/")}, "https://goreddit.example/feed.atom"},
+		{"base url behind a proxy", []Option{WithBaseURL("https://goreddit.example"), WithProxyHeaders(true)}, "https://goreddit.example/feed.atom"},
+		{"host of the request", nil, "http://evil.example/feed.atom"},
+		{"host of the request behind a proxy", []Option{WithProxyHeaders(true)}, "https://evil.example/feed.atom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(memory.NewStore(), NewMemorySessionManager(), make([]byte, 32), tt.opts...)
+			req := httptest.NewRequest(http.MethodGet, "/feed.atom", nil)
+			req.Host = "evil.example"
+			req.Header.Set("X-Forwarded-Proto", "https")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("the feed answered %d", w.Code)
+			}
+			if self := `rel="self" type="application/atom+xml" href="` + tt.want + `"`; !strings.Contains(w.Body.String(), self) {
+				t.Errorf("the feed does not link itself as %s:\n%s", tt.want, w.Body)
+			}
+		})
+	}
+}
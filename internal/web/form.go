@@ -1,6 +1,12 @@
 package web
 
-import "encoding/gob"
+import (
+	"encoding/gob"
+	"net/mail"
+	"net/url"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
 
 func init() {
 	// encode the form and errors so they can be sent to the client via the session
@@ -9,15 +15,27 @@ func init() {
 	gob.Register(CreateCommentForm{})
 	gob.Register(RegisterForm{})
 	gob.Register(LoginForm{})
+	gob.Register(TokenForm{})
+	gob.Register(WebhookForm{})
+	gob.Register(ImportForm{})
+	gob.Register(ModeratorForm{})
+	gob.Register(ForgotPasswordForm{})
+	gob.Register(ResetPasswordForm{})
+	gob.Register(EmailForm{})
+	gob.Register(TwoFactorForm{})
+	gob.Register(DisableTwoFactorForm{})
+	gob.Register(SignupForm{})
 	gob.Register(FormErrors{})
 }
 
 type FormErrors map[string]string
 
+// the create forms are decoded from the JSON bodies of the api as well
+
 type CreatePostForm struct {
-	Title   string
-	Content string
-	Errors  FormErrors
+	Title   string     `json:"title"`
+	Content string     `json:"content"`
+	Errors  FormErrors `json:"-"`
 }
 
 func (f *CreatePostForm) Validate() bool {
@@ -34,9 +52,9 @@ func (f *CreatePostForm) Validate() bool {
 }
 
 type CreateThreadForm struct {
-	Title       string
-	Description string
-	Errors      FormErrors
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Errors      FormErrors `json:"-"`
 }
 
 func (f *CreateThreadForm) Validate() bool {
@@ -53,8 +71,10 @@ func (f *CreateThreadForm) Validate() bool {
 }
 
 type CreateCommentForm struct {
-	Content string
-	Errors  FormErrors
+	Content string `json:"content"`
+	// id of the comment answered, empty for top level comments
+	ParentID string     `json:"parent_id,omitempty"`
+	Errors   FormErrors `json:"-"`
 }
 
 func (f *CreateCommentForm) Validate() bool {
@@ -70,7 +90,9 @@ func (f *CreateCommentForm) Validate() bool {
 type RegisterForm struct {
 	Username      string
 	Password      string
+	Email         string
 	UsernameTaken bool
+	EmailTaken    bool
 
 	Errors FormErrors
 }
@@ -90,9 +112,28 @@ func (f *RegisterForm) Validate() bool {
 		f.Errors["Password"] = "Your password must be at least 8 characters long."
 	}
 
+	validateEmail(f.Errors, f.Email, f.EmailTaken)
+
 	return len(f.Errors) == 0
 }
 
+// validateEmail checks the address of the register and the email forms
+func validateEmail(errors FormErrors, email string, taken bool) {
+	if email == "" {
+		errors["Email"] = "Please enter your email address."
+	} else if !validEmail(email) {
+		errors["Email"] = "Please enter a valid email address."
+	} else if taken {
+		errors["Email"] = "This email address is already in use."
+	}
+}
+
+// validEmail accepts a bare address like someone@example.com, not the "Name <address>" forms net/mail parses too
+func validEmail(email string) bool {
+	a, err := mail.ParseAddress(email)
+	return err == nil && a.Address == email
+}
+
 type LoginForm struct {
 	Username             string
 	Password             string
@@ -116,3 +157,225 @@ func (f *LoginForm) Validate() bool {
 
 	return len(f.Errors) == 0
 }
+
+type TokenForm struct {
+	Name  string
+	Scope string
+	// only admins can create tokens with the admin scope
+	CanAdmin bool
+
+	Errors FormErrors
+}
+
+func (f *TokenForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Name == "" {
+		f.Errors["Name"] = "Please name your token."
+	} else if len(f.Name) > 100 {
+		f.Errors["Name"] = "The name must be at most 100 characters long."
+	}
+
+	switch store.TokenScope(f.Scope) {
+	case store.ScopeRead, store.ScopeWrite:
+	case store.ScopeAdmin:
+		if !f.CanAdmin {
+			f.Errors["Scope"] = "Only admins can create admin tokens."
+		}
+	default:
+		f.Errors["Scope"] = "Please choose a scope."
+	}
+
+	return len(f.Errors) == 0
+}
+
+type WebhookForm struct {
+	URL string
+	// Secret can be left empty to keep the current one or, for a new webhook, to have one generated
+	Secret string
+	// Events is the filter of the webhook, none subscribes to every event
+	Events []string
+
+	Errors FormErrors
+}
+
+func (f *WebhookForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.URL == "" {
+		f.Errors["URL"] = "Please enter the url to post the events to."
+	} else if u, err := url.Parse(f.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		f.Errors["URL"] = "Please enter an http or https url."
+	}
+
+	if f.Secret != "" && len(f.Secret) < 16 {
+		f.Errors["Secret"] = "The secret must be at least 16 characters long."
+	}
+
+	for _, e := range f.Events {
+		if !validWebhookEvent(store.WebhookEvent(e)) {
+			f.Errors["Events"] = "Please choose among the listed events."
+		}
+	}
+
+	return len(f.Errors) == 0
+}
+
+func validWebhookEvent(e store.WebhookEvent) bool {
+	for _, valid := range store.WebhookEvents {
+		if e == valid {
+			return true
+		}
+	}
+	return false
+}
+
+type ImportForm struct {
+	Merge       bool
+	CreateUsers bool
+	// Invalid is why the archive could not be read, if it could not
+	Invalid string
+
+	Errors FormErrors
+}
+
+func (f *ImportForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Invalid != "" {
+		f.Errors["Archive"] = f.Invalid
+	}
+
+	return len(f.Errors) == 0
+}
+
+type ModeratorForm struct {
+	Username    string
+	UnknownUser bool
+
+	Errors FormErrors
+}
+
+func (f *ModeratorForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Username == "" {
+		f.Errors["Username"] = "Please enter the username of the moderator."
+	} else if f.UnknownUser {
+		f.Errors["Username"] = "There is no user with this username."
+	}
+
+	return len(f.Errors) == 0
+}
+
+type ForgotPasswordForm struct {
+	Username string
+
+	Errors FormErrors
+}
+
+func (f *ForgotPasswordForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Username == "" {
+		f.Errors["Username"] = "Please enter your username."
+	}
+
+	return len(f.Errors) == 0
+}
+
+// ResetPasswordForm goes back to the page without the passwords, so they never reach the session
+type ResetPasswordForm struct {
+	Password string
+	Confirm  string
+
+	Errors FormErrors
+}
+
+func (f *ResetPasswordForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Password == "" {
+		f.Errors["Password"] = "Please enter a new password."
+	} else if len(f.Password) < 8 {
+		f.Errors["Password"] = "Your password must be at least 8 characters long."
+	} else if f.Confirm != f.Password {
+		f.Errors["Confirm"] = "The passwords do not match."
+	}
+
+	return len(f.Errors) == 0
+}
+
+type EmailForm struct {
+	Email      string
+	EmailTaken bool
+
+	Errors FormErrors
+}
+
+func (f *EmailForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	validateEmail(f.Errors, f.Email, f.EmailTaken)
+
+	return len(f.Errors) == 0
+}
+
+type TwoFactorForm struct {
+	Code          string
+	IncorrectCode bool
+
+	Errors FormErrors
+}
+
+func (f *TwoFactorForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Code == "" {
+		f.Errors["Code"] = "Please enter a code."
+	} else if f.IncorrectCode {
+		f.Errors["Code"] = "This code is incorrect or has been used already."
+	}
+
+	return len(f.Errors) == 0
+}
+
+// DisableTwoFactorForm goes back to the page without the password, so it never reaches the session
+type DisableTwoFactorForm struct {
+	Password          string
+	IncorrectPassword bool
+
+	Errors FormErrors
+}
+
+func (f *DisableTwoFactorForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Password == "" {
+		f.Errors["Password"] = "Please enter your password."
+	} else if f.IncorrectPassword {
+		f.Errors["Password"] = "This password is incorrect."
+	}
+
+	return len(f.Errors) == 0
+}
+
+// SignupForm chooses the username of a user signing up with an identity provider
+type SignupForm struct {
+	Username      string
+	UsernameTaken bool
+
+	Errors FormErrors
+}
+
+func (f *SignupForm) Validate() bool {
+	f.Errors = FormErrors{}
+
+	if f.Username == "" {
+		f.Errors["Username"] = "Please enter a username."
+	} else if f.UsernameTaken {
+		f.Errors["Username"] = "This username is already taken."
+	}
+
+	return len(f.Errors) == 0
+}
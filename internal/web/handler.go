@@ -2,9 +2,16 @@ package web
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"html/template"
+	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
@@ -12,110 +19,482 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/mail"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc"
 )
 
-func NewHandler(s store.Store, ss *scs.SessionManager, csrfKey []byte) *Handler {
+// Option configures the optional behaviours of the Handler
+type Option func(*Handler)
+
+// WithCommentDepth sets how many levels of replies are shown below a comment before linking to the rest of the thread
+func WithCommentDepth(depth int) Option {
+	return func(h *Handler) {
+		h.commentDepth = depth
+	}
+}
+
+// WithRequestTimeout sets the deadline of every request, the store queries of a request are canceled when it expires
+func WithRequestTimeout(d time.Duration) Option {
+	return func(h *Handler) {
+		h.requestTimeout = d
+	}
+}
+
+// WithMailer sets how the mails of the site are sent, they are written to a directory in the temporary one by default
+func WithMailer(m mail.Mailer) Option {
+	return func(h *Handler) {
+		h.mailer = m
+	}
+}
+
+// WithBaseURL sets the scheme and host the links sent by mail and the links of the feeds start with, like
+// https://example.com. Without it the links take the host the request came to, which whoever sends the request chooses.
+func WithBaseURL(u string) Option {
+	return func(h *Handler) {
+		h.baseURL = strings.TrimSuffix(u, "/")
+	}
+}
+
+// WithVerifiedPosting sets whether the users have to verify their email address before creating threads, posts and comments
+func WithVerifiedPosting(required bool) Option {
+	return func(h *Handler) {
+		h.verifiedPosting = required
+	}
+}
+
+// WithOIDCProviders sets the OpenID Connect providers the users can log in with, next to their password
+func WithOIDCProviders(pp ...*oidc.Provider) Option {
+	return func(h *Handler) {
+		h.providers = pp
+	}
+}
+
+// WithProxyHeaders takes the address of the client from the X-Forwarded-For or X-Real-IP header the reverse proxy
+// in front of the site sets, and the scheme from X-Forwarded-Proto. Without a proxy the clients would choose the
+// address the lockouts count them under, and the scheme of the links built from the request.
+func WithProxyHeaders(trusted bool) Option {
+	return func(h *Handler) {
+		h.proxyHeaders = trusted
+	}
+}
+
+func NewHandler(s store.Store, ss *scs.SessionManager, csrfKey []byte, opts ...Option) *Handler {
 	h := &Handler{
-		Mux:      chi.NewRouter(),
-		store:    s,
-		sessions: ss,
+		Mux:            chi.NewRouter(),
+		store:          s,
+		sessions:       ss,
+		commentDepth:   5,
+		requestTimeout: 10 * time.Second,
+		mailer:         mail.NewDirMailer(filepath.Join(os.TempDir(), "goreddit-mail"), "goreddit <noreply@localhost>"),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
 
 	threadsHandler := ThreadHandler{store: s, sessions: ss}
-	postHandler := PostHandler{store: s, sessions: ss}
+	postHandler := PostHandler{store: s, sessions: ss, commentDepth: h.commentDepth}
 	commentHandler := CommentHandler{store: s, sessions: ss}
-	userHandler := UserHandler{store: s, sessions: ss}
+	userHandler := UserHandler{store: s, sessions: ss, mailer: h.mailer, baseURL: h.baseURL, providers: h.providers}
+	oidcHandler := OIDCHandler{store: s, sessions: ss, providers: h.providers, baseURL: h.baseURL}
+	searchHandler := SearchHandler{store: s, sessions: ss}
+	adminHandler := AdminHandler{store: s, sessions: ss}
+	tokenHandler := TokenHandler{store: s, sessions: ss}
+	twoFactorHandler := TwoFactorHandler{store: s, sessions: ss}
+	webhookHandler := WebhookHandler{store: s, sessions: ss}
+	feedHandler := FeedHandler{store: s, baseURL: h.baseURL}
+	eventHandler := EventHandler{store: s}
+	apiHandler := APIHandler{store: s, sessions: ss, commentDepth: h.commentDepth, verifiedPosting: h.verifiedPosting}
+
+	// take the address and the scheme of the client from the proxy, before the logger, the lockouts and the links use them
+	if h.proxyHeaders {
+		h.Use(middleware.RealIP)
+		h.Use(forwardedProto)
+	}
 
 	// add logger middleware
 	h.Use(middleware.Logger)
 
+	// add a deadline to the context of every request but the event streams, it answers 504 when the handler runs out of time
+	h.Use(h.withTimeout)
+
+	// add the middleware authenticating the requests with a personal access token, before the csrf check they skip
+	h.Use(h.withToken)
+
 	// add csrf protection middleware
-	h.Use(csrf.Protect(csrfKey, csrf.Secure(false))) // set security to false for development otherwise the cookie will only be sent over https
+	h.Use(csrf.Protect(csrfKey, csrf.Secure(false), csrf.CookieName(csrfCookie), csrf.ErrorHandler(http.HandlerFunc(csrfFailure)))) // set security to false for development otherwise the cookie will only be sent over https
 
 	// add session middleware
-	h.Use(ss.LoadAndSave)
+	h.Use(h.loadSession)
 
 	// add custom middleware to retrieve the user from the session and add it to the request context
 	h.Use(h.withUser)
 
 	// homepage
 	h.Get("/", h.homeView())
+	h.Get("/feed.atom", feedHandler.front(formatAtom))
+	h.Get("/feed.rss", feedHandler.front(formatRSS))
 
 	// sub paths
 	h.Route("/threads", func(r chi.Router) {
 		r.Get("/", threadsHandler.listView())
-		r.Get("/new", threadsHandler.createView())
+		r.With(h.requireUser, h.requireVerified).Get("/new", threadsHandler.createView())
 		r.Get("/{id}", threadsHandler.view())
-		r.Post("/", threadsHandler.save())
-		r.Post("/{id}/delete", threadsHandler.delete())
+		r.Get("/{id}/feed.atom", feedHandler.thread(formatAtom))
+		r.Get("/{id}/feed.rss", feedHandler.thread(formatRSS))
+		r.Get("/{id}/events", eventHandler.thread())
+		r.With(h.requireUser, h.requireVerified).Post("/", threadsHandler.save())
+		r.With(h.requireUser).Post("/{id}/delete", threadsHandler.delete())
+		r.With(h.requireUser).Get("/{id}/edit", threadsHandler.editView())
+		r.With(h.requireUser).Post("/{id}/edit", threadsHandler.update())
+		r.With(h.requireUser).Post("/{id}/lock", threadsHandler.lock(true))
+		r.With(h.requireUser).Post("/{id}/unlock", threadsHandler.lock(false))
+		r.With(h.requireUser).Post("/{id}/moderators", threadsHandler.addModerator())
+		r.With(h.requireUser).Post("/{id}/moderators/{userId}/remove", threadsHandler.removeModerator())
+		r.Get("/{id}/modlog", threadsHandler.modLogView())
 
 		// post routes
-		r.Get("/{id}/new", postHandler.createView())
+		r.With(h.requireUser, h.requireVerified).Get("/{id}/new", postHandler.createView())
 		r.Get("/{threadId}/{postId}", postHandler.view())
-		r.Get("/{threadId}/{postId}/vote", postHandler.vote())
-		r.Post("/{id}", postHandler.save())
+		r.Get("/{threadId}/{postId}/comments/{commentId}", postHandler.view())
+		r.Get("/{threadId}/{postId}/feed.atom", feedHandler.post(formatAtom))
+		r.Get("/{threadId}/{postId}/feed.rss", feedHandler.post(formatRSS))
+		r.Get("/{threadId}/{postId}/events", eventHandler.post())
+		r.With(h.requireUser, requireScope(store.ScopeWrite)).Post("/{threadId}/{postId}/vote", postHandler.vote())
+		r.With(h.requireUser).Post("/{threadId}/{postId}/delete", postHandler.delete())
+		r.With(h.requireUser).Get("/{threadId}/{postId}/edit", postHandler.editView())
+		r.With(h.requireUser).Post("/{threadId}/{postId}/edit", postHandler.update())
+		r.With(h.requireUser).Post("/{threadId}/{postId}/pin", postHandler.pin(true))
+		r.With(h.requireUser).Post("/{threadId}/{postId}/unpin", postHandler.pin(false))
+		r.With(h.requireUser, h.requireVerified).Post("/{id}", postHandler.save())
 
 		// comment routes
-		r.Post("/{threadId}/{postId}", commentHandler.save())
+		r.With(h.requireUser, h.requireVerified).Post("/{threadId}/{postId}", commentHandler.save())
 	})
 
 	// comments vote
-	h.Get("/comments/{id}/vote", commentHandler.vote())
+	h.With(h.requireUser, requireScope(store.ScopeWrite)).Post("/comments/{id}/vote", commentHandler.vote())
+	h.With(h.requireUser).Post("/comments/{id}/delete", commentHandler.delete())
+	h.With(h.requireUser).Get("/comments/{id}/edit", commentHandler.editView())
+	h.With(h.requireUser).Post("/comments/{id}/edit", commentHandler.update())
+
+	// search
+	h.Get("/search", searchHandler.view())
+
+	// admin routes
+	h.Route("/admin", func(r chi.Router) {
+		r.Use(h.requireAdmin)
+		r.Get("/trash", adminHandler.trashView())
+		r.Post("/trash/{type}/{id}/restore", adminHandler.restore())
+		r.Get("/threads/{id}/export", adminHandler.exportThread())
+		r.Get("/import", adminHandler.importView())
+		r.Post("/import", adminHandler.importArchive())
+		r.Get("/webhooks", webhookHandler.listView())
+		r.Post("/webhooks", webhookHandler.create())
+		r.Get("/webhooks/{id}", webhookHandler.view())
+		r.Post("/webhooks/{id}", webhookHandler.update())
+		r.Post("/webhooks/{id}/delete", webhookHandler.delete())
+		r.Get("/lockouts", adminHandler.lockoutsView())
+		r.Post("/lockouts/unlock", adminHandler.unlock())
+	})
+
+	// settings routes, a token could mint wider tokens so only the admin scope reaches them
+	h.Route("/settings", func(r chi.Router) {
+		r.Use(h.requireUser, requireScope(store.ScopeAdmin))
+		r.Get("/tokens", tokenHandler.listView())
+		r.Post("/tokens", tokenHandler.create())
+		r.Post("/tokens/{id}/revoke", tokenHandler.revoke())
+		r.Get("/2fa", twoFactorHandler.view())
+		r.Post("/2fa", twoFactorHandler.enable())
+		r.Post("/2fa/disable", twoFactorHandler.disable())
+		r.Get("/connections", oidcHandler.connectionsView())
+		r.Post("/connections/{provider}", oidcHandler.connect())
+		r.Post("/connections/{id}/disconnect", oidcHandler.disconnect())
+	})
+
+	// json api
+	h.Mount("/api/v1", apiHandler.Routes())
+	h.Get("/api/openapi.json", apiHandler.specView())
 
 	// user routes
 	h.Get("/register", userHandler.RegisterView())
 	h.Post("/register", userHandler.Register())
 	h.Get("/login", userHandler.LoginView())
 	h.Post("/login", userHandler.Login())
+	h.Get("/login/2fa", userHandler.LoginTwoFactorView())
+	h.Post("/login/2fa", userHandler.LoginTwoFactor())
 	h.Get("/logout", userHandler.Logout())
+	h.Get("/password/forgot", userHandler.ForgotPasswordView())
+	h.Post("/password/forgot", userHandler.ForgotPassword())
+	h.Get("/password/reset/{token}", userHandler.ResetPasswordView())
+	h.Post("/password/reset/{token}", userHandler.ResetPassword())
+	h.With(h.requireUser).Get("/email", userHandler.EmailView())
+	h.With(h.requireUser).Post("/email", userHandler.SendVerification())
+	h.Get("/email/verify/{token}", userHandler.VerifyEmail())
+
+	// identity provider routes
+	h.Get("/auth/{provider}/login", oidcHandler.login())
+	h.Get("/auth/{provider}/callback", oidcHandler.callback())
+	h.Get("/auth/signup", oidcHandler.signupView())
+	h.Post("/auth/signup", oidcHandler.signup())
 	return h
 }
 
 type Handler struct {
-	*chi.Mux //embedded structure
-	store    store.Store
-	sessions *scs.SessionManager
+	*chi.Mux       //embedded structure
+	store          store.Store
+	sessions       *scs.SessionManager
+	commentDepth   int
+	requestTimeout time.Duration
+	mailer         mail.Mailer
+	baseURL        string
+	// verifiedPosting keeps the users who have not verified their address from posting
+	verifiedPosting bool
+	providers       []*oidc.Provider
+	proxyHeaders    bool
 }
 
 func (h *Handler) homeView() http.HandlerFunc {
 	type data struct {
 		SessionData
-		Posts []store.Post
+		SortData
+		PageData
+		Posts     []store.Post
+		PostVotes map[uuid.UUID]int
+		CSRF      template.HTML // string which is not escaped
 	}
 
 	var once sync.Once
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/home.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/home.html")
 	return func(w http.ResponseWriter, r *http.Request) {
-		// retrieve all posts
-		pp, err := h.store.Posts()
+		once.Do(func() {
+			h.sessions.Put(r.Context(), "flash", "Welcome!")
+		})
+
+		// answer 304 when nothing changed since the client got the page, the listings limited to a time window
+		// change as the posts leave it so they are rendered every time
+		sort, sortData := parsePostSort(r.URL.Query())
+		var modified time.Time
+		if sort.Since.IsZero() {
+			var err error
+			if modified, err = h.store.LastModified(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if notModified(w, r, h.sessions, modified, true) {
+			return
+		}
+
+		// retrieve a page of posts in the requested order
+		pp, cc, err := h.store.PostsPage(r.Context(), sort, parsePage(r))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			listingError(w, err)
 			return
 		}
 
-		once.Do(func() {
-			h.sessions.Put(r.Context(), "flash", "Welcome!")
-		})
+		// retrieve the votes of the current user to highlight them
+		votes, err := postVotes(h.store, r.Context(), pp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		tmpl.Execute(w, data{
 			SessionData: GetSessionData(h.sessions, r.Context()),
+			SortData:    sortData,
+			PageData:    newPageData(r, cc),
 			Posts:       pp,
+			PostVotes:   votes,
+			CSRF:        csrf.TemplateField(r),
 		})
 	}
 }
 
+// withTimeout adds the deadline of the requests. The event streams stay open as long as their page and get none.
+func (h *Handler) withTimeout(next http.Handler) http.Handler {
+	timeout := middleware.Timeout(h.requestTimeout)(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isEventStream(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout.ServeHTTP(w, r)
+	})
+}
+
+// forwardedProto sets the scheme of the request url to the one the proxy tells, the urls the site builds from the
+// request start with it. Only a trusted proxy may tell it, the links in the shared caches and the feeds depend on it.
+func forwardedProto(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch proto := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); proto {
+		case "http", "https":
+			r.URL.Scheme = proto
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isEventStream tells the requests of the event routes, /threads/{id}/events and /threads/{threadId}/{postId}/events
+func isEventStream(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/threads/") && strings.HasSuffix(r.URL.Path, "/events")
+}
+
+// loadSession loads the session of the request and saves it once the handler is done. The event streams only load it:
+// scs buffers the whole response to add the session cookie and a stream has to reach the browser as it goes.
+func (h *Handler) loadSession(next http.Handler) http.Handler {
+	loadAndSave := h.sessions.LoadAndSave(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isEventStream(r) {
+			loadAndSave.ServeHTTP(w, r)
+			return
+		}
+		var token string
+		if cookie, err := r.Cookie(h.sessions.Cookie.Name); err == nil {
+			token = cookie.Value
+		}
+		ctx, err := h.sessions.Load(r.Context(), token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 // create a middleware to retrieve the user from the session and add it to the request context
 func (h *Handler) withUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the user may come from a token already
+		if _, ok := UserFromContext(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		id, _ := h.sessions.Get(r.Context(), "user_id").(uuid.UUID)
 
-		user, err := h.store.User(id)
+		user, err := h.store.User(r.Context(), id)
 		if err != nil {
 			next.ServeHTTP(w, r)
 			return
 		}
+		// the sessions of the user were revoked since this one logged in
+		if user.SessionGeneration != h.sessions.GetInt(r.Context(), "session_generation") {
+			h.sessions.Remove(r.Context(), "user_id")
+			next.ServeHTTP(w, r)
+			return
+		}
 
 		ctx := context.WithValue(r.Context(), "user", user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// withToken authenticates the requests carrying a personal access token in the Authorization header, it adds the user
+// of the token to the request context like withUser does. Those requests carry no cookie a form of another site could
+// abuse so they skip the csrf check, which is why it has to run before csrf.Protect.
+func (h *Handler) withToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		scheme, token, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+			tokenUnauthorized(w, "the Authorization header must hold a Bearer token")
+			return
+		}
+		t, err := h.store.TokenByHash(r.Context(), hashToken(strings.TrimSpace(token)))
+		if errors.Is(err, sql.ErrNoRows) {
+			tokenUnauthorized(w, "invalid or revoked token")
+			return
+		}
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		user, err := h.store.User(r.Context(), t.UserID)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// the safe methods only read, the others need the write scope
+		want := store.ScopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			want = store.ScopeRead
+		}
+		if !t.Scope.Allows(want) {
+			apiError(w, http.StatusForbidden, "the token needs the "+string(want)+" scope")
+			return
+		}
+
+		// record the use at most once a minute to spare writes
+		if !t.LastUsedAt.Valid || time.Since(t.LastUsedAt.Time) > time.Minute {
+			if err := h.store.TouchToken(r.Context(), t.ID); err != nil {
+				log.Printf("error recording the use of token %s: %v", t.ID, err)
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), "user", user)
+		ctx = context.WithValue(ctx, "token", t)
+		next.ServeHTTP(w, csrf.UnsafeSkipCheck(r.WithContext(ctx)))
+	})
+}
+
+// tokenUnauthorized answers 401 to a request with a bad token, telling the client which scheme is expected
+func tokenUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="goreddit"`)
+	apiError(w, http.StatusUnauthorized, message)
+}
+
+// requireScope keeps out the requests made with a token lacking the scope, a session can do whatever its user can
+func requireScope(scope store.TokenScope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if t, ok := TokenFromContext(r.Context()); ok && !t.Scope.Allows(scope) {
+				http.Error(w, "the token needs the "+string(scope)+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// middleware to redirect anonymous visitors to the login page, it has to run after withUser
+func (h *Handler) requireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := UserFromContext(r.Context()); !ok {
+			h.sessions.Put(r.Context(), "flash", "Please log in to continue.")
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middleware to send the users who have not verified their address to the email page before they post,
+// when the deployment asks for it. It has to run after requireUser.
+func (h *Handler) requireVerified(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := UserFromContext(r.Context()); h.verifiedPosting && !user.EmailVerified {
+			h.sessions.Put(r.Context(), "flash", "Please verify your email address before posting.")
+			http.Redirect(w, r, "/email", http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middleware to keep everybody but admins out, tokens need the admin scope too, it has to run after withUser
+func (h *Handler) requireAdmin(next http.Handler) http.Handler {
+	return h.requireUser(requireScope(store.ScopeAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := UserFromContext(r.Context()); !user.Admin() {
+			forbidden(w, r, h.sessions, "Only the admins can see this page.")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})))
+}
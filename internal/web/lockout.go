@@ -0,0 +1,148 @@
+package web
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// limit is how many attempts a key gets before it is locked out. The first lockout lasts base and every attempt
+// after it doubles the next one, up to max.
+type limit struct {
+	// prefix tells the keys of the limits apart in the store
+	prefix    string
+	threshold int
+	base      time.Duration
+	max       time.Duration
+}
+
+var (
+	// loginUserLimit counts the failed logins of a username, from wherever they come
+	loginUserLimit = limit{prefix: "login-user:", threshold: 5, base: time.Minute, max: 24 * time.Hour}
+	// loginIPLimit counts the failed logins of an address, whatever the username
+	loginIPLimit = limit{prefix: "login-ip:", threshold: 20, base: time.Minute, max: 24 * time.Hour}
+	// registerIPLimit counts every registration of an address, the ones going through too
+	registerIPLimit = limit{prefix: "register-ip:", threshold: 10, base: 10 * time.Minute, max: 24 * time.Hour}
+	// resetUserLimit counts every request to reset the password of a username, each of them mails a link
+	resetUserLimit = limit{prefix: "reset-user:", threshold: 3, base: 15 * time.Minute, max: 24 * time.Hour}
+	// resetIPLimit counts every request to reset a password from an address, whatever the username
+	resetIPLimit = limit{prefix: "reset-ip:", threshold: 10, base: 15 * time.Minute, max: 24 * time.Hour}
+)
+
+// lockoutWindow is how long the attempts of a key are remembered after the last one
+const lockoutWindow = 24 * time.Hour
+
+// key is the key of the username or the address under the limit
+func (l limit) key(s string) string {
+	return l.prefix + strings.ToLower(s)
+}
+
+// lockout returns how long the key is locked out after the attempts, 0 while they are below the threshold
+func (l limit) lockout(attempts int) time.Duration {
+	if attempts < l.threshold {
+		return 0
+	}
+	d := l.base
+	for i := l.threshold; i < attempts && d < l.max; i++ {
+		d *= 2
+	}
+	if d > l.max {
+		d = l.max
+	}
+	return d
+}
+
+// lockedOut returns how long the longest locked out of the keys stays so, 0 when none is
+func lockedOut(ctx context.Context, s store.Store, keys ...string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		l, err := s.Lockout(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if l.Locked(now) && l.LockedUntil.Time.Sub(now) > wait {
+			wait = l.LockedUntil.Time.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// countAttempt counts an attempt of the username or the address under the limit, and locks it out once the attempts
+// are too many. It returns how long the lockout lasts, 0 when there is none.
+func countAttempt(r *http.Request, s store.Store, l limit, value string) (time.Duration, error) {
+	key := l.key(value)
+	c, err := s.CountAttempt(r.Context(), key, lockoutWindow)
+	if err != nil {
+		return 0, err
+	}
+	d := l.lockout(c.Attempts)
+	if d == 0 {
+		return 0, nil
+	}
+	if err := s.LockOut(r.Context(), key, time.Now().Add(d)); err != nil {
+		return 0, err
+	}
+	securityEvent("%s locked out for %s after %d attempts, the last one from %s", key, d, c.Attempts, clientIP(r))
+	return d, nil
+}
+
+// securityEvent logs what the operators want to know about the attacks on the site
+func securityEvent(format string, v ...interface{}) {
+	log.Printf("security: "+format, v...)
+}
+
+// clientIP is the address the request comes from. Behind a reverse proxy it is the one of the proxy, unless
+// WithProxyHeaders let the proxy tell the one of the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// lockoutMessage tells the visitors who are locked out what happened and how long they wait, rounded up to the second
+func lockoutMessage(what string, wait time.Duration) string {
+	wait = (wait + time.Second - 1).Truncate(time.Second)
+	return fmt.Sprintf("Too many %s. Please try again in %s.", what, wait)
+}
+
+// registrationWait counts a registration of the address unless it is locked out. It returns how long the address waits
+// before registering again, 0 when the registration goes on.
+func registrationWait(r *http.Request, s store.Store) (time.Duration, error) {
+	ip := clientIP(r)
+	wait, err := lockedOut(r.Context(), s, registerIPLimit.key(ip))
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	_, err = countAttempt(r, s, registerIPLimit, ip)
+	return 0, err
+}
+
+// passwordResetWait counts a request to reset the password of the username from the address unless either is locked
+// out. It returns how long they wait before asking again, 0 when the request goes on. The usernames without an
+// account count too, so the wait tells nobody who is registered.
+func passwordResetWait(r *http.Request, s store.Store, username string) (time.Duration, error) {
+	ip := clientIP(r)
+	wait, err := lockedOut(r.Context(), s, resetIPLimit.key(ip), resetUserLimit.key(username))
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	if _, err := countAttempt(r, s, resetUserLimit, username); err != nil {
+		return 0, err
+	}
+	_, err = countAttempt(r, s, resetIPLimit, ip)
+	return 0, err
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/memory"
+)
+
+func TestLimitLockout(t *testing.T) {
+	l := limit{prefix: "test:", threshold: 3, base: time.Minute, max: 10 * time.Minute}
+
+	tests := []struct {
+		name     string
+		l        limit
+		attempts int
+		want     time.Duration
+	}{
+		{"no attempt", l, 0, 0},
+		{"below the threshold", l, 2, 0},
+		{"at the threshold", l, 3, time.Minute},
+		{"one after the threshold", l, 4, 2 * time.Minute},
+		{"below max", l, 6, 8 * time.Minute},
+		{"doubled past max", l, 7, 10 * time.Minute},
+		{"far past max", l, 1000, 10 * time.Minute},
+		{"login of a username at the threshold", loginUserLimit, 5, time.Minute},
+		{"login of a username at max", loginUserLimit, 16, 24 * time.Hour},
+		{"registration at the threshold", registerIPLimit, 10, 10 * time.Minute},
+		{"registration at max", registerIPLimit, 18, 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.l.lockout(tt.attempts); got != tt.want {
+				t.Errorf("lockout(%d) = %s, want %s", tt.attempts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistrationWait(t *testing.T) {
+	s := memory.NewStore()
+	register := func(addr string) time.Duration {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/register", nil)
+		r.RemoteAddr = addr + ":1234"
+		wait, err := registrationWait(r, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return wait
+	}
+
+	// the registration reaching the threshold goes on, it locks out the next ones
+	for i := 1; i <= registerIPLimit.threshold; i++ {
+		if wait := register("192.0.2.1"); wait != 0 {
+			t.Fatalf("registration %d waits %s, want none", i, wait)
+		}
+	}
+	tests := []struct {
+		name     string
+		addr     string
+		min, max time.Duration
+	}{
+		{"address at the threshold", "192.0.2.1", registerIPLimit.base - time.Minute, registerIPLimit.base},
+		{"address locked out again", "192.0.2.1", registerIPLimit.base - time.Minute, registerIPLimit.base},
+		{"other address", "192.0.2.2", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a locked out address is not counted again, its lockout does not grow
+			if wait := register(tt.addr); wait < tt.min || wait > tt.max {
+				t.Errorf("waits %s, want between %s and %s", wait, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/policy"
+)
+
+// modTargetLength is how much of a comment the mod log keeps to tell which one it was
+const modTargetLength = 80
+
+// removals and restorations are the mod log actions of the trash, by type of content
+var (
+	removals = map[store.ContentType]store.ModActionType{
+		store.ContentThread:  store.ModRemoveThread,
+		store.ContentPost:    store.ModRemovePost,
+		store.ContentComment: store.ModRemoveComment,
+	}
+	restorations = map[store.ContentType]store.ModActionType{
+		store.ContentThread:  store.ModRestoreThread,
+		store.ContentPost:    store.ModRestorePost,
+		store.ContentComment: store.ModRestoreComment,
+	}
+)
+
+// modActionText is how the mod log shows the actions
+var modActionText = map[store.ModActionType]string{
+	store.ModRemoveThread:    "removed the thread",
+	store.ModRemovePost:      "removed the post",
+	store.ModRemoveComment:   "removed the comment",
+	store.ModRestoreThread:   "restored the thread",
+	store.ModRestorePost:     "restored the post",
+	store.ModRestoreComment:  "restored the comment",
+	store.ModPinPost:         "pinned the post",
+	store.ModUnpinPost:       "unpinned the post",
+	store.ModLockThread:      "locked the thread",
+	store.ModUnlockThread:    "unlocked the thread",
+	store.ModAddModerator:    "appointed the moderator",
+	store.ModRemoveModerator: "dismissed the moderator",
+}
+
+// describeModAction tells the readers of the mod log what the moderator did
+func describeModAction(a store.ModActionType) string {
+	if text, ok := modActionText[a]; ok {
+		return text
+	}
+	return string(a)
+}
+
+// modAction describes what the user does as a moderator to the thread, post or comment, for the mod log of its thread
+func modAction(user store.User, action store.ModActionType, content interface{}) store.ModAction {
+	a := store.ModAction{ID: uuid.New(), ModeratorID: uuid.NullUUID{UUID: user.ID, Valid: true}, Action: action}
+	switch c := content.(type) {
+	case store.Thread:
+		a.ThreadID, a.TargetID, a.Target = c.ID, c.ID, c.Title
+	case store.Post:
+		a.ThreadID, a.TargetID, a.Target = c.ThreadID, c.ID, c.Title
+	case store.Comment:
+		a.ThreadID, a.TargetID, a.Target = c.ThreadID, c.ID, excerpt(c.Content, modTargetLength)
+	}
+	return a
+}
+
+// moderate runs the change and records the action in the mod log of the thread in the same transaction,
+// so the log never misses an action nor holds one which did not happen
+func moderate(ctx context.Context, s store.Store, a store.ModAction, change func(store.Store) error) error {
+	return s.WithTx(ctx, func(tx store.Store) error {
+		if err := change(tx); err != nil {
+			return err
+		}
+		return tx.LogModAction(ctx, &a)
+	})
+}
+
+// remove moves the thread, post or comment to the trash with del. The user removes the content of somebody else
+// as a moderator, then the removal goes to the mod log and moderated is set.
+func remove(ctx context.Context, s store.Store, user store.User, content interface{}, del func(store.Store) error) (moderated bool, err error) {
+	res, err := resource(content)
+	if err != nil {
+		return false, err
+	}
+	if !policy.Moderates(user, policy.Delete, res) {
+		return false, del(s)
+	}
+	return true, moderate(ctx, s, modAction(user, removals[res.Type], content), del)
+}
+
+// contentByType gets the thread, post or comment of the type, the ones in the trash too
+func contentByType(ctx context.Context, s store.Store, t store.ContentType, id uuid.UUID) (interface{}, error) {
+	switch t {
+	case store.ContentThread:
+		return s.Thread(ctx, id)
+	case store.ContentPost:
+		return s.Post(ctx, id)
+	case store.ContentComment:
+		return s.Comment(ctx, id)
+	}
+	return nil, fmt.Errorf("unexpected content type %q", t)
+}
+
+// removedMessage is the flash message shown after a deletion, the authors delete their content while the moderators remove it
+func removedMessage(t store.ContentType, moderated bool) string {
+	if moderated {
+		return fmt.Sprintf("The %s has been removed.", t)
+	}
+	return fmt.Sprintf("Your %s has been deleted.", t)
+}
+
+// excerpt shortens the text to about n characters, cutting it between two words
+func excerpt(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) <= n {
+		return text
+	}
+	cut := strings.LastIndex(text[:n], " ")
+	if cut <= 0 {
+		cut = n
+	}
+	return strings.ToValidUTF8(text[:cut], "") + "…"
+}
@@ -0,0 +1,435 @@
+package web
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc"
+)
+
+func init() {
+	// the flows and the signups wait in the session for the browser to come back from the provider
+	gob.Register(oidcFlow{})
+	gob.Register(oidcSignup{})
+}
+
+// oidcTTL is how long the site waits for the browser to come back from the provider and for the username of a signup
+const oidcTTL = 10 * time.Minute
+
+// oidcFlow is a sign in started with a provider. UserID is set when a logged in user connects an account,
+// the flow logs in otherwise.
+type oidcFlow struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+	UserID   uuid.UUID
+	Started  int64
+}
+
+// oidcSignup is an account of a provider linked to nobody yet, waiting for its user to choose a username
+type oidcSignup struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Started       int64
+}
+
+// expired tells whether the time the site waits for something started at the unix time is over
+func expired(started int64) bool {
+	return time.Since(time.Unix(started, 0)) > oidcTTL
+}
+
+type OIDCHandler struct {
+	store     store.Store
+	sessions  *scs.SessionManager
+	providers []*oidc.Provider
+	// baseURL starts the urls the providers send the browsers back to, the host of the request is used when it is empty
+	baseURL string
+}
+
+// provider finds the provider named in the url, nil when there is none
+func (h *OIDCHandler) provider(r *http.Request) *oidc.Provider {
+	name := chi.URLParam(r, "provider")
+	for _, p := range h.providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (h *OIDCHandler) redirectURL(r *http.Request, p *oidc.Provider) string {
+	return siteURL(h.baseURL, r, "/auth/"+p.Name+"/callback")
+}
+
+// login sends the browser to the provider to sign in
+func (h *OIDCHandler) login() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := h.provider(r)
+		if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.start(w, r, p, uuid.Nil)
+	}
+}
+
+// connect sends the browser of the logged in user to the provider, to link the account signing in to the user
+func (h *OIDCHandler) connect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := h.provider(r)
+		if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+		user, _ := UserFromContext(r.Context())
+		h.start(w, r, p, user.ID)
+	}
+}
+
+// start keeps the secrets of a new flow in the session and redirects to the provider
+func (h *OIDCHandler) start(w http.ResponseWriter, r *http.Request, p *oidc.Provider, userID uuid.UUID) {
+	flow := oidcFlow{Provider: p.Name, UserID: userID, Started: time.Now().Unix()}
+	for _, s := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		var err error
+		if *s, err = oidc.RandomString(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	u, err := p.AuthURL(r.Context(), h.redirectURL(r, p), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		log.Printf("error starting the sign in with %s: %v", p.Name, err)
+		h.failed(w, r, flow, fmt.Sprintf("%s cannot be reached, please try again later.", p.DisplayName))
+		return
+	}
+	h.sessions.Put(r.Context(), "oidc_flow", flow)
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// failed tells the user the flow did not go through, back where it started
+func (h *OIDCHandler) failed(w http.ResponseWriter, r *http.Request, flow oidcFlow, message string) {
+	h.sessions.Put(r.Context(), "flash", message)
+	if flow.UserID != uuid.Nil {
+		http.Redirect(w, r, "/settings/connections", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// callback takes the browser coming back from the provider. The state has to be the one of the flow of this session,
+// so nobody can slip the code of another account in, and the nonce has to be in the ID token.
+func (h *OIDCHandler) callback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := h.provider(r)
+		if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+		// a flow is good for one callback
+		flow, ok := h.sessions.Pop(r.Context(), "oidc_flow").(oidcFlow)
+		q := r.URL.Query()
+		if !ok || flow.Provider != p.Name || expired(flow.Started) ||
+			subtle.ConstantTimeCompare([]byte(flow.State), []byte(q.Get("state"))) != 1 {
+			h.failed(w, r, flow, "The sign in could not be completed, please try again.")
+			return
+		}
+		if e := q.Get("error"); e != "" {
+			h.failed(w, r, flow, fmt.Sprintf("The sign in with %s was canceled or refused.", p.DisplayName))
+			return
+		}
+
+		raw, err := p.Exchange(r.Context(), q.Get("code"), h.redirectURL(r, p), flow.Verifier)
+		if err != nil {
+			log.Printf("error completing the sign in with %s: %v", p.Name, err)
+			h.failed(w, r, flow, "The sign in could not be completed, please try again.")
+			return
+		}
+		claims, err := p.Verify(r.Context(), raw, flow.Nonce)
+		if err != nil {
+			log.Printf("error completing the sign in with %s: %v", p.Name, err)
+			h.failed(w, r, flow, "The sign in could not be completed, please try again.")
+			return
+		}
+
+		identity, err := h.store.IdentityBySubject(r.Context(), p.Name, claims.Subject)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		linked := err == nil
+
+		if flow.UserID != uuid.Nil {
+			h.link(w, r, p, flow, claims, linked, identity)
+			return
+		}
+		if linked {
+			startSession(w, r, h.store, h.sessions, identity.UserID)
+			return
+		}
+
+		// the account is new to the site, its user chooses a username first
+		h.sessions.Put(r.Context(), "oidc_signup", oidcSignup{
+			Provider:      p.Name,
+			Subject:       claims.Subject,
+			Email:         claims.Email,
+			EmailVerified: claims.EmailVerified,
+			Username:      suggestUsername(claims),
+			Started:       time.Now().Unix(),
+		})
+		http.Redirect(w, r, "/auth/signup", http.StatusFound)
+	}
+}
+
+// link connects the account to the user who started the flow, as long as it is still the user of the session
+func (h *OIDCHandler) link(w http.ResponseWriter, r *http.Request, p *oidc.Provider, flow oidcFlow, claims oidc.Claims, linked bool, identity store.Identity) {
+	user, ok := UserFromContext(r.Context())
+	switch {
+	case !ok || user.ID != flow.UserID:
+		h.failed(w, r, oidcFlow{}, "Please log in again.")
+		return
+	case linked && identity.UserID == user.ID:
+		h.failed(w, r, flow, fmt.Sprintf("This %s account is connected already.", p.DisplayName))
+		return
+	case linked:
+		h.failed(w, r, flow, fmt.Sprintf("This %s account is connected to another user.", p.DisplayName))
+		return
+	}
+
+	ii, err := h.store.IdentitiesByUser(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, i := range ii {
+		if i.Provider == p.Name {
+			h.failed(w, r, flow, fmt.Sprintf("You have connected another %s account already, disconnect it first.", p.DisplayName))
+			return
+		}
+	}
+	if err := h.store.CreateIdentity(r.Context(), &store.Identity{
+		ID:       uuid.New(),
+		UserID:   user.ID,
+		Provider: p.Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.sessions.Put(r.Context(), "flash", fmt.Sprintf("Your %s account has been connected, you can log in with it.", p.DisplayName))
+	http.Redirect(w, r, "/settings/connections", http.StatusFound)
+}
+
+// suggestUsername offers the username the user has at the provider, or the start of the address
+func suggestUsername(c oidc.Claims) string {
+	if c.PreferredUsername != "" {
+		return c.PreferredUsername
+	}
+	if name, _, ok := strings.Cut(c.Email, "@"); ok {
+		return name
+	}
+	return ""
+}
+
+// pendingSignup returns the signup waiting in the session, as long as it waits
+func (h *OIDCHandler) pendingSignup(r *http.Request) (oidcSignup, *oidc.Provider, bool) {
+	signup, ok := h.sessions.Get(r.Context(), "oidc_signup").(oidcSignup)
+	if !ok || expired(signup.Started) {
+		return oidcSignup{}, nil, false
+	}
+	for _, p := range h.providers {
+		if p.Name == signup.Provider {
+			return signup, p, true
+		}
+	}
+	return oidcSignup{}, nil, false
+}
+
+func (h *OIDCHandler) signupView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Provider *oidc.Provider
+		// Signup is the form choosing the username, it starts with the one of the provider
+		Signup SignupForm
+		CSRF   template.HTML
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/user_signup.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		signup, p, ok := h.pendingSignup(r)
+		if !ok {
+			h.failed(w, r, oidcFlow{}, "Please sign in again.")
+			return
+		}
+		sd := GetSessionData(h.sessions, r.Context())
+		form, ok := sd.Form.(SignupForm)
+		if !ok {
+			form = SignupForm{Username: signup.Username}
+		}
+		tmpl.Execute(w, data{
+			SessionData: sd,
+			Provider:    p,
+			Signup:      form,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+// signup creates the user of the account with the chosen username and logs it in. The address of the account
+// comes along unless another user has it, verified when the provider says it is.
+func (h *OIDCHandler) signup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		signup, p, ok := h.pendingSignup(r)
+		if !ok {
+			h.failed(w, r, oidcFlow{}, "Please sign in again.")
+			return
+		}
+		form := SignupForm{
+			Username:      r.FormValue("username"),
+			UsernameTaken: false,
+		}
+		wait, err := registrationWait(r, h.store)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			h.sessions.Put(r.Context(), "form", form)
+			h.sessions.Put(r.Context(), "flash", lockoutMessage("registrations from your address", wait))
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+		if _, err := h.store.UserByUsername(r.Context(), form.Username); err == nil {
+			form.UsernameTaken = true
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		user := store.User{ID: uuid.New(), Username: form.Username}
+		if signup.Email != "" && validEmail(signup.Email) {
+			if _, err := h.store.UserByEmail(r.Context(), signup.Email); errors.Is(err, sql.ErrNoRows) {
+				user.Email, user.EmailVerified = signup.Email, signup.EmailVerified
+			}
+		}
+		// the user has no password, the account of the provider is the way in
+		err = h.store.WithTx(r.Context(), func(tx store.Store) error {
+			if err := tx.CreateUser(r.Context(), &user); err != nil {
+				return err
+			}
+			return tx.CreateIdentity(r.Context(), &store.Identity{
+				ID:       uuid.New(),
+				UserID:   user.ID,
+				Provider: p.Name,
+				Subject:  signup.Subject,
+				Email:    signup.Email,
+			})
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Remove(r.Context(), "oidc_signup")
+		startSession(w, r, h.store, h.sessions, user.ID)
+	}
+}
+
+// connection is a provider on the connections page, with the account of the user there if there is one
+type connection struct {
+	Provider *oidc.Provider
+	Identity *store.Identity
+}
+
+func (h *OIDCHandler) connectionsView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Connections []connection
+		CSRF        template.HTML
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/connections.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		ii, err := h.store.IdentitiesByUser(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cc := make([]connection, len(h.providers))
+		for n, p := range h.providers {
+			cc[n].Provider = p
+			for i := range ii {
+				if ii[i].Provider == p.Name {
+					cc[n].Identity = &ii[i]
+				}
+			}
+		}
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			Connections: cc,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+// disconnect unlinks an account of the user, but the last one of a user without password who would be locked out
+func (h *OIDCHandler) disconnect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// users only see their own accounts
+		user, _ := UserFromContext(r.Context())
+		ii, err := h.store.IdentitiesByUser(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var identity *store.Identity
+		for i := range ii {
+			if ii[i].ID == id {
+				identity = &ii[i]
+			}
+		}
+		if identity == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if user.Password == "" && len(ii) == 1 {
+			h.sessions.Put(r.Context(), "flash", "This is your only way to log in. Set a password with the forgot password page before disconnecting it.")
+			http.Redirect(w, r, "/settings/connections", http.StatusFound)
+			return
+		}
+
+		if err := h.store.DeleteIdentity(r.Context(), identity.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.sessions.Put(r.Context(), "flash", "The account has been disconnected.")
+		http.Redirect(w, r, "/settings/connections", http.StatusFound)
+	}
+}
@@ -0,0 +1,397 @@
+package web
+
+import (
+	"context"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/memory"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc/oidctest"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var csrfField = regexp.MustCompile(`name="gorilla.csrf.Token" value="([^"]+)"`)
+
+// browser visits the site and the provider with its own cookies, following the redirects between them
+type browser struct {
+	t      *testing.T
+	site   string
+	client *http.Client
+}
+
+func newBrowser(t *testing.T, site string) *browser {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &browser{t: t, site: site, client: &http.Client{Jar: jar}}
+}
+
+// page returns the path of the page the browser ends up on and its body
+func (b *browser) page(res *http.Response, err error) (string, string) {
+	b.t.Helper()
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		b.t.Fatalf("%s answered %s: %s", res.Request.URL, res.Status, body)
+	}
+	return res.Request.URL.Path, html.UnescapeString(string(body))
+}
+
+func (b *browser) get(path string) (string, string) {
+	b.t.Helper()
+	return b.page(b.client.Get(b.site + path))
+}
+
+// post submits the form of the page from, with its csrf token
+func (b *browser) post(from, action string, form url.Values) (string, string) {
+	b.t.Helper()
+	_, body := b.get(from)
+	m := csrfField.FindStringSubmatch(body)
+	if m == nil {
+		b.t.Fatalf("%s has no csrf token", from)
+	}
+	if form == nil {
+		form = url.Values{}
+	}
+	form.Set("gorilla.csrf.Token", m[1])
+	req, err := http.NewRequest(http.MethodPost, b.site+action, strings.NewReader(form.Encode()))
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Referer", b.site+from)
+	return b.page(b.client.Do(req))
+}
+
+// register creates a local user with a password and logs it in
+func (b *browser) register(username string) {
+	b.t.Helper()
+	form := url.Values{"username": {username}, "password": {"password123"}, "email": {username + "@example.com"}}
+	if path, _ := b.post("/register", "/register", form); path == "/register" {
+		b.t.Fatalf("%s could not register", username)
+	}
+	form.Del("email")
+	if path, _ := b.post("/login", "/login", form); path != "/" {
+		b.t.Fatalf("%s could not log in, ended on %s", username, path)
+	}
+}
+
+// loggedInAs fails unless the page shows the user logged in
+func loggedInAs(t *testing.T, body, username string) {
+	t.Helper()
+	if !strings.Contains(body, `href="/logout"`) || !strings.Contains(body, username) {
+		t.Fatalf("the page does not show %s logged in", username)
+	}
+}
+
+// newOIDCSite serves the site with the provider of the mock IdP under the name test
+func newOIDCSite(t *testing.T) (*httptest.Server, *oidctest.Server, store.Store) {
+	inRepoRoot(t)
+
+	idp := oidctest.NewServer("goreddit", "secret")
+	t.Cleanup(idp.Close)
+	s := memory.NewStore()
+	site := httptest.NewServer(NewHandler(s, NewMemorySessionManager(), make([]byte, 32),
+		WithOIDCProviders(oidc.NewProvider(idp.Config("test"), nil))))
+	t.Cleanup(site.Close)
+	return site, idp, s
+}
+
+func TestOIDCSignupAndLogin(t *testing.T) {
+	site, idp, s := newOIDCSite(t)
+	idp.SetUser(oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"})
+	b := newBrowser(t, site.URL)
+
+	_, body := b.get("/login")
+	if !strings.Contains(body, `href="/auth/test/login"`) {
+		t.Fatal("the login page does not offer the provider")
+	}
+
+	// the first login chooses a username, the one at the provider is offered
+	path, body := b.get("/auth/test/login")
+	if path != "/auth/signup" {
+		t.Fatalf("the first login ended on %s, want /auth/signup", path)
+	}
+	if !strings.Contains(body, `value="alice"`) {
+		t.Error("the signup does not offer the username of the provider")
+	}
+	path, body = b.post("/auth/signup", "/auth/signup", url.Values{"username": {"alice"}})
+	if path != "/" {
+		t.Fatalf("the signup ended on %s", path)
+	}
+	loggedInAs(t, body, "alice")
+
+	user, err := s.UserByUsername(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Password != "" || user.Email != "alice@example.com" || !user.EmailVerified {
+		t.Errorf("the new user is %+v", user)
+	}
+	if _, err := s.IdentityBySubject(context.Background(), "test", "alice-1"); err != nil {
+		t.Errorf("the identity was not linked: %v", err)
+	}
+
+	// a user without password does not log in with an empty one
+	b.get("/logout")
+	path, _ = b.post("/login", "/login", url.Values{"username": {"alice"}, "password": {""}})
+	if path != "/login" {
+		t.Fatalf("an empty password logged in, the login ended on %s", path)
+	}
+
+	// the next logins go straight in
+	path, body = b.get("/auth/test/login")
+	if path != "/" {
+		t.Fatalf("the second login ended on %s, want /", path)
+	}
+	loggedInAs(t, body, "alice")
+}
+
+func TestOIDCSignupUsernameTaken(t *testing.T) {
+	site, idp, _ := newOIDCSite(t)
+	b := newBrowser(t, site.URL)
+	b.register("bob")
+	b.get("/logout")
+
+	// another bob at the provider, with the address of the local bob
+	idp.SetUser(oidctest.User{Subject: "bob-2", Email: "bob@example.com", EmailVerified: true, PreferredUsername: "bob"})
+	other := newBrowser(t, site.URL)
+	other.get("/auth/test/login")
+	path, body := other.post("/auth/signup", "/auth/signup", url.Values{"username": {"bob"}})
+	if path != "/auth/signup" || !strings.Contains(body, "This username is already taken.") {
+		t.Fatalf("a taken username was accepted, the signup ended on %s", path)
+	}
+	path, body = other.post("/auth/signup", "/auth/signup", url.Values{"username": {"bob2"}})
+	if path != "/" {
+		t.Fatalf("the signup ended on %s", path)
+	}
+	loggedInAs(t, body, "bob2")
+	_, body = other.get("/email")
+	if strings.Contains(body, "bob@example.com") {
+		t.Error("the new user took the address of another user")
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	site, idp, _ := newOIDCSite(t)
+	idp.SetUser(oidctest.User{Subject: "alice-1"})
+	b := newBrowser(t, site.URL)
+
+	// a callback without a flow started by this browser
+	path, body := b.get("/auth/test/callback?state=forged&code=forged")
+	if path != "/login" || !strings.Contains(body, "The sign in could not be completed") {
+		t.Fatalf("a forged callback ended on %s", path)
+	}
+
+	// the callback of a flow with another state, like the code of an attacker
+	noRedirect := *b.client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	res, err := noRedirect.Get(site.URL + "/auth/test/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	res, err = noRedirect.Get(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := back.Query()
+	q.Set("state", "another")
+	back.RawQuery = q.Encode()
+	path, _ = b.page(b.client.Get(back.String()))
+	if path != "/login" {
+		t.Fatalf("a callback with another state ended on %s", path)
+	}
+
+	// the flow was spent by the failed callback, the right state does not work anymore
+	q.Set("state", res.Request.URL.Query().Get("state"))
+	back.RawQuery = q.Encode()
+	path, _ = b.page(b.client.Get(back.String()))
+	if path != "/login" {
+		t.Fatalf("a spent flow ended on %s", path)
+	}
+}
+
+func TestOIDCDenied(t *testing.T) {
+	site, _, _ := newOIDCSite(t)
+	b := newBrowser(t, site.URL)
+	// nobody signs in at the provider
+	path, body := b.get("/auth/test/login")
+	if path != "/login" || !strings.Contains(body, "was canceled or refused") {
+		t.Fatalf("a denied sign in ended on %s", path)
+	}
+}
+
+func TestOIDCConnectAndDisconnect(t *testing.T) {
+	site, idp, s := newOIDCSite(t)
+	ctx := context.Background()
+
+	alice := newBrowser(t, site.URL)
+	idp.SetUser(oidctest.User{Subject: "alice-1", PreferredUsername: "alice"})
+	alice.get("/auth/test/login")
+	alice.post("/auth/signup", "/auth/signup", url.Values{"username": {"alice"}})
+
+	bob := newBrowser(t, site.URL)
+	bob.register("bob")
+
+	// the account of alice is not bob's to connect
+	path, body := bob.post("/settings/connections", "/settings/connections/test", nil)
+	if path != "/settings/connections" || !strings.Contains(body, "connected to another user") {
+		t.Fatalf("bob connected the account of alice, ended on %s", path)
+	}
+
+	idp.SetUser(oidctest.User{Subject: "bob-1", Email: "bob@example.com"})
+	_, body = bob.post("/settings/connections", "/settings/connections/test", nil)
+	if !strings.Contains(body, "has been connected") {
+		t.Fatal("bob could not connect his account")
+	}
+	u, err := s.UserByUsername(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ii, err := s.IdentitiesByUser(ctx, u.ID)
+	if err != nil || len(ii) != 1 || ii[0].Subject != "bob-1" {
+		t.Fatalf("bob has the identities %+v, %v", ii, err)
+	}
+
+	// bob logs in with the account now
+	other := newBrowser(t, site.URL)
+	_, body = other.get("/auth/test/login")
+	loggedInAs(t, body, "bob")
+
+	// bob has a password, he can disconnect the account
+	_, body = bob.post("/settings/connections", "/settings/connections/"+ii[0].ID.String()+"/disconnect", nil)
+	if !strings.Contains(body, "The account has been disconnected.") {
+		t.Fatal("bob could not disconnect his account")
+	}
+	if _, err := s.IdentityBySubject(ctx, "test", "bob-1"); err == nil {
+		t.Error("the identity of bob is still there")
+	}
+
+	// alice has no password, the provider is her only way in
+	a, err := s.UserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ai, err := s.IdentitiesByUser(ctx, a.ID)
+	if err != nil || len(ai) != 1 {
+		t.Fatalf("alice has the identities %+v, %v", ai, err)
+	}
+	_, body = alice.post("/settings/connections", "/settings/connections/"+ai[0].ID.String()+"/disconnect", nil)
+	if !strings.Contains(body, "This is your only way to log in.") {
+		t.Error("alice disconnected her last way to log in")
+	}
+	// nor does bob disconnect the account of alice
+	res, err := bob.client.PostForm(site.URL+"/settings/connections/"+ai[0].ID.String()+"/disconnect", url.Values{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if _, err := s.IdentityBySubject(ctx, "test", "alice-1"); err != nil {
+		t.Errorf("the identity of alice is gone: %v", err)
+	}
+}
+
+func TestOIDCLoginAsksSecondFactor(t *testing.T) {
+	site, idp, s := newOIDCSite(t)
+	b := newBrowser(t, site.URL)
+	b.register("carol")
+	idp.SetUser(oidctest.User{Subject: "carol-1"})
+	b.post("/settings/connections", "/settings/connections/test", nil)
+	b.get("/logout")
+
+	u, err := s.UserByUsername(context.Background(), "carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnableTOTP(context.Background(), &store.TOTP{UserID: u.ID, Secret: "JBSWY3DPEHPK3PXP"}, []store.RecoveryCode{}); err != nil {
+		t.Fatal(err)
+	}
+
+	path, body := b.get("/auth/test/login")
+	if path != "/login/2fa" {
+		t.Fatalf("the login ended on %s, want /login/2fa", path)
+	}
+	if strings.Contains(body, `href="/logout"`) {
+		t.Error("the user is logged in before the second factor")
+	}
+}
+
+// a user without password could never turn the second factor off, the password comes first
+func TestOIDCTwoFactorNeedsPassword(t *testing.T) {
+	site, idp, s := newOIDCSite(t)
+	idp.SetUser(oidctest.User{Subject: "erin-1", PreferredUsername: "erin"})
+	b := newBrowser(t, site.URL)
+	b.get("/auth/test/login")
+	b.post("/auth/signup", "/auth/signup", url.Values{"username": {"erin"}})
+
+	_, body := b.get("/settings/2fa")
+	if strings.Contains(body, "QR code") || !strings.Contains(body, "have no password yet") {
+		t.Fatal("a user without password is offered to enroll")
+	}
+	_, body = b.post("/settings/connections", "/settings/2fa", url.Values{"code": {"123456"}})
+	if !strings.Contains(body, "Please set a password before turning on two-factor authentication.") {
+		t.Error("the enrollment of a user without password is not refused")
+	}
+	u, err := s.UserByUsername(context.Background(), "erin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.TOTP(context.Background(), u.ID); err == nil {
+		t.Fatal("a user without password enrolled")
+	}
+
+	// once the user has a password the enrollment is offered
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Password = string(hash)
+	if err := s.UpdateUser(context.Background(), &u); err != nil {
+		t.Fatal(err)
+	}
+	if _, body = b.get("/settings/2fa"); !strings.Contains(body, "QR code") {
+		t.Error("a user with a password is not offered to enroll")
+	}
+}
+
+// the identities of a user go away with the user
+func TestOIDCIdentitiesDeletedWithUser(t *testing.T) {
+	s := memory.NewStore()
+	ctx := context.Background()
+	u := store.User{ID: uuid.New(), Username: "dave"}
+	if err := s.CreateUser(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateIdentity(ctx, &store.Identity{ID: uuid.New(), UserID: u.ID, Provider: "test", Subject: "dave-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUser(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.IdentityBySubject(ctx, "test", "dave-1"); err == nil {
+		t.Error("the identity outlived its user")
+	}
+}
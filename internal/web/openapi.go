@@ -0,0 +1,336 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// apiOperation describes a route of the api in the openapi document, every route registered by Routes needs one
+type apiOperation struct {
+	id      string
+	method  string
+	path    string
+	summary string
+	query   []apiParam
+	// zero values of the types of the bodies, request is nil when the route takes no body and response when it
+	// answers 204
+	request  interface{}
+	response interface{}
+	status   int
+	// the response is a page of a listing
+	list bool
+	// the route needs a logged in user
+	auth bool
+	// forbidden tells which users the policy refuses, the 403 of every route needing a user describes the csrf and
+	// token failures as well
+	forbidden string
+}
+
+// apiParam is a query parameter of an operation
+type apiParam struct {
+	name        string
+	description string
+	enum        []string
+}
+
+var (
+	cursorParam = apiParam{name: "cursor", description: "cursor of the page, taken from the cursors of the previous response"}
+	sortParams  = []apiParam{
+		{name: "sort", description: "order of the posts, hot when missing", enum: []string{
+			string(store.OrderHot), string(store.OrderNew), string(store.OrderTop), string(store.OrderControversial)}},
+		{name: "t", description: "time window of the top and controversial orders, day when missing", enum: sortWindowNames},
+		cursorParam,
+	}
+)
+
+var apiOperations = []apiOperation{
+	// threads
+	{id: "listThreads", method: http.MethodGet, path: "/threads", summary: "List the threads",
+		query: []apiParam{cursorParam}, response: apiThread{}, status: http.StatusOK, list: true},
+	{id: "getThread", method: http.MethodGet, path: "/threads/{threadId}", summary: "Get a thread",
+		response: apiThread{}, status: http.StatusOK},
+	{id: "createThread", method: http.MethodPost, path: "/threads", summary: "Create a thread",
+		request: CreateThreadForm{}, response: apiThread{}, status: http.StatusCreated, auth: true},
+	{id: "updateThread", method: http.MethodPut, path: "/threads/{threadId}", summary: "Edit a thread, authors only",
+		request: CreateThreadForm{}, response: apiThread{}, status: http.StatusOK, auth: true,
+		forbidden: "The user is not the author of the thread"},
+	{id: "deleteThread", method: http.MethodDelete, path: "/threads/{threadId}", summary: "Move a thread to the trash, authors and admins only, the thread moderators cannot",
+		status: http.StatusNoContent, auth: true,
+		forbidden: "The user is neither the author of the thread nor an admin"},
+
+	// posts
+	{id: "listPosts", method: http.MethodGet, path: "/posts", summary: "List the posts of every thread",
+		query: sortParams, response: apiPost{}, status: http.StatusOK, list: true},
+	{id: "listThreadPosts", method: http.MethodGet, path: "/threads/{threadId}/posts", summary: "List the posts of a thread",
+		query: sortParams, response: apiPost{}, status: http.StatusOK, list: true},
+	{id: "getPost", method: http.MethodGet, path: "/posts/{postId}", summary: "Get a post",
+		response: apiPost{}, status: http.StatusOK},
+	{id: "createPost", method: http.MethodPost, path: "/threads/{threadId}/posts", summary: "Create a post in a thread",
+		request: CreatePostForm{}, response: apiPost{}, status: http.StatusCreated, auth: true,
+		forbidden: "The thread is locked and the user does not moderate it"},
+	{id: "updatePost", method: http.MethodPut, path: "/posts/{postId}", summary: "Edit a post, authors only",
+		request: CreatePostForm{}, response: apiPost{}, status: http.StatusOK, auth: true,
+		forbidden: "The user is not the author of the post"},
+	{id: "deletePost", method: http.MethodDelete, path: "/posts/{postId}", summary: "Move a post to the trash, authors and thread moderators only, the admins moderate every thread",
+		status: http.StatusNoContent, auth: true,
+		forbidden: "The user neither wrote the post nor moderates its thread"},
+	{id: "votePost", method: http.MethodPut, path: "/posts/{postId}/vote", summary: "Vote a post, a value of 0 retracts the vote",
+		request: apiVote{}, response: apiPost{}, status: http.StatusOK, auth: true,
+		forbidden: "The post is in the trash"},
+
+	// comments
+	{id: "listComments", method: http.MethodGet, path: "/posts/{postId}/comments", summary: "List the comment trees of a post",
+		query: []apiParam{cursorParam}, response: apiComment{}, status: http.StatusOK, list: true},
+	{id: "getComment", method: http.MethodGet, path: "/comments/{commentId}", summary: "Get a comment with its replies",
+		response: apiComment{}, status: http.StatusOK},
+	{id: "createComment", method: http.MethodPost, path: "/posts/{postId}/comments", summary: "Comment a post or reply to a comment",
+		request: CreateCommentForm{}, response: apiComment{}, status: http.StatusCreated, auth: true,
+		forbidden: "The thread is locked and the user does not moderate it, the post or the comment is in the trash"},
+	{id: "updateComment", method: http.MethodPut, path: "/comments/{commentId}", summary: "Edit a comment, authors only",
+		request: CreateCommentForm{}, response: apiComment{}, status: http.StatusOK, auth: true,
+		forbidden: "The user is not the author of the comment"},
+	{id: "deleteComment", method: http.MethodDelete, path: "/comments/{commentId}", summary: "Move a comment to the trash, authors and thread moderators only, the admins moderate every thread",
+		status: http.StatusNoContent, auth: true,
+		forbidden: "The user neither wrote the comment nor moderates its thread"},
+	{id: "voteComment", method: http.MethodPut, path: "/comments/{commentId}/vote", summary: "Vote a comment, a value of 0 retracts the vote",
+		request: apiVote{}, response: apiComment{}, status: http.StatusOK, auth: true,
+		forbidden: "The comment is in the trash"},
+}
+
+// forbiddenDescription tells who gets a 403 from an operation needing a user, the reason of the operation comes first
+func forbiddenDescription(reason string) string {
+	common := "the csrf token of the session is missing or wrong, the personal access token is a read token, " +
+		"or the deployment wants a verified email address before posting"
+	if reason == "" {
+		return strings.ToUpper(common[:1]) + common[1:]
+	}
+	return reason + ", " + common
+}
+
+// specView serves the openapi document of the api, it is built once from the operations
+func (h *APIHandler) specView() http.HandlerFunc {
+	doc := openAPIDocument(apiOperations, h.sessions.Cookie.Name)
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, doc)
+	}
+}
+
+type object = map[string]interface{}
+
+var pathParam = regexp.MustCompile(`{(\w+)}`)
+
+// openAPIDocument builds the openapi 3 document of the operations, the schemas are derived from the go types of the bodies
+func openAPIDocument(ops []apiOperation, sessionCookie string) object {
+	schemas := schemaSet{}
+	errorSchema := schemas.ref(reflect.TypeOf(apiErrorResponse{}))
+	cursorsSchema := schemas.ref(reflect.TypeOf(apiCursors{}))
+
+	paths := object{}
+	for _, op := range ops {
+		o := object{"operationId": op.id, "summary": op.summary}
+
+		var params []object
+		for _, m := range pathParam.FindAllStringSubmatch(op.path, -1) {
+			params = append(params, object{
+				"name": m[1], "in": "path", "required": true,
+				"schema": object{"type": "string", "format": "uuid"},
+			})
+		}
+		for _, q := range op.query {
+			s := object{"type": "string"}
+			if q.enum != nil {
+				s["enum"] = q.enum
+			}
+			params = append(params, object{"name": q.name, "in": "query", "description": q.description, "schema": s})
+		}
+		if params != nil {
+			o["parameters"] = params
+		}
+
+		if op.request != nil {
+			o["requestBody"] = object{
+				"required": true,
+				"content":  object{"application/json": object{"schema": schemas.ref(reflect.TypeOf(op.request))}},
+			}
+		}
+
+		responses := object{}
+		success := object{"description": http.StatusText(op.status)}
+		if op.response != nil {
+			data := schemas.ref(reflect.TypeOf(op.response))
+			envelope := object{"type": "object", "required": []string{"data"}, "properties": object{"data": data}}
+			if op.list {
+				envelope["required"] = []string{"data", "cursors"}
+				envelope["properties"] = object{
+					"data":    object{"type": "array", "items": data},
+					"cursors": cursorsSchema,
+				}
+			}
+			success["content"] = object{"application/json": object{"schema": envelope}}
+		}
+		responses[strconv.Itoa(op.status)] = success
+
+		// the errors the operation can answer, every error has the same body
+		var failures []int
+		if params != nil || op.request != nil {
+			failures = append(failures, http.StatusBadRequest)
+		}
+		if op.auth {
+			failures = append(failures, http.StatusUnauthorized, http.StatusForbidden)
+		}
+		if strings.Contains(op.path, "{") {
+			failures = append(failures, http.StatusNotFound)
+		}
+		if op.request != nil {
+			failures = append(failures, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity)
+		}
+		failures = append(failures, http.StatusInternalServerError)
+		for _, code := range failures {
+			description := http.StatusText(code)
+			if code == http.StatusForbidden {
+				description = forbiddenDescription(op.forbidden)
+			}
+			responses[strconv.Itoa(code)] = object{
+				"description": description,
+				"content":     object{"application/json": object{"schema": errorSchema}},
+			}
+		}
+		o["responses"] = responses
+
+		if op.auth {
+			o["security"] = []object{{"session": []string{}, "csrf": []string{}}, {"token": []string{}}}
+		}
+
+		item, ok := paths[op.path].(object)
+		if !ok {
+			item = object{}
+			paths[op.path] = item
+		}
+		item[strings.ToLower(op.method)] = o
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "goreddit API",
+			"version": "1",
+		},
+		"servers": []object{{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": object{
+			"schemas": schemas,
+			"securitySchemes": object{
+				"session": object{
+					"type": "apiKey", "in": "cookie", "name": sessionCookie,
+					"description": "session cookie set by the login form",
+				},
+				"csrf": object{
+					"type": "apiKey", "in": "header", "name": "X-CSRF-Token",
+					"description": "csrf token sent back in the X-CSRF-Token header of every response",
+				},
+				"token": object{
+					"type": "http", "scheme": "bearer",
+					"description": "personal access token created in the settings, read tokens can only call GET operations",
+				},
+			},
+		},
+	}
+}
+
+// schemaSet holds the schemas of the named types, they are referenced so recursive types like the comments work
+type schemaSet object
+
+var (
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// ref returns the schema of the type, named structs are added to the set and referenced
+func (s schemaSet) ref(t reflect.Type) object {
+	switch t {
+	case uuidType:
+		return object{"type": "string", "format": "uuid"}
+	case timeType:
+		return object{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem := s.ref(t.Elem())
+		if _, ok := elem["$ref"]; ok {
+			return object{"allOf": []object{elem}, "nullable": true}
+		}
+		elem["nullable"] = true
+		return elem
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return object{"type": "array", "items": s.ref(t.Elem())}
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": s.ref(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := s[name]; !ok {
+			// reserve the name first, the fields may refer to the type itself
+			s[name] = nil
+			s[name] = s.object(t)
+		}
+		return object{"$ref": "#/components/schemas/" + name}
+	}
+	// interfaces and whatever else can hold any value
+	return object{}
+}
+
+// object returns the schema of a struct from the json tags of its fields, omitempty fields are optional
+func (s schemaSet) object(t reflect.Type) object {
+	properties := object{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+		properties[name] = s.ref(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	o := object{"type": "object", "properties": properties}
+	if required != nil {
+		o["required"] = required
+	}
+	return o
+}
+
+// schemaName names the schema of a type, the api representations drop their prefix, e.g. apiThread is Thread
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
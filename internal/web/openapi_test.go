@@ -0,0 +1,103 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/memory"
+)
+
+const apiPrefix = "/api/v1"
+
+// TestOpenAPICoversRoutes fails when a route of the api has no entry in the served document or the other way around
+func TestOpenAPICoversRoutes(t *testing.T) {
+	// the handlers parse the templates from the root of the repository
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	h := NewHandler(memory.NewStore(), NewMemorySessionManager(), make([]byte, 32))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /api/openapi.json answered %d", rec.Code)
+	}
+	var doc struct {
+		OpenAPI string                                `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("openapi version is %q, want 3.x", doc.OpenAPI)
+	}
+
+	routes := map[string]bool{}
+	err = chi.Walk(h, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if !strings.HasPrefix(route, apiPrefix+"/") {
+			return nil
+		}
+		path := strings.TrimSuffix(strings.TrimPrefix(route, apiPrefix), "/")
+		routes[method+" "+path] = true
+		if _, ok := doc.Paths[path][strings.ToLower(method)]; !ok {
+			t.Errorf("route %s %s has no entry in the openapi document", method, route)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) == 0 {
+		t.Fatal("no api routes found")
+	}
+
+	for path, item := range doc.Paths {
+		for method := range item {
+			if !routes[strings.ToUpper(method)+" "+path] {
+				t.Errorf("the openapi document describes %s %s which is not routed", strings.ToUpper(method), path)
+			}
+		}
+	}
+}
+
+// TestOpenAPISchemas checks the schemas follow the json names of the go types
+func TestOpenAPISchemas(t *testing.T) {
+	doc := openAPIDocument(apiOperations, "session")
+	schemas := doc["components"].(object)["schemas"].(schemaSet)
+
+	comment, ok := schemas["Comment"]
+	if !ok {
+		t.Fatal("no Comment schema")
+	}
+	props := comment.(object)["properties"].(object)
+	for _, name := range []string{"id", "post_id", "parent_id", "replies"} {
+		if _, ok := props[name]; !ok {
+			t.Errorf("Comment schema has no %s property", name)
+		}
+	}
+	// the replies refer to the comment schema itself
+	replies := props["replies"].(object)["items"].(object)
+	if replies["$ref"] != "#/components/schemas/Comment" {
+		t.Errorf("replies items are %v, want a reference to Comment", replies)
+	}
+
+	form := schemas["CreateCommentForm"].(object)
+	if _, ok := form["properties"].(object)["Errors"]; ok {
+		t.Error("the errors of the forms are not part of the request body")
+	}
+	if req := form["required"].([]string); len(req) != 1 || req[0] != "content" {
+		t.Errorf("CreateCommentForm requires %v, want [content]", req)
+	}
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/policy"
+)
+
+// can tells the templates whether the user may do the action on the thread, post or comment, nil stands for the site
+func can(user store.User, a policy.Action, content interface{}) (bool, error) {
+	res, err := resource(content)
+	if err != nil {
+		return false, fmt.Errorf("can: %w", err)
+	}
+	return policy.Can(user, a, res), nil
+}
+
+// resource returns the policy resource of the thread, post or comment, nil stands for the site
+func resource(content interface{}) (policy.Resource, error) {
+	switch c := content.(type) {
+	case nil:
+		return policy.Site(), nil
+	case store.Thread:
+		return policy.Thread(c), nil
+	case store.Post:
+		return policy.Post(c), nil
+	case store.Comment:
+		return policy.Comment(c), nil
+	}
+	return policy.Resource{}, fmt.Errorf("unexpected content %T", content)
+}
+
+// authorize answers the 403 page when the user of the request may not do the action on the resource
+func authorize(w http.ResponseWriter, r *http.Request, sessions *scs.SessionManager, a policy.Action, res policy.Resource) bool {
+	user, _ := UserFromContext(r.Context())
+	if policy.Can(user, a, res) {
+		return true
+	}
+	forbidden(w, r, sessions, deniedMessage(a, res))
+	return false
+}
+
+// deniedMessage explains to the user what the policy does not allow
+func deniedMessage(a policy.Action, res policy.Resource) string {
+	switch {
+	case res.Type == "":
+		return "You cannot create threads."
+	case a == policy.Create && res.Locked:
+		return "This thread is locked, only its moderators can post in it."
+	case a == policy.Appoint:
+		return "Only the admins can appoint the moderators."
+	case a == policy.Create:
+		return fmt.Sprintf("You cannot add to this %s.", res.Type)
+	}
+	return fmt.Sprintf("You cannot %s this %s.", a, res.Type)
+}
+
+// the 403 page is parsed on its first use, it is shared by every handler
+var forbiddenPage struct {
+	once sync.Once
+	tmpl *template.Template
+}
+
+// forbidden answers the 403 page with the message
+func forbidden(w http.ResponseWriter, r *http.Request, sessions *scs.SessionManager, message string) {
+	type data struct {
+		SessionData
+		Message string
+	}
+
+	forbiddenPage.once.Do(func() {
+		forbiddenPage.tmpl = parseTemplates("templates/layout.html", "templates/forbidden.html")
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	forbiddenPage.tmpl.Execute(w, data{
+		SessionData: GetSessionData(sessions, r.Context()),
+		Message:     message,
+	})
+}
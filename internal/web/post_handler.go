@@ -1,6 +1,9 @@
 package web
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"html/template"
 	"net/http"
 
@@ -9,11 +12,14 @@ import (
 	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/policy"
 )
 
 type PostHandler struct {
 	store    store.Store
 	sessions *scs.SessionManager
+	// levels of replies shown below a comment
+	commentDepth int
 }
 
 func (h *PostHandler) createView() http.HandlerFunc {
@@ -23,7 +29,7 @@ func (h *PostHandler) createView() http.HandlerFunc {
 		CSRF   template.HTML // string which is not escaped
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/post_create.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/post_create.html")
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		//parse the id
@@ -34,11 +40,18 @@ func (h *PostHandler) createView() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		t, err := h.store.Thread(id)
+		t, err := h.store.Thread(r.Context(), id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if t.DeletedAt.Valid {
+			http.NotFound(w, r)
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Create, policy.Thread(t)) {
+			return
+		}
 		tmpl.Execute(w, data{
 			SessionData: GetSessionData(h.sessions, r.Context()),
 			Thread:      t,
@@ -50,13 +63,19 @@ func (h *PostHandler) createView() http.HandlerFunc {
 func (h *PostHandler) view() http.HandlerFunc {
 	type data struct {
 		SessionData
-		Thread   store.Thread
-		Post     store.Post
-		Comments []store.Comment
+		PageData
+		Thread       store.Thread
+		Post         store.Post
+		Comments     []store.Comment
+		PostVotes    map[uuid.UUID]int
+		CommentVotes map[uuid.UUID]int
+		// Focused is set when only the thread of a single comment is shown
+		Focused  bool
+		MaxDepth int
 		CSRF     template.HTML // string which is not escaped
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/post.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/post.html")
 	return func(w http.ResponseWriter, r *http.Request) {
 		//parse the thread id to which the Post belongs
 		threadIdStr := chi.URLParam(r, "threadId")
@@ -69,7 +88,7 @@ func (h *PostHandler) view() http.HandlerFunc {
 		}
 
 		// retrieve the thread from db
-		t, err := h.store.Thread(threadId)
+		t, err := h.store.Thread(r.Context(), threadId)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -85,13 +104,62 @@ func (h *PostHandler) view() http.HandlerFunc {
 			return
 		}
 		// retrieve the post from db
-		p, err := h.store.Post(postId)
+		p, err := h.store.Post(r.Context(), postId)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// what is in the trash is gone for visitors
+		if t.DeletedAt.Valid || p.DeletedAt.Valid {
+			http.NotFound(w, r)
+			return
+		}
+		// answer 304 when nothing changed since the client got the page, the anonymous visitors
+		// get no forms and so no csrf fields, their page may be shared
+		modified, err := h.store.PostModified(r.Context(), p.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if notModified(w, r, h.sessions, modified, true) {
+			return
+		}
+
+		// retrieve a page of comment trees from db, or the thread of a single comment when its id is in the path
+		var cc []store.Comment
+		var cursors store.Cursors
+		commentIdStr := chi.URLParam(r, "commentId")
+		if commentIdStr != "" {
+			commentId, err := uuid.Parse(commentIdStr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c, err := h.store.CommentThread(r.Context(), commentId, h.commentDepth)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if c.PostID != p.ID {
+				http.NotFound(w, r)
+				return
+			}
+			cc = []store.Comment{c}
+		} else {
+			cc, cursors, err = h.store.CommentTree(r.Context(), p.ID, parsePage(r), h.commentDepth)
+			if err != nil {
+				listingError(w, err)
+				return
+			}
+		}
+
+		// retrieve the votes of the current user to highlight them
+		pv, err := postVotes(h.store, r.Context(), []store.Post{p})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		// retrieve the comments from db
-		cc, err := h.store.CommentsByPost(p.ID)
+		cv, err := commentVotes(h.store, r.Context(), flattenComments(cc))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -99,11 +167,16 @@ func (h *PostHandler) view() http.HandlerFunc {
 
 		// execute the template passing both the thread and post
 		tmpl.Execute(w, data{
-			SessionData: GetSessionData(h.sessions, r.Context()),
-			Thread:      t,
-			Post:        p,
-			Comments:    cc,
-			CSRF:        csrf.TemplateField(r),
+			SessionData:  GetSessionData(h.sessions, r.Context()),
+			PageData:     newPageData(r, cursors),
+			Thread:       t,
+			Post:         p,
+			Comments:     cc,
+			PostVotes:    pv,
+			CommentVotes: cv,
+			Focused:      commentIdStr != "",
+			MaxDepth:     h.commentDepth,
+			CSRF:         csrf.TemplateField(r),
 		})
 	}
 }
@@ -120,11 +193,18 @@ func (h *PostHandler) save() http.HandlerFunc {
 			return
 		}
 		// verify that the thread exists
-		t, err := h.store.Thread(id)
+		t, err := h.store.Thread(r.Context(), id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if t.DeletedAt.Valid {
+			http.NotFound(w, r)
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Create, policy.Thread(t)) {
+			return
+		}
 
 		//parse the form for new post info
 		form := CreatePostForm{
@@ -139,17 +219,20 @@ func (h *PostHandler) save() http.HandlerFunc {
 			http.Redirect(w, r, r.Referer(), http.StatusFound)
 			return
 		}
-		//send new post to db
+		//send new post to db, the author is the logged in user
+		user, _ := UserFromContext(r.Context())
 		p := &store.Post{
 			ID:       uuid.New(),
 			ThreadID: t.ID,
+			AuthorID: uuid.NullUUID{UUID: user.ID, Valid: true},
 			Title:    form.Title,
 			Content:  form.Content,
 		}
-		if err := h.store.CreatePost(p); err != nil {
+		if err := h.store.CreatePost(r.Context(), p); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookPostCreated, p.ID)
 
 		// add flash message
 		h.sessions.Put(r.Context(), "flash", "Your new post has been created.")
@@ -158,6 +241,48 @@ func (h *PostHandler) save() http.HandlerFunc {
 	}
 }
 
+func (h *PostHandler) delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		//parse the post id
+		idStr := chi.URLParam(r, "postId")
+
+		//parse and validate the id
+		id, err := uuid.Parse(idStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// retrieve the post
+		p, err := h.store.Post(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !authorize(w, r, h.sessions, policy.Delete, policy.Post(p)) {
+			return
+		}
+
+		//move post to the trash
+		user, _ := UserFromContext(r.Context())
+		moderated, err := remove(r.Context(), h.store, user, p, func(s store.Store) error {
+			return s.DeletePost(r.Context(), p.ID)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookPostDeleted, p.ID)
+
+		// add flash message
+		h.sessions.Put(r.Context(), "flash", removedMessage(store.ContentPost, moderated))
+
+		// redirect to the thread of the post
+		http.Redirect(w, r, "/threads/"+p.ThreadID.String(), http.StatusFound)
+	}
+}
+
 func (h *PostHandler) vote() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		//parse the post id
@@ -171,22 +296,40 @@ func (h *PostHandler) vote() http.HandlerFunc {
 		}
 
 		// retrieve the post
-		p, err := h.store.Post(id)
+		p, err := h.store.Post(r.Context(), id)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if p.DeletedAt.Valid {
+			http.NotFound(w, r)
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Vote, policy.Post(p)) {
+			return
+		}
 
-		//increase/decrease the vote
-		dir := r.URL.Query().Get("dir")
-		if dir == "up" {
-			p.Votes++
-		} else if dir == "down" {
-			p.Votes--
+		// parse the direction of the vote
+		value, ok := voteValue(r.FormValue("dir"))
+		if !ok {
+			http.Error(w, "invalid vote direction", http.StatusBadRequest)
+			return
 		}
 
-		//update the comment in db
-		if err := h.store.UpdatePost(&p); err != nil {
+		// voting twice in the same direction retracts the vote, reading and writing in one transaction
+		// so two quick clicks cannot both see the old vote
+		user, _ := UserFromContext(r.Context())
+		err = h.store.WithTx(r.Context(), func(s store.Store) error {
+			votes, err := s.PostVotes(r.Context(), user.ID, []uuid.UUID{p.ID})
+			if err != nil {
+				return err
+			}
+			if votes[p.ID] == value {
+				return s.RetractPostVote(r.Context(), user.ID, p.ID)
+			}
+			return s.VotePost(r.Context(), user.ID, p.ID, value)
+		})
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -194,3 +337,154 @@ func (h *PostHandler) vote() http.HandlerFunc {
 		http.Redirect(w, r, r.Referer(), http.StatusFound)
 	}
 }
+
+// voteValue converts the dir query parameter of the vote routes into a ledger value
+func voteValue(dir string) (int, bool) {
+	switch dir {
+	case "up":
+		return store.VoteUp, true
+	case "down":
+		return store.VoteDown, true
+	}
+	return store.VoteNone, false
+}
+
+// postVotes returns the votes of the logged in user on the given posts, anonymous visitors get an empty map
+func postVotes(s store.Store, ctx context.Context, pp []store.Post) (map[uuid.UUID]int, error) {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return map[uuid.UUID]int{}, nil
+	}
+	ids := make([]uuid.UUID, len(pp))
+	for i, p := range pp {
+		ids[i] = p.ID
+	}
+	return s.PostVotes(ctx, user.ID, ids)
+}
+
+func (h *PostHandler) editView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Thread store.Thread
+		Post   store.Post
+		CSRF   template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/post_edit.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Edit, policy.Post(p)) {
+			return
+		}
+		sd := GetSessionData(h.sessions, r.Context())
+		// the form starts with the current values, unless it is sent back after a validation error
+		if _, ok := sd.Form.(CreatePostForm); !ok {
+			sd.Form = CreatePostForm{Title: p.Title, Content: p.Content}
+		}
+		tmpl.Execute(w, data{
+			SessionData: sd,
+			Thread:      t,
+			Post:        p,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *PostHandler) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Edit, policy.Post(p)) {
+			return
+		}
+
+		form := CreatePostForm{
+			Title:   r.FormValue("title"),
+			Content: r.FormValue("content"),
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		p.Title, p.Content = form.Title, form.Content
+		if err := h.store.UpdatePost(r.Context(), &p); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookPostUpdated, p.ID)
+
+		h.sessions.Put(r.Context(), "flash", "Your post has been saved.")
+		http.Redirect(w, r, "/threads/"+t.ID.String()+"/"+p.ID.String(), http.StatusFound)
+	}
+}
+
+// pin pins or unpins the post on top of its thread
+func (h *PostHandler) pin(pinned bool) http.HandlerFunc {
+	action, message := store.ModPinPost, "The post has been pinned."
+	if !pinned {
+		action, message = store.ModUnpinPost, "The post has been unpinned."
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, p, ok := h.post(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Pin, policy.Post(p)) {
+			return
+		}
+
+		// there is nothing to log when the post is already as asked
+		if p.Pinned != pinned {
+			user, _ := UserFromContext(r.Context())
+			if err := moderate(r.Context(), h.store, modAction(user, action, p), func(s store.Store) error {
+				return s.PinPost(r.Context(), p.ID, pinned)
+			}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		h.sessions.Put(r.Context(), "flash", message)
+		http.Redirect(w, r, "/threads/"+t.ID.String(), http.StatusFound)
+	}
+}
+
+// post retrieves the thread and the post in the url, what is in the trash is not found
+func (h *PostHandler) post(w http.ResponseWriter, r *http.Request) (store.Thread, store.Post, bool) {
+	threadID, err := uuid.Parse(chi.URLParam(r, "threadId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return store.Thread{}, store.Post{}, false
+	}
+	postID, err := uuid.Parse(chi.URLParam(r, "postId"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return store.Thread{}, store.Post{}, false
+	}
+	p, err := h.store.Post(r.Context(), postID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && (p.DeletedAt.Valid || p.ThreadID != threadID) {
+		http.NotFound(w, r)
+		return store.Thread{}, store.Post{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return store.Thread{}, store.Post{}, false
+	}
+	t, err := h.store.Thread(r.Context(), p.ThreadID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return store.Thread{}, store.Post{}, false
+	}
+	if t.DeletedAt.Valid {
+		http.NotFound(w, r)
+		return store.Thread{}, store.Post{}, false
+	}
+	return t, p, true
+}
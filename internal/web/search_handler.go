@@ -0,0 +1,81 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+type SearchHandler struct {
+	store    store.Store
+	sessions *scs.SessionManager
+}
+
+// searchTypes are the values of the type filter in the order they are shown
+var searchTypes = []store.ContentType{store.ContentThread, store.ContentPost, store.ContentComment}
+
+func (h *SearchHandler) view() http.HandlerFunc {
+	type data struct {
+		SessionData
+		PageData
+		Query   string
+		Type    store.ContentType
+		Types   []store.ContentType
+		Thread  string
+		Threads []store.Thread
+		Results []store.SearchResult
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/search.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		// parse the query and the filters, empty filters search everything
+		q := store.SearchQuery{Terms: r.URL.Query().Get("q")}
+		if thread := r.URL.Query().Get("thread"); thread != "" {
+			id, err := uuid.Parse(thread)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			q.ThreadID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+		switch t := store.ContentType(r.URL.Query().Get("type")); t {
+		case "", store.ContentThread, store.ContentPost, store.ContentComment:
+			q.Type = t
+		default:
+			http.Error(w, "invalid search type", http.StatusBadRequest)
+			return
+		}
+
+		// the threads fill the thread filter
+		threads, err := h.store.Threads(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var results []store.SearchResult
+		var cc store.Cursors
+		if q.Terms != "" {
+			if results, cc, err = h.store.Search(r.Context(), q, parsePage(r)); err != nil {
+				listingError(w, err)
+				return
+			}
+		}
+
+		d := data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			PageData:    newPageData(r, cc),
+			Query:       q.Terms,
+			Type:        q.Type,
+			Types:       searchTypes,
+			Threads:     threads,
+			Results:     results,
+		}
+		if q.ThreadID.Valid {
+			d.Thread = q.ThreadID.UUID.String()
+		}
+		tmpl.Execute(w, d)
+	}
+}
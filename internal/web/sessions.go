@@ -27,6 +27,11 @@ func NewSessionManager(dataSourceName string) (*scs.SessionManager, error) {
 	return sessions, nil
 }
 
+// NewMemorySessionManager keeps the sessions in memory, it goes together with the memory store
+func NewMemorySessionManager() *scs.SessionManager {
+	return scs.New()
+}
+
 // All sessions data are included in a single struct
 type SessionData struct {
 	FlashMessage string
@@ -41,7 +46,7 @@ func GetSessionData(session *scs.SessionManager, ctx context.Context) SessionDat
 	// Get the flash message from the session, we use pop to remove it from the session because we want to display it only once
 	data.FlashMessage = session.PopString(ctx, "flash")
 	// retrieve user from context
-	data.User, data.LoggedIn = ctx.Value("user").(store.User)
+	data.User, data.LoggedIn = UserFromContext(ctx)
 
 	// Get the form from the session
 	data.Form = session.Pop(ctx, "form")
@@ -51,3 +56,15 @@ func GetSessionData(session *scs.SessionManager, ctx context.Context) SessionDat
 
 	return data
 }
+
+// UserFromContext returns the user the withUser middleware added to the request context
+func UserFromContext(ctx context.Context) (store.User, bool) {
+	u, ok := ctx.Value("user").(store.User)
+	return u, ok
+}
+
+// TokenFromContext returns the personal access token the request was authenticated with, if any
+func TokenFromContext(ctx context.Context) (store.Token, bool) {
+	t, ok := ctx.Value("token").(store.Token)
+	return t, ok
+}
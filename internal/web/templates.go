@@ -0,0 +1,159 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// functions available in every template
+var templateFuncs = template.FuncMap{
+	"timeAgo":   timeAgo,
+	"dict":      dict,
+	"highlight": highlight,
+	"can":       can,
+	"modAction": describeModAction,
+}
+
+// parseTemplates works like template.ParseFiles but makes templateFuncs available, the first file is the one executed
+func parseTemplates(files ...string) *template.Template {
+	return template.Must(template.New(filepath.Base(files[0])).Funcs(templateFuncs).ParseFiles(files...))
+}
+
+// timeAgo formats a time relative to now, e.g. "3 hours ago"
+func timeAgo(t time.Time) string {
+	d := time.Since(t)
+	if d < time.Minute {
+		return "just now"
+	}
+
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	for _, u := range units {
+		if n := int(d / u.size); n >= 1 {
+			if n == 1 {
+				return fmt.Sprintf("1 %s ago", u.name)
+			}
+			return fmt.Sprintf("%d %ss ago", n, u.name)
+		}
+	}
+	return "just now"
+}
+
+// dict builds a map from key value pairs, so a template can pass several values to another template
+func dict(values ...interface{}) (map[string]interface{}, error) {
+	if len(values)%2 != 0 {
+		return nil, errors.New("dict needs key value pairs")
+	}
+	m := make(map[string]interface{}, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		key, ok := values[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", values[i])
+		}
+		m[key] = values[i+1]
+	}
+	return m, nil
+}
+
+// highlight escapes a search headline and marks the words it matched
+func highlight(headline string) template.HTML {
+	s := template.HTMLEscapeString(headline)
+	s = strings.ReplaceAll(s, store.HighlightStart, "<mark>")
+	s = strings.ReplaceAll(s, store.HighlightStop, "</mark>")
+	return template.HTML(s)
+}
+
+// the time windows of the top and controversial listings in the order they are shown
+var sortWindowNames = []string{"hour", "day", "week", "month", "year", "all"}
+
+var sortWindows = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// SortData tells the listing templates which sort tab and time window are selected
+type SortData struct {
+	Sort    store.PostOrder
+	Window  string
+	Windows []string
+}
+
+// parsePostSort reads the sort and t query parameters of a listing, invalid values fall back to hot and the last day
+func parsePostSort(q url.Values) (store.PostSort, SortData) {
+	order := store.PostOrder(q.Get("sort"))
+	switch order {
+	case store.OrderNew, store.OrderTop, store.OrderControversial:
+	default:
+		order = store.OrderHot
+	}
+	sort := store.PostSort{Order: order}
+	data := SortData{Sort: order}
+
+	// only top and controversial are limited to a time window
+	if order == store.OrderTop || order == store.OrderControversial {
+		window := q.Get("t")
+		d, ok := sortWindows[window]
+		if !ok {
+			window, d = "day", sortWindows["day"]
+		}
+		if d > 0 {
+			sort.Since = time.Now().Add(-d)
+		}
+		data.Window = window
+		data.Windows = sortWindowNames
+	}
+	return sort, data
+}
+
+// PageData holds the links to the pages around the one shown by a listing, they are empty when there is no such page
+type PageData struct {
+	PrevURL string
+	NextURL string
+}
+
+// parsePage reads the cursor query parameter of a listing
+func parsePage(r *http.Request) store.Page {
+	return store.Page{Size: store.DefaultPageSize, Cursor: r.URL.Query().Get("cursor")}
+}
+
+// newPageData builds the links to the pages around the current one keeping the other query parameters
+func newPageData(r *http.Request, cc store.Cursors) PageData {
+	link := func(cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		q := r.URL.Query()
+		q.Set("cursor", cursor)
+		return "?" + q.Encode()
+	}
+	return PageData{PrevURL: link(cc.Prev), NextURL: link(cc.Next)}
+}
+
+// listingError responds to a failed listing, a tampered or stale cursor is a client error
+func listingError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
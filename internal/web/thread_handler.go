@@ -1,14 +1,18 @@
 package web
 
 import (
+	"database/sql"
+	"errors"
 	"html/template"
 	"net/http"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/policy"
 )
 
 type ThreadHandler struct {
@@ -20,18 +24,20 @@ func (h *ThreadHandler) listView() http.HandlerFunc {
 	// wrap some local data that wont be visible from outside
 	type data struct {
 		SessionData
+		PageData
 		Threads []store.Thread
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/threads.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/threads.html")
 	return func(w http.ResponseWriter, r *http.Request) {
-		threads, err := h.store.Threads()
+		threads, cc, err := h.store.ThreadsPage(r.Context(), parsePage(r))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			listingError(w, err)
 			return
 		}
 		tmpl.Execute(w, data{
 			SessionData: GetSessionData(h.sessions, r.Context()),
+			PageData:    newPageData(r, cc),
 			Threads:     threads,
 		})
 	}
@@ -42,8 +48,11 @@ func (h *ThreadHandler) createView() http.HandlerFunc {
 		SessionData
 		CSRF template.HTML // string which is not escaped
 	}
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/thread_create.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/thread_create.html")
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, h.sessions, policy.Create, policy.Site()) {
+			return
+		}
 		tmpl.Execute(w, data{
 			SessionData: GetSessionData(h.sessions, r.Context()),
 			CSRF:        csrf.TemplateField(r),
@@ -54,12 +63,19 @@ func (h *ThreadHandler) createView() http.HandlerFunc {
 func (h *ThreadHandler) view() http.HandlerFunc {
 	type data struct {
 		SessionData
-		Thread store.Thread
-		Posts  []store.Post
-		CSRF   template.HTML // string which is not escaped
+		SortData
+		PageData
+		Thread     store.Thread
+		Pinned     []store.Post
+		Posts      []store.Post
+		PostVotes  map[uuid.UUID]int
+		Moderators []store.User
+		// Appoint is the form of the admins to appoint a moderator, sent back after a validation error
+		Appoint ModeratorForm
+		CSRF    template.HTML // string which is not escaped
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/thread.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/thread.html")
 	return func(w http.ResponseWriter, r *http.Request) {
 		//parse the id
 		idStr := chi.URLParam(r, "id")
@@ -69,20 +85,70 @@ func (h *ThreadHandler) view() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		t, err := h.store.Thread(id)
+		t, err := h.store.Thread(r.Context(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the threads in the trash are gone for visitors
+		if t.DeletedAt.Valid {
+			http.NotFound(w, r)
+			return
+		}
+		// answer 304 when nothing changed since the client got the page, see homeView for the time windows
+		sort, sortData := parsePostSort(r.URL.Query())
+		var modified time.Time
+		if sort.Since.IsZero() {
+			if modified, err = h.store.ThreadModified(r.Context(), t.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if notModified(w, r, h.sessions, modified, true) {
+			return
+		}
+		page := parsePage(r)
+		pp, cc, err := h.store.PostsByThreadPage(r.Context(), t.ID, sort, page)
+		if err != nil {
+			listingError(w, err)
+			return
+		}
+		// the pinned posts head the first page and are left out of the listing
+		var pinned []store.Post
+		if page.Cursor == "" {
+			if pinned, err = h.store.PinnedPosts(r.Context(), t.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		listed := pp[:0]
+		for _, p := range pp {
+			if !p.Pinned {
+				listed = append(listed, p)
+			}
+		}
+		votes, err := postVotes(h.store, r.Context(), append(pinned, listed...))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		pp, err := h.store.PostsByThread(t.ID)
+		mods, err := h.store.Moderators(r.Context(), t.ID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		sd := GetSessionData(h.sessions, r.Context())
+		appoint, _ := sd.Form.(ModeratorForm)
 		tmpl.Execute(w, data{
-			SessionData: GetSessionData(h.sessions, r.Context()),
+			SessionData: sd,
+			SortData:    sortData,
+			PageData:    newPageData(r, cc),
 			Thread:      t,
-			Posts:       pp,
+			Pinned:      pinned,
+			Posts:       listed,
+			PostVotes:   votes,
+			Moderators:  mods,
+			Appoint:     appoint,
 			CSRF:        csrf.TemplateField(r),
 		})
 	}
@@ -90,6 +156,10 @@ func (h *ThreadHandler) view() http.HandlerFunc {
 
 func (h *ThreadHandler) save() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorize(w, r, h.sessions, policy.Create, policy.Site()) {
+			return
+		}
+
 		//parse the form
 		form := CreateThreadForm{
 			Title:       r.FormValue("title"),
@@ -103,15 +173,19 @@ func (h *ThreadHandler) save() http.HandlerFunc {
 			return
 		}
 
-		//send new thread to db
-		if err := h.store.CreateThread(&store.Thread{
+		//send new thread to db, the author is the logged in user
+		user, _ := UserFromContext(r.Context())
+		t := &store.Thread{
 			ID:          uuid.New(),
+			AuthorID:    uuid.NullUUID{UUID: user.ID, Valid: true},
 			Title:       form.Title,
 			Description: form.Description,
-		}); err != nil {
+		}
+		if err := h.store.CreateThread(r.Context(), t); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookThreadCreated, t.ID)
 
 		// add flash message
 		h.sessions.Put(r.Context(), "flash", "Your new thread has been created.")
@@ -130,16 +204,283 @@ func (h *ThreadHandler) delete() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		//delete thread from db
-		if err := h.store.DeleteThread(id); err != nil {
+		t, err := h.store.Thread(r.Context(), id)
+		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		if !authorize(w, r, h.sessions, policy.Delete, policy.Thread(t)) {
+			return
+		}
+
+		//move thread to the trash
+		user, _ := UserFromContext(r.Context())
+		moderated, err := remove(r.Context(), h.store, user, t, func(s store.Store) error {
+			return s.DeleteThread(r.Context(), t.ID)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookThreadDeleted, t.ID)
+
 		// add flash message
-		h.sessions.Put(r.Context(), "flash", "Your thread has been deleted.")
+		h.sessions.Put(r.Context(), "flash", removedMessage(store.ContentThread, moderated))
 
 		// redirect to the thread list
 		http.Redirect(w, r, "/threads", http.StatusFound)
 	}
 }
+
+func (h *ThreadHandler) editView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Thread store.Thread
+		CSRF   template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/thread_edit.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Edit, policy.Thread(t)) {
+			return
+		}
+		sd := GetSessionData(h.sessions, r.Context())
+		// the form starts with the current values, unless it is sent back after a validation error
+		if _, ok := sd.Form.(CreateThreadForm); !ok {
+			sd.Form = CreateThreadForm{Title: t.Title, Description: t.Description}
+		}
+		tmpl.Execute(w, data{
+			SessionData: sd,
+			Thread:      t,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *ThreadHandler) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Edit, policy.Thread(t)) {
+			return
+		}
+
+		form := CreateThreadForm{
+			Title:       r.FormValue("title"),
+			Description: r.FormValue("description"),
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		t.Title, t.Description = form.Title, form.Description
+		if err := h.store.UpdateThread(r.Context(), &t); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		notifyWebhooks(r.Context(), h.store, store.WebhookThreadUpdated, t.ID)
+
+		h.sessions.Put(r.Context(), "flash", "Your thread has been saved.")
+		http.Redirect(w, r, "/threads/"+t.ID.String(), http.StatusFound)
+	}
+}
+
+// lock locks or unlocks the thread against new posts
+func (h *ThreadHandler) lock(locked bool) http.HandlerFunc {
+	action, message := store.ModLockThread, "The thread has been locked."
+	if !locked {
+		action, message = store.ModUnlockThread, "The thread has been unlocked."
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Lock, policy.Thread(t)) {
+			return
+		}
+
+		// there is nothing to log when the thread is already as asked
+		if t.Locked != locked {
+			user, _ := UserFromContext(r.Context())
+			if err := moderate(r.Context(), h.store, modAction(user, action, t), func(s store.Store) error {
+				return s.LockThread(r.Context(), t.ID, locked)
+			}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		h.sessions.Put(r.Context(), "flash", message)
+		http.Redirect(w, r, "/threads/"+t.ID.String(), http.StatusFound)
+	}
+}
+
+func (h *ThreadHandler) addModerator() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Appoint, policy.Thread(t)) {
+			return
+		}
+
+		form := ModeratorForm{
+			Username: r.FormValue("username"),
+		}
+		var u store.User
+		if form.Username != "" {
+			var err error
+			u, err = h.store.UserByUsername(r.Context(), form.Username)
+			if errors.Is(err, sql.ErrNoRows) {
+				form.UnknownUser = true
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		mods, err := h.store.Moderators(r.Context(), t.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if moderatorIn(mods, u.ID) {
+			h.sessions.Put(r.Context(), "flash", u.Username+" moderates this thread already.")
+			http.Redirect(w, r, "/threads/"+t.ID.String(), http.StatusFound)
+			return
+		}
+
+		user, _ := UserFromContext(r.Context())
+		a := modAction(user, store.ModAddModerator, t)
+		a.TargetID, a.Target = u.ID, u.Username
+		if err := moderate(r.Context(), h.store, a, func(s store.Store) error {
+			return s.AddModerator(r.Context(), t.ID, u.ID)
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", u.Username+" is now a moderator of this thread.")
+		http.Redirect(w, r, "/threads/"+t.ID.String(), http.StatusFound)
+	}
+}
+
+func (h *ThreadHandler) removeModerator() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		if !authorize(w, r, h.sessions, policy.Appoint, policy.Thread(t)) {
+			return
+		}
+		userID, err := uuid.Parse(chi.URLParam(r, "userId"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mods, err := h.store.Moderators(r.Context(), t.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var u store.User
+		for _, m := range mods {
+			if m.ID == userID {
+				u = m
+			}
+		}
+		if u.ID == uuid.Nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		user, _ := UserFromContext(r.Context())
+		a := modAction(user, store.ModRemoveModerator, t)
+		a.TargetID, a.Target = u.ID, u.Username
+		if err := moderate(r.Context(), h.store, a, func(s store.Store) error {
+			return s.RemoveModerator(r.Context(), t.ID, u.ID)
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", u.Username+" no longer moderates this thread.")
+		http.Redirect(w, r, "/threads/"+t.ID.String(), http.StatusFound)
+	}
+}
+
+// modLogView shows the public log of what the moderators did in the thread
+func (h *ThreadHandler) modLogView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		PageData
+		Thread  store.Thread
+		Actions []store.ModAction
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/modlog.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := h.thread(w, r)
+		if !ok {
+			return
+		}
+		aa, cc, err := h.store.ModLog(r.Context(), t.ID, parsePage(r))
+		if err != nil {
+			listingError(w, err)
+			return
+		}
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			PageData:    newPageData(r, cc),
+			Thread:      t,
+			Actions:     aa,
+		})
+	}
+}
+
+// moderatorIn reports whether the user is among the moderators
+func moderatorIn(mods []store.User, id uuid.UUID) bool {
+	for _, m := range mods {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// thread retrieves the thread in the url, the threads in the trash are not found
+func (h *ThreadHandler) thread(w http.ResponseWriter, r *http.Request) (store.Thread, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return store.Thread{}, false
+	}
+	t, err := h.store.Thread(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && t.DeletedAt.Valid {
+		http.NotFound(w, r)
+		return store.Thread{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return store.Thread{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,142 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// tokenPrefix starts every token so they are easy to recognize, e.g. when one leaks into a repository
+const tokenPrefix = "gr_"
+
+// newToken generates a random token starting with the prefix together with the hash to store
+func newToken(prefix string) (string, []byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := prefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken hashes a token with sha256, the tokens are long and random so they need no slow hash like the passwords
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+type TokenHandler struct {
+	store    store.Store
+	sessions *scs.SessionManager
+}
+
+func (h *TokenHandler) listView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Tokens []store.Token
+		Scopes []store.TokenScope
+		// NewToken is the token just created, it is shown only once
+		NewToken string
+		CSRF     template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/tokens.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		tt, err := h.store.TokensByUser(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		scopes := store.TokenScopes
+		if !user.Admin() {
+			scopes = []store.TokenScope{store.ScopeRead, store.ScopeWrite}
+		}
+
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			Tokens:      tt,
+			Scopes:      scopes,
+			NewToken:    h.sessions.PopString(r.Context(), "new_token"),
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *TokenHandler) create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		form := TokenForm{
+			Name:     r.FormValue("name"),
+			Scope:    r.FormValue("scope"),
+			CanAdmin: user.Admin(),
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		token, hash, err := newToken(tokenPrefix)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := h.store.CreateToken(r.Context(), &store.Token{
+			ID:     uuid.New(),
+			UserID: user.ID,
+			Name:   form.Name,
+			Scope:  store.TokenScope(form.Scope),
+			Prefix: token[:len(tokenPrefix)+6],
+			Hash:   hash,
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "new_token", token)
+		h.sessions.Put(r.Context(), "flash", "Your token has been created. Copy it now, it will not be shown again.")
+		http.Redirect(w, r, "/settings/tokens", http.StatusFound)
+	}
+}
+
+func (h *TokenHandler) revoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := uuid.Parse(chi.URLParam(r, "id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// users only see their own tokens
+		user, _ := UserFromContext(r.Context())
+		t, err := h.store.Token(r.Context(), id)
+		if errors.Is(err, sql.ErrNoRows) || err == nil && t.UserID != user.ID {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.store.DeleteToken(r.Context(), t.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", "The token "+t.Name+" has been revoked.")
+		http.Redirect(w, r, "/settings/tokens", http.StatusFound)
+	}
+}
@@ -0,0 +1,192 @@
+package web
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+	"rsc.io/qr"
+)
+
+const (
+	// totpIssuer names the site in the authenticator apps
+	totpIssuer = "goreddit"
+	// recoveryCodes is how many recovery codes a user gets when enabling the second factor
+	recoveryCodes = 10
+)
+
+// newRecoveryCode generates a recovery code like abcd-efgh-ijkl-mnop, 80 random bits which need no slow hash either
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// hashRecoveryCode hashes the code as the user typed it, whatever the case and the dashes
+func hashRecoveryCode(code string) []byte {
+	code = strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	return hashToken(code)
+}
+
+// qrDataURL renders the text as a QR code in a png embedded in a data url, the secret never leaves the server
+func qrDataURL(text string) (template.URL, error) {
+	c, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(c.PNG())), nil
+}
+
+type TwoFactorHandler struct {
+	store    store.Store
+	sessions *scs.SessionManager
+}
+
+// view shows the state of the second factor of the user. Without one it offers to enroll with a new secret, kept in
+// the session until the user confirms it with a code. The users without a password, who signed up with an identity
+// provider, set one first: it is what turns the second factor off again.
+func (h *TwoFactorHandler) view() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Enabled bool
+		// NoPassword is set for the users who log in with their identity providers only
+		NoPassword bool
+		// CodesLeft is how many recovery codes the user has not used
+		CodesLeft int
+		// NewCodes are the recovery codes just generated, they are shown only once
+		NewCodes []string
+		// Secret and QR enroll the authenticator app
+		Secret string
+		QR     template.URL
+		CSRF   template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/two_factor.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		d := data{NoPassword: user.Password == "", CSRF: csrf.TemplateField(r)}
+
+		_, err := h.store.TOTP(r.Context(), user.ID)
+		switch {
+		case err == nil:
+			d.Enabled = true
+			if d.CodesLeft, err = h.store.RecoveryCodesLeft(r.Context(), user.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			d.NewCodes, _ = h.sessions.Pop(r.Context(), "recovery_codes").([]string)
+		case errors.Is(err, sql.ErrNoRows) && d.NoPassword:
+		case errors.Is(err, sql.ErrNoRows):
+			d.Secret = h.sessions.GetString(r.Context(), "totp_secret")
+			if d.Secret == "" {
+				if d.Secret, err = totp.NewSecret(); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				h.sessions.Put(r.Context(), "totp_secret", d.Secret)
+			}
+			if d.QR, err = qrDataURL(totp.URI(totpIssuer, user.Username, d.Secret)); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		d.SessionData = GetSessionData(h.sessions, r.Context())
+		tmpl.Execute(w, d)
+	}
+}
+
+// enable turns the second factor on once the user proved the app has the secret by entering one of its codes
+func (h *TwoFactorHandler) enable() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		if user.Password == "" {
+			h.sessions.Put(r.Context(), "flash", "Please set a password before turning on two-factor authentication.")
+			http.Redirect(w, r, "/settings/2fa", http.StatusFound)
+			return
+		}
+		secret := h.sessions.GetString(r.Context(), "totp_secret")
+		if secret == "" {
+			http.Redirect(w, r, "/settings/2fa", http.StatusFound)
+			return
+		}
+
+		form := TwoFactorForm{
+			Code:          r.FormValue("code"),
+			IncorrectCode: false,
+		}
+		step, ok := totp.Validate(secret, form.Code, time.Now())
+		form.IncorrectCode = !ok
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		codes := make([]string, recoveryCodes)
+		stored := make([]store.RecoveryCode, recoveryCodes)
+		for i := range codes {
+			code, err := newRecoveryCode()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			codes[i] = code
+			stored[i] = store.RecoveryCode{ID: uuid.New(), UserID: user.ID, Hash: hashRecoveryCode(code)}
+		}
+		// the code just entered counts as used, it cannot log in
+		if err := h.store.EnableTOTP(r.Context(), &store.TOTP{UserID: user.ID, Secret: secret, LastStep: step}, stored); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Remove(r.Context(), "totp_secret")
+		h.sessions.Put(r.Context(), "recovery_codes", codes)
+		h.sessions.Put(r.Context(), "flash", "Two-factor authentication is on. Save your recovery codes now, they will not be shown again.")
+		http.Redirect(w, r, "/settings/2fa", http.StatusFound)
+	}
+}
+
+// disable turns the second factor off, the password proves it is the user and not somebody who found the session open
+func (h *TwoFactorHandler) disable() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		form := DisableTwoFactorForm{
+			Password:          r.FormValue("password"),
+			IncorrectPassword: false,
+		}
+		form.IncorrectPassword = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(form.Password)) != nil
+		if !form.Validate() {
+			form.Password = ""
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		if err := h.store.DisableTOTP(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", "Two-factor authentication is off.")
+		http.Redirect(w, r, "/settings/2fa", http.StatusFound)
+	}
+}
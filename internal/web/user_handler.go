@@ -1,45 +1,127 @@
 package web
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/csrf"
 	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/mail"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/oidc"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/totp"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// passwordResetTTL is how long the link to reset a password works, the mail below tells it too
+	passwordResetTTL = time.Hour
+	// emailVerificationTTL is how long the link to verify an address works, the mail below tells it too
+	emailVerificationTTL = 24 * time.Hour
+	// emailVerificationInterval is how long a user waits before asking for another link to verify the address,
+	// so nobody gets the site to flood a mailbox
+	emailVerificationInterval = 5 * time.Minute
+	// twoFactorPendingTTL is how long the code of the second factor is awaited after the password
+	twoFactorPendingTTL = 5 * time.Minute
+	// maxTwoFactorAttempts is how many incorrect codes send the user back to the password
+	maxTwoFactorAttempts = 5
+)
+
+// the mail with the link to reset a password, filled with the username and the link
+const passwordResetMail = `Hello %s,
+
+somebody, hopefully you, asked to reset the password of your goreddit account.
+Open this link within an hour to choose a new one:
+
+%s
+
+If it was not you, ignore this message and your password stays the same.
+`
+
+// the mail with the link to verify an address, filled with the username and the link
+const emailVerificationMail = `Hello %s,
+
+please open this link within a day to verify the email address of your goreddit account:
+
+%s
+
+If you have no goreddit account, somebody entered your address by mistake and you can ignore this message.
+`
+
 type UserHandler struct {
 	store    store.Store
 	sessions *scs.SessionManager
+	mailer   mail.Mailer
+	// baseURL starts the links sent by mail, the host of the request is used when it is empty
+	baseURL string
+	// providers are offered on the login and register pages
+	providers []*oidc.Provider
+}
+
+// link makes the path absolute for the links sent by mail
+func (h *UserHandler) link(r *http.Request, path string) string {
+	return siteURL(h.baseURL, r, path)
+}
+
+// siteURL makes the path absolute with the base url, or with the host of the request when there is none
+func siteURL(baseURL string, r *http.Request, path string) string {
+	if baseURL != "" {
+		return baseURL + path
+	}
+	return absoluteURL(r, path)
 }
 
 func (h *UserHandler) RegisterView() http.HandlerFunc {
 	type data struct {
 		SessionData
-		CSRF template.HTML // string which is not escaped
+		Providers []*oidc.Provider
+		CSRF      template.HTML // string which is not escaped
 	}
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/user_register.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/user_register.html")
 	return func(w http.ResponseWriter, r *http.Request) {
 		tmpl.Execute(w, data{
 			SessionData: GetSessionData(h.sessions, r.Context()),
+			Providers:   h.providers,
 			CSRF:        csrf.TemplateField(r),
 		})
 	}
 }
 
+// Register creates a user, as long as the address did not register too many already
 func (h *UserHandler) Register() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		form := RegisterForm{
 			Username:      r.FormValue("username"),
 			Password:      r.FormValue("password"),
+			Email:         r.FormValue("email"),
 			UsernameTaken: false,
+			EmailTaken:    false,
+		}
+		wait, err := registrationWait(r, h.store)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		if _, err := h.store.UserByUsername(form.Username); err == nil {
+		if wait > 0 {
+			h.sessions.Put(r.Context(), "form", RegisterForm{Username: form.Username, Email: form.Email})
+			h.sessions.Put(r.Context(), "flash", lockoutMessage("registrations from your address", wait))
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+		if _, err := h.store.UserByUsername(r.Context(), form.Username); err == nil {
 			form.UsernameTaken = true
 		}
+		if _, err := h.store.UserByEmail(r.Context(), form.Email); err == nil {
+			form.EmailTaken = true
+		}
 		if !form.Validate() {
 			h.sessions.Put(r.Context(), "form", form)
 			http.Redirect(w, r, r.Referer(), http.StatusFound)
@@ -53,16 +135,22 @@ func (h *UserHandler) Register() http.HandlerFunc {
 			return
 		}
 
-		if err := h.store.CreateUser(&store.User{
+		user := store.User{
 			ID:       uuid.New(),
 			Username: form.Username,
 			Password: string(password),
-		}); err != nil {
+			Email:    form.Email,
+		}
+		if err := h.store.CreateUser(r.Context(), &user); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if err := h.sendVerification(r, user); err != nil {
+			// the account is there, the user asks for another link from the email page
+			log.Printf("error sending the email verification of %s: %v", user.ID, err)
+		}
 
-		h.sessions.Put(r.Context(), "flash", "Your registration was successful. Please log in.")
+		h.sessions.Put(r.Context(), "flash", "Your registration was successful. We mailed you a link to verify your email address. Please log in.")
 		http.Redirect(w, r, "/", http.StatusFound)
 	}
 }
@@ -70,18 +158,22 @@ func (h *UserHandler) Register() http.HandlerFunc {
 func (h *UserHandler) LoginView() http.HandlerFunc {
 	type data struct {
 		SessionData
-		CSRF template.HTML
+		Providers []*oidc.Provider
+		CSRF      template.HTML
 	}
 
-	tmpl := template.Must(template.ParseFiles("templates/layout.html", "templates/user_login.html"))
+	tmpl := parseTemplates("templates/layout.html", "templates/user_login.html")
 	return func(w http.ResponseWriter, r *http.Request) {
 		tmpl.Execute(w, data{
 			SessionData: GetSessionData(h.sessions, r.Context()),
+			Providers:   h.providers,
 			CSRF:        csrf.TemplateField(r),
 		})
 	}
 }
 
+// Login checks the password unless the username or the address is locked out, so the guesses of an attacker stop
+// counting once they are too many
 func (h *UserHandler) Login() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		form := LoginForm{
@@ -89,7 +181,19 @@ func (h *UserHandler) Login() http.HandlerFunc {
 			Password:             r.FormValue("password"),
 			IncorrectCredentials: false,
 		}
-		user, err := h.store.UserByUsername(form.Username)
+		wait, err := lockedOut(r.Context(), h.store, loginIPLimit.key(clientIP(r)), loginUserLimit.key(form.Username))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			h.sessions.Put(r.Context(), "form", LoginForm{Username: form.Username})
+			h.sessions.Put(r.Context(), "flash", lockoutMessage("failed logins", wait))
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		user, err := h.store.UserByUsername(r.Context(), form.Username)
 		if err != nil {
 			form.IncorrectCredentials = true
 		} else {
@@ -97,17 +201,250 @@ func (h *UserHandler) Login() http.HandlerFunc {
 			form.IncorrectCredentials = compareErr != nil
 		}
 		if !form.Validate() {
+			if form.IncorrectCredentials && form.Username != "" {
+				wait, err := h.countFailedLogin(r, form.Username)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if wait > 0 {
+					h.sessions.Put(r.Context(), "flash", lockoutMessage("failed logins", wait))
+				}
+			}
 			h.sessions.Put(r.Context(), "form", form)
 			http.Redirect(w, r, r.Referer(), http.StatusFound)
 			return
 		}
 
-		h.sessions.Put(r.Context(), "user_id", user.ID)
-		h.sessions.Put(r.Context(), "flash", "You have been logged in successfully.")
+		// the attempts of the address stay, an attacker with an account of their own does not get to reset them
+		if err := h.store.ClearLockout(r.Context(), loginUserLimit.key(user.Username)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		startSession(w, r, h.store, h.sessions, user.ID)
+	}
+}
+
+// countFailedLogin counts a wrong password or code for the username and the address it came from. It returns how long
+// the lockout it triggered lasts, 0 when there is none.
+func (h *UserHandler) countFailedLogin(r *http.Request, username string) (time.Duration, error) {
+	byUser, err := countAttempt(r, h.store, loginUserLimit, username)
+	if err != nil {
+		return 0, err
+	}
+	byIP, err := countAttempt(r, h.store, loginIPLimit, clientIP(r))
+	if err != nil {
+		return 0, err
+	}
+	if byIP > byUser {
+		return byIP, nil
+	}
+	return byUser, nil
+}
+
+// startSession logs the user in once the first factor, the password or an identity provider, vouched for the user.
+// With a second factor the session only gets half way, to the page asking for a code.
+func startSession(w http.ResponseWriter, r *http.Request, s store.Store, sessions *scs.SessionManager, userID uuid.UUID) {
+	user, err := s.User(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, err = s.TOTP(r.Context(), userID)
+	if err == nil {
+		if err := sessions.RenewToken(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sessions.Put(r.Context(), "pending_user_id", userID)
+		sessions.Put(r.Context(), "pending_at", time.Now().Unix())
+		sessions.Put(r.Context(), "pending_generation", user.SessionGeneration)
+		sessions.Remove(r.Context(), "pending_attempts")
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := logIn(r.Context(), sessions, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sessions.Put(r.Context(), "flash", "You have been logged in successfully.")
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// logIn makes the session the one of the user. The session gets a new token, so a token somebody else planted in the
+// browser before the login is worth nothing, and the generation of the user, so revoking the sessions ends it.
+func logIn(ctx context.Context, sessions *scs.SessionManager, user store.User) error {
+	if err := sessions.RenewToken(ctx); err != nil {
+		return err
+	}
+	sessions.Remove(ctx, "pending_user_id")
+	sessions.Remove(ctx, "pending_at")
+	sessions.Remove(ctx, "pending_attempts")
+	sessions.Remove(ctx, "pending_generation")
+	sessions.Put(ctx, "user_id", user.ID)
+	sessions.Put(ctx, "session_generation", user.SessionGeneration)
+	return nil
+}
+
+// pendingUser returns the user who entered the right password but no code yet, for as long as the code is awaited
+func (h *UserHandler) pendingUser(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := h.sessions.Get(ctx, "pending_user_id").(uuid.UUID)
+	at := time.Unix(h.sessions.GetInt64(ctx, "pending_at"), 0)
+	if !ok || time.Since(at) > twoFactorPendingTTL {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// loginAgain sends the visitor whose pending login is gone back to the login page
+func (h *UserHandler) loginAgain(w http.ResponseWriter, r *http.Request, message string) {
+	h.sessions.Remove(r.Context(), "pending_user_id")
+	h.sessions.Remove(r.Context(), "pending_at")
+	h.sessions.Remove(r.Context(), "pending_attempts")
+	h.sessions.Remove(r.Context(), "pending_generation")
+	h.sessions.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+func (h *UserHandler) LoginTwoFactorView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		CSRF template.HTML
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/user_login_2fa.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := h.pendingUser(r.Context()); !ok {
+			h.loginAgain(w, r, "Please log in again.")
+			return
+		}
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+// LoginTwoFactor completes the login with a code of the app or a recovery code
+func (h *UserHandler) LoginTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := h.pendingUser(r.Context())
+		if !ok {
+			h.loginAgain(w, r, "Please log in again.")
+			return
+		}
+
+		// the codes are guessed like the passwords, their failures count for the username too
+		user, err := h.store.User(r.Context(), userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the password was reset since it was entered
+		if user.SessionGeneration != h.sessions.GetInt(r.Context(), "pending_generation") {
+			h.loginAgain(w, r, "Please log in again.")
+			return
+		}
+		wait, err := lockedOut(r.Context(), h.store, loginIPLimit.key(clientIP(r)), loginUserLimit.key(user.Username))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			h.loginAgain(w, r, lockoutMessage("failed logins", wait))
+			return
+		}
+
+		form := TwoFactorForm{
+			Code:          r.FormValue("code"),
+			IncorrectCode: false,
+		}
+		factor, err := h.checkSecondFactor(r.Context(), userID, form.Code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		form.IncorrectCode = !factor.ok
+		if !form.Validate() {
+			if form.IncorrectCode && form.Code != "" {
+				wait, err := h.countFailedLogin(r, user.Username)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if wait > 0 {
+					h.loginAgain(w, r, lockoutMessage("failed logins", wait))
+					return
+				}
+			}
+			attempts := h.sessions.GetInt(r.Context(), "pending_attempts") + 1
+			if attempts >= maxTwoFactorAttempts {
+				h.loginAgain(w, r, "Too many incorrect codes. Please log in again.")
+				return
+			}
+			h.sessions.Put(r.Context(), "pending_attempts", attempts)
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, "/login/2fa", http.StatusFound)
+			return
+		}
+
+		if err := logIn(r.Context(), h.sessions, user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		flash := "You have been logged in successfully."
+		if factor.withRecoveryCode {
+			left, err := h.store.RecoveryCodesLeft(r.Context(), userID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			flash = fmt.Sprintf("You have been logged in with a recovery code, %d left.", left)
+		}
+		h.sessions.Put(r.Context(), "flash", flash)
 		http.Redirect(w, r, "/", http.StatusFound)
 	}
 }
 
+// secondFactor is the outcome of checking a code
+type secondFactor struct {
+	ok               bool
+	withRecoveryCode bool
+}
+
+// checkSecondFactor accepts a code of the app of the user or one of the recovery codes, every code works once
+func (h *UserHandler) checkSecondFactor(ctx context.Context, userID uuid.UUID, code string) (secondFactor, error) {
+	if code == "" {
+		return secondFactor{}, nil
+	}
+	t, err := h.store.TOTP(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the second factor was turned off in the meantime
+		return secondFactor{ok: true}, nil
+	}
+	if err != nil {
+		return secondFactor{}, err
+	}
+
+	if step, ok := totp.Validate(t.Secret, code, time.Now()); ok {
+		err := h.store.UseTOTPStep(ctx, userID, step)
+		if errors.Is(err, sql.ErrNoRows) {
+			return secondFactor{}, nil
+		}
+		return secondFactor{ok: err == nil}, err
+	}
+	err = h.store.UseRecoveryCode(ctx, userID, hashRecoveryCode(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return secondFactor{}, nil
+	}
+	return secondFactor{ok: err == nil, withRecoveryCode: true}, err
+}
+
 func (h *UserHandler) Logout() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		h.sessions.Remove(r.Context(), "user_id")
@@ -115,3 +452,370 @@ func (h *UserHandler) Logout() http.HandlerFunc {
 		http.Redirect(w, r, "/", http.StatusFound)
 	}
 }
+
+func (h *UserHandler) ForgotPasswordView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		CSRF template.HTML
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/user_forgot.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+// ForgotPassword mails a link to reset the password to the address of the account. The answer is the same whether
+// the account exists, has an address or not, so the page tells nobody who is registered.
+func (h *UserHandler) ForgotPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form := ForgotPasswordForm{
+			Username: r.FormValue("username"),
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		// every request mails a link and stores a reset, nobody floods an address with them
+		wait, err := passwordResetWait(r, h.store, form.Username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			h.sessions.Put(r.Context(), "flash", lockoutMessage("requests to reset a password", wait))
+			http.Redirect(w, r, "/password/forgot", http.StatusFound)
+			return
+		}
+
+		user, err := h.store.UserByUsername(r.Context(), form.Username)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == nil && user.Email != "" {
+			if err := h.sendPasswordReset(r, user); err != nil {
+				// the link is lost, telling the visitor would tell the account has an address
+				log.Printf("error sending the password reset of %s: %v", user.ID, err)
+			}
+		}
+
+		h.sessions.Put(r.Context(), "flash", "If the account has an email address, a link to reset its password is on its way.")
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}
+}
+
+// sendPasswordReset stores a new reset for the user and mails its link
+func (h *UserHandler) sendPasswordReset(r *http.Request, user store.User) error {
+	token, hash, err := newToken("")
+	if err != nil {
+		return err
+	}
+	if err := h.store.CreatePasswordReset(r.Context(), &store.PasswordReset{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	}); err != nil {
+		return err
+	}
+
+	return h.mailer.Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Reset your goreddit password",
+		Body:    fmt.Sprintf(passwordResetMail, user.Username, h.link(r, "/password/reset/"+token)),
+	})
+}
+
+// passwordReset gets the reset of the token in the url, ok is false when there is none or it cannot be used anymore
+func (h *UserHandler) passwordReset(r *http.Request) (reset store.PasswordReset, ok bool, err error) {
+	reset, err = h.store.PasswordResetByHash(r.Context(), hashToken(chi.URLParam(r, "token")))
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.PasswordReset{}, false, nil
+	}
+	if err != nil {
+		return store.PasswordReset{}, false, err
+	}
+	return reset, reset.Usable(time.Now()), nil
+}
+
+// resetUnusable sends the visitor with a used, expired or made up link to ask for a new one
+func (h *UserHandler) resetUnusable(w http.ResponseWriter, r *http.Request) {
+	h.sessions.Put(r.Context(), "flash", "This link to reset your password is invalid or has expired. Please ask for a new one.")
+	http.Redirect(w, r, "/password/forgot", http.StatusFound)
+}
+
+func (h *UserHandler) ResetPasswordView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Token string
+		CSRF  template.HTML
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/user_reset.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the token is in the url, it must not leak to the sites the page loads its styles and scripts from
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		_, ok, err := h.passwordReset(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			h.resetUnusable(w, r)
+			return
+		}
+
+		tmpl.Execute(w, data{
+			SessionData: GetSessionData(h.sessions, r.Context()),
+			Token:       chi.URLParam(r, "token"),
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *UserHandler) ResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		reset, ok, err := h.passwordReset(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			h.resetUnusable(w, r)
+			return
+		}
+
+		form := ResetPasswordForm{
+			Password: r.FormValue("password"),
+			Confirm:  r.FormValue("confirm"),
+		}
+		if !form.Validate() {
+			// the page sends no referer, back to it by its own path
+			form.Password, form.Confirm = "", ""
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.URL.Path, http.StatusFound)
+			return
+		}
+
+		password, err := bcrypt.GenerateFromPassword([]byte(form.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// using the reset and changing the password go together, a link clicked twice at once changes it once
+		err = h.store.WithTx(r.Context(), func(tx store.Store) error {
+			if err := tx.UsePasswordReset(r.Context(), reset.ID); err != nil {
+				return err
+			}
+			user, err := tx.User(r.Context(), reset.UserID)
+			if err != nil {
+				return err
+			}
+			user.Password = string(password)
+			if err := tx.UpdateUser(r.Context(), &user); err != nil {
+				return err
+			}
+			// whoever got in with the old password is out, through a session or a token
+			if err := tx.RevokeSessions(r.Context(), user.ID); err != nil {
+				return err
+			}
+			tt, err := tx.TokensByUser(r.Context(), user.ID)
+			if err != nil {
+				return err
+			}
+			for _, t := range tt {
+				if err := tx.DeleteToken(r.Context(), t.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			h.resetUnusable(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// whoever was logged in on this browser starts over with the new password
+		if err := h.sessions.RenewToken(r.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.sessions.Remove(r.Context(), "user_id")
+		h.sessions.Put(r.Context(), "flash", "Your password has been changed, you have been logged out everywhere and your personal access tokens have been revoked. Please log in.")
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}
+}
+
+// sendVerification stores a new verification of the address of the user and mails its link
+func (h *UserHandler) sendVerification(r *http.Request, user store.User) error {
+	token, hash, err := newToken("")
+	if err != nil {
+		return err
+	}
+	if err := h.store.CreateEmailVerification(r.Context(), &store.EmailVerification{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Email:     user.Email,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	}); err != nil {
+		return err
+	}
+
+	return h.mailer.Send(r.Context(), mail.Message{
+		To:      user.Email,
+		Subject: "Verify your goreddit email address",
+		Body:    fmt.Sprintf(emailVerificationMail, user.Username, h.link(r, "/email/verify/"+token)),
+	})
+}
+
+// verificationWait is how long the user has to wait before asking for another link, zero when the user can ask now
+func (h *UserHandler) verificationWait(ctx context.Context, userID uuid.UUID) (time.Duration, error) {
+	v, err := h.store.LastEmailVerification(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	wait := time.Until(v.CreatedAt.Add(emailVerificationInterval))
+	if wait <= 0 {
+		return 0, nil
+	}
+	return wait.Truncate(time.Second) + time.Second, nil
+}
+
+func (h *UserHandler) EmailView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		// Change is the form to change the address, it starts with the current one
+		Change EmailForm
+		// Wait is how long before the user can ask for another link
+		Wait time.Duration
+		CSRF template.HTML
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/user_email.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		wait, err := h.verificationWait(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		sd := GetSessionData(h.sessions, r.Context())
+		change, ok := sd.Form.(EmailForm)
+		if !ok {
+			change = EmailForm{Email: user.Email}
+		}
+		tmpl.Execute(w, data{
+			SessionData: sd,
+			Change:      change,
+			Wait:        wait,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+// SendVerification mails a link to verify the address, after changing the address of the user when it is a new one
+func (h *UserHandler) SendVerification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		form := EmailForm{
+			Email:      r.FormValue("email"),
+			EmailTaken: false,
+		}
+		if other, err := h.store.UserByEmail(r.Context(), form.Email); err == nil && other.ID != user.ID {
+			form.EmailTaken = true
+		}
+		if !form.Validate() {
+			h.sessions.Put(r.Context(), "form", form)
+			http.Redirect(w, r, r.Referer(), http.StatusFound)
+			return
+		}
+
+		changed := form.Email != user.Email
+		if !changed && user.EmailVerified {
+			h.sessions.Put(r.Context(), "flash", "Your email address is verified already.")
+			http.Redirect(w, r, "/email", http.StatusFound)
+			return
+		}
+		wait, err := h.verificationWait(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if wait > 0 {
+			h.sessions.Put(r.Context(), "flash", fmt.Sprintf("Please wait %s before asking for another link.", wait))
+			http.Redirect(w, r, "/email", http.StatusFound)
+			return
+		}
+
+		// a new address has to be verified again
+		if changed {
+			user.Email, user.EmailVerified = form.Email, false
+			if err := h.store.UpdateUser(r.Context(), &user); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := h.sendVerification(r, user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", fmt.Sprintf("We mailed a link to verify your address to %s.", user.Email))
+		http.Redirect(w, r, "/email", http.StatusFound)
+	}
+}
+
+// VerifyEmail follows the mailed link, it verifies the address the link was sent to as long as the user still has it
+func (h *UserHandler) VerifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the token is in the url, it must not leak to the page the user goes to next
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		v, err := h.store.EmailVerificationByHash(r.Context(), hashToken(chi.URLParam(r, "token")))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var user store.User
+		if err == nil && time.Now().Before(v.ExpiresAt) {
+			if user, err = h.store.User(r.Context(), v.UserID); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		if user.ID == uuid.Nil || user.Email != v.Email {
+			h.sessions.Put(r.Context(), "flash", "This link to verify your email address is invalid or has expired.")
+			http.Redirect(w, r, "/email", http.StatusFound)
+			return
+		}
+
+		if !user.EmailVerified {
+			user.EmailVerified = true
+			if err := h.store.UpdateUser(r.Context(), &user); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		h.sessions.Put(r.Context(), "flash", "Your email address has been verified.")
+		http.Redirect(w, r, "/", http.StatusFound)
+	}
+}
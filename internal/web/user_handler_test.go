@@ -0,0 +1,104 @@
+package web
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+func TestResetPasswordEndsOtherSessions(t *testing.T) {
+	site, _, s := newOIDCSite(t)
+	ctx := context.Background()
+
+	// the user logs in twice, the reset happens in the first browser
+	here, there := newBrowser(t, site.URL), newBrowser(t, site.URL)
+	here.register("alice")
+	if path, _ := there.post("/login", "/login", url.Values{"username": {"alice"}, "password": {"password123"}}); path != "/" {
+		t.Fatalf("the second login ended on %s", path)
+	}
+	_, body := there.get("/")
+	loggedInAs(t, body, "alice")
+
+	user, err := s.UserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateToken(ctx, &store.Token{ID: uuid.New(), UserID: user.ID, Name: "cli", Scope: store.ScopeRead,
+		Prefix: "gr_test", Hash: hashToken("gr_test-token")}); err != nil {
+		t.Fatal(err)
+	}
+	token, hash, err := newToken("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreatePasswordReset(ctx, &store.PasswordReset{ID: uuid.New(), UserID: user.ID, Hash: hash,
+		ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	reset := "/password/reset/" + token
+	if path, _ := here.post(reset, reset, url.Values{"password": {"password456"}, "confirm": {"password456"}}); path != "/login" {
+		t.Fatalf("the reset ended on %s, want /login", path)
+	}
+
+	_, body = there.get("/")
+	if strings.Contains(body, `href="/logout"`) {
+		t.Error("the other session is still logged in after the reset")
+	}
+	tt, err := s.TokensByUser(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tt) != 0 {
+		t.Errorf("%d personal access tokens are left after the reset", len(tt))
+	}
+
+	// a login with the new password starts a session of the new generation
+	if path, _ := there.post("/login", "/login", url.Values{"username": {"alice"}, "password": {"password456"}}); path != "/" {
+		t.Fatalf("the login with the new password ended on %s", path)
+	}
+	_, body = there.get("/")
+	loggedInAs(t, body, "alice")
+}
+
+func TestForgotPasswordIsThrottled(t *testing.T) {
+	site, _, s := newOIDCSite(t)
+	b := newBrowser(t, site.URL)
+	b.register("alice")
+	ask := func(username string) (string, string) {
+		return b.post("/password/forgot", "/password/forgot", url.Values{"username": {username}})
+	}
+
+	// a few links go out, then the username waits
+	for i := 0; i < resetUserLimit.threshold; i++ {
+		if path, _ := ask("alice"); path != "/login" {
+			t.Fatalf("request %d ended on %s, want /login", i+1, path)
+		}
+	}
+	path, body := ask("alice")
+	if path != "/password/forgot" || !strings.Contains(body, "Too many requests to reset a password") {
+		t.Fatalf("the request past the limit ended on %s without the lockout message", path)
+	}
+
+	// the usernames without an account are limited the same, the wait tells nothing
+	for i := 0; i < resetUserLimit.threshold; i++ {
+		ask("nobody")
+	}
+	if _, body := ask("nobody"); !strings.Contains(body, "Too many requests to reset a password") {
+		t.Error("a username without an account is not limited")
+	}
+
+	// the lockout is stored, the lockouts page of the admins lists it
+	l, err := s.Lockout(context.Background(), resetUserLimit.key("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.Locked(time.Now()) {
+		t.Error("the username is not locked out in the store")
+	}
+}
@@ -0,0 +1,245 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// the headers sent with every delivery, the signature is "sha256=" followed by the hex HMAC-SHA256 of the body
+// computed with the secret of the webhook
+const (
+	webhookEventHeader     = "X-Goreddit-Event"
+	webhookDeliveryHeader  = "X-Goreddit-Delivery"
+	webhookSignatureHeader = "X-Goreddit-Signature"
+)
+
+const (
+	// webhookBatch is how many deliveries the sender claims at once
+	webhookBatch = 20
+	// webhookTimeout is how long a webhook has to answer
+	webhookTimeout = 10 * time.Second
+	// webhookMaxAttempts is how many times a delivery is attempted before it fails for good
+	webhookMaxAttempts = 10
+	// the delay before a retry doubles after every failed attempt, from webhookBackoff up to webhookMaxBackoff
+	webhookBackoff    = 30 * time.Second
+	webhookMaxBackoff = 6 * time.Hour
+)
+
+// webhookPayload is the body posted to the webhooks, Data is the content as the api returns it
+type webhookPayload struct {
+	ID        uuid.UUID          `json:"id"`
+	Event     store.WebhookEvent `json:"event"`
+	CreatedAt time.Time          `json:"created_at"`
+	Data      interface{}        `json:"data"`
+}
+
+// notifyWebhooks queues the event for the webhooks subscribed to it, id is the thread, post or comment it is about.
+// The change it reports is done already, so failures are logged rather than returned.
+func notifyWebhooks(ctx context.Context, s store.Store, event store.WebhookEvent, id uuid.UUID) {
+	if err := queueWebhooks(ctx, s, event, id); err != nil {
+		log.Printf("error queueing webhooks for %s %s: %v", event, id, err)
+	}
+}
+
+func queueWebhooks(ctx context.Context, s store.Store, event store.WebhookEvent, id uuid.UUID) error {
+	ww, err := s.Webhooks(ctx)
+	if err != nil {
+		return err
+	}
+	var subscribed []store.Webhook
+	for _, w := range ww {
+		if w.Wants(event) {
+			subscribed = append(subscribed, w)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	// the getters return the content in the trash too, so the deletions carry what was deleted
+	var data interface{}
+	switch event.ContentType() {
+	case store.ContentThread:
+		t, err := s.Thread(ctx, id)
+		if err != nil {
+			return err
+		}
+		data = newAPIThread(t)
+	case store.ContentPost:
+		p, err := s.Post(ctx, id)
+		if err != nil {
+			return err
+		}
+		data = newAPIPost(p)
+	case store.ContentComment:
+		c, err := s.Comment(ctx, id)
+		if err != nil {
+			return err
+		}
+		data = newAPIComment(c)
+	}
+	payload, err := json.Marshal(webhookPayload{
+		ID:        uuid.New(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.WithTx(ctx, func(tx store.Store) error {
+		for _, w := range subscribed {
+			if err := tx.CreateDelivery(ctx, &store.WebhookDelivery{
+				ID:            uuid.New(),
+				WebhookID:     w.ID,
+				Event:         event,
+				Payload:       payload,
+				NextAttemptAt: time.Now(),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// signPayload returns the value of the signature header of the payload
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay is how long a delivery waits after its nth failed attempt
+func retryDelay(attempts int) time.Duration {
+	d := webhookBackoff
+	for i := 1; i < attempts && d < webhookMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > webhookMaxBackoff {
+		d = webhookMaxBackoff
+	}
+	return d
+}
+
+// WebhookSender attempts the deliveries queued in the store, several senders can share a store
+type WebhookSender struct {
+	store  store.WebhookStore
+	client *http.Client
+}
+
+func NewWebhookSender(s store.WebhookStore) *WebhookSender {
+	return &WebhookSender{
+		store: s,
+		client: &http.Client{
+			Timeout: webhookTimeout,
+			// a redirect is an answer like any other, the body is not posted again somewhere else
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// Run sends the due deliveries, it checks the queue at every interval and right away while it finds full batches
+func (s *WebhookSender) Run(interval time.Duration) {
+	for {
+		n, err := s.sendDue(context.Background())
+		if err != nil {
+			log.Printf("sending webhooks: %v", err)
+		}
+		if n < webhookBatch {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// sendDue attempts a batch of due deliveries and returns how many it attempted
+func (s *WebhookSender) sendDue(ctx context.Context) (int, error) {
+	// the lease outlasts the attempts of the whole batch, the deliveries of a crashed sender are retried once it expires
+	dd, err := s.store.ClaimDeliveries(ctx, webhookBatch, webhookBatch*webhookTimeout+time.Minute)
+	if err != nil {
+		return 0, err
+	}
+	webhooks := map[uuid.UUID]store.Webhook{}
+	for i := range dd {
+		d := &dd[i]
+		w, ok := webhooks[d.WebhookID]
+		if !ok {
+			w, err = s.store.Webhook(ctx, d.WebhookID)
+			if errors.Is(err, sql.ErrNoRows) {
+				// deleted meanwhile, together with its deliveries
+				continue
+			}
+			if err != nil {
+				return i, err
+			}
+			webhooks[w.ID] = w
+		}
+
+		s.attempt(ctx, w, d)
+		if err := s.store.UpdateDelivery(ctx, d); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return i + 1, err
+		}
+	}
+	return len(dd), nil
+}
+
+// attempt posts the delivery to the webhook and records the outcome in the delivery
+func (s *WebhookSender) attempt(ctx context.Context, w store.Webhook, d *store.WebhookDelivery) {
+	d.Attempts++
+	d.LastAttemptAt = sql.NullTime{Time: time.Now(), Valid: true}
+	d.ResponseStatus, d.Error = 0, ""
+
+	err := s.post(ctx, w, d)
+	switch {
+	case err == nil:
+		d.Status = store.DeliveryDelivered
+	case d.Attempts >= webhookMaxAttempts:
+		d.Status = store.DeliveryFailed
+		d.Error = err.Error()
+	default:
+		d.Status = store.DeliveryPending
+		d.NextAttemptAt = time.Now().Add(retryDelay(d.Attempts))
+		d.Error = err.Error()
+	}
+}
+
+func (s *WebhookSender) post(ctx context.Context, w store.Webhook, d *store.WebhookDelivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "goreddit-webhooks")
+	req.Header.Set(webhookEventHeader, string(d.Event))
+	req.Header.Set(webhookDeliveryHeader, d.ID.String())
+	req.Header.Set(webhookSignatureHeader, signPayload(w.Secret, d.Payload))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	d.ResponseStatus = res.StatusCode
+
+	// keep the start of the answer, it usually tells what went wrong
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s: %s", res.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/csrf"
+	"github.com/salvovitale/go-chi-w-postgress-example/internal/db/store"
+)
+
+// deliveryLogSize is how many of the latest deliveries the page of a webhook shows
+const deliveryLogSize = 50
+
+// WebhookHandler serves the admin pages managing the webhooks
+type WebhookHandler struct {
+	store    store.Store
+	sessions *scs.SessionManager
+}
+
+// webhookEventOption is a checkbox of the event filter
+type webhookEventOption struct {
+	Event   store.WebhookEvent
+	Checked bool
+}
+
+// eventOptions checks the events of the form sent back after a validation error, or else the ones of the webhook
+func eventOptions(form interface{}, events store.WebhookEventList) []webhookEventOption {
+	checked := map[store.WebhookEvent]bool{}
+	if f, ok := form.(WebhookForm); ok {
+		for _, e := range f.Events {
+			checked[store.WebhookEvent(e)] = true
+		}
+	} else {
+		for _, e := range events {
+			checked[e] = true
+		}
+	}
+	oo := make([]webhookEventOption, len(store.WebhookEvents))
+	for i, e := range store.WebhookEvents {
+		oo[i] = webhookEventOption{Event: e, Checked: checked[e]}
+	}
+	return oo
+}
+
+func (h *WebhookHandler) listView() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Webhooks []store.Webhook
+		Events   []webhookEventOption
+		CSRF     template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/webhooks.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		ww, err := h.store.Webhooks(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sd := GetSessionData(h.sessions, r.Context())
+		tmpl.Execute(w, data{
+			SessionData: sd,
+			Webhooks:    ww,
+			Events:      eventOptions(sd.Form, nil),
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *WebhookHandler) view() http.HandlerFunc {
+	type data struct {
+		SessionData
+		Webhook    store.Webhook
+		Events     []webhookEventOption
+		Deliveries []store.WebhookDelivery
+		CSRF       template.HTML // string which is not escaped
+	}
+
+	tmpl := parseTemplates("templates/layout.html", "templates/webhook.html")
+	return func(w http.ResponseWriter, r *http.Request) {
+		wh, ok := h.webhook(w, r)
+		if !ok {
+			return
+		}
+		dd, err := h.store.DeliveriesByWebhook(r.Context(), wh.ID, deliveryLogSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sd := GetSessionData(h.sessions, r.Context())
+		tmpl.Execute(w, data{
+			SessionData: sd,
+			Webhook:     wh,
+			Events:      eventOptions(sd.Form, wh.Events),
+			Deliveries:  dd,
+			CSRF:        csrf.TemplateField(r),
+		})
+	}
+}
+
+func (h *WebhookHandler) create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form, ok := h.parseForm(w, r)
+		if !ok {
+			return
+		}
+
+		secret := form.Secret
+		if secret == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			secret = hex.EncodeToString(b)
+		}
+		wh := &store.Webhook{
+			ID:     uuid.New(),
+			URL:    form.URL,
+			Secret: secret,
+			Events: webhookEvents(form.Events),
+		}
+		if err := h.store.CreateWebhook(r.Context(), wh); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", "The webhook has been created.")
+		http.Redirect(w, r, "/admin/webhooks/"+wh.ID.String(), http.StatusFound)
+	}
+}
+
+func (h *WebhookHandler) update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wh, ok := h.webhook(w, r)
+		if !ok {
+			return
+		}
+		form, ok := h.parseForm(w, r)
+		if !ok {
+			return
+		}
+
+		wh.URL = form.URL
+		if form.Secret != "" {
+			wh.Secret = form.Secret
+		}
+		wh.Events = webhookEvents(form.Events)
+		if err := h.store.UpdateWebhook(r.Context(), &wh); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", "The webhook has been saved.")
+		http.Redirect(w, r, "/admin/webhooks/"+wh.ID.String(), http.StatusFound)
+	}
+}
+
+func (h *WebhookHandler) delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wh, ok := h.webhook(w, r)
+		if !ok {
+			return
+		}
+		if err := h.store.DeleteWebhook(r.Context(), wh.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		h.sessions.Put(r.Context(), "flash", "The webhook has been deleted.")
+		http.Redirect(w, r, "/admin/webhooks", http.StatusFound)
+	}
+}
+
+// webhook retrieves the webhook in the url
+func (h *WebhookHandler) webhook(w http.ResponseWriter, r *http.Request) (store.Webhook, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return store.Webhook{}, false
+	}
+	wh, err := h.store.Webhook(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return store.Webhook{}, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return store.Webhook{}, false
+	}
+	return wh, true
+}
+
+// parseForm validates the posted form, it sends the form back to the page when it is invalid
+func (h *WebhookHandler) parseForm(w http.ResponseWriter, r *http.Request) (WebhookForm, bool) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return WebhookForm{}, false
+	}
+	form := WebhookForm{
+		URL:    r.PostForm.Get("url"),
+		Secret: r.PostForm.Get("secret"),
+		Events: r.PostForm["events"],
+	}
+	if !form.Validate() {
+		h.sessions.Put(r.Context(), "form", form)
+		http.Redirect(w, r, r.Referer(), http.StatusFound)
+		return WebhookForm{}, false
+	}
+	return form, true
+}
+
+func webhookEvents(ee []string) store.WebhookEventList {
+	l := store.WebhookEventList{}
+	for _, e := range ee {
+		l = append(l, store.WebhookEvent(e))
+	}
+	return l
+}